@@ -3,14 +3,13 @@ package main
 import (
 	"context"
 	"fmt"
-	"go-proxy/common"
 	"go-proxy/proxyserver"
+	"go-proxy/rwutil"
 	"net"
-	"strconv"
-	"strings"
 	"sync"
 	"time"
 
+	"braces.dev/errtrace"
 	"github.com/wailsapp/wails/v3/pkg/application"
 )
 
@@ -23,69 +22,61 @@ func NewMyService(app *application.App) *MyService {
 }
 
 func (s *MyService) ServiceStartup(ctx context.Context, options application.ServiceOptions) error {
-	l1, _ := NewLocalListener(8000, &common.ProxyAuth{
-		Username: "khanh",
-		Password: "khanh",
-	}, ServerFilter{Tags: []string{"ssh"}})
-	l2, _ := NewLocalListener(8001, &common.ProxyAuth{
-		Username: "khanh",
-		Password: "khanh",
-	}, ServerFilter{Tags: []string{"http"}})
-	l3, _ := NewLocalListener(8002, &common.ProxyAuth{
-		Username: "khanh",
-		Password: "khanh",
-	}, ServerFilter{Tags: []string{"socks5"}})
-	l4, _ := NewLocalListener(9001, &common.ProxyAuth{
-		Username: "khanh",
-		Password: "khanh",
-	}, ServerFilter{IgnoreAll: true})
-	l5, _ := NewLocalListener(9002, &common.ProxyAuth{
-		Username: "khanh",
-		Password: "khanh",
-	}, ServerFilter{IgnoreAll: true})
-
-	ListenerServerManager.AddListeners([]*LocalListener{l1, l2, l3, l4, l5})
-
-	var wg sync.WaitGroup
-	wg.Go(func() {
-		ListenerServerManager.Serve()
-	})
-	<-time.After(time.Second)
-
-	ListenerServerManager.AddServers([]*proxyserver.Server{
-		proxyserver.NewServer("localhost", 2222, &common.ProxyAuth{
-			Username: "ubuntu",
-			Password: "ubuntu",
-		}),
-		proxyserver.NewServer("127.0.0.1", 9001, &common.ProxyAuth{
-			Username: "khanh",
-			Password: "khanh",
-		}),
-		proxyserver.NewServer("::1", 9002, &common.ProxyAuth{
-			Username: "khanh",
-			Password: "khanh",
-		}),
-		proxyserver.NewServer("64.137.75.244", 6164, &common.ProxyAuth{
-			Username: "fuaultwx",
-			Password: "frqbhyi7fs1a",
-		}),
-		proxyserver.NewServer("p.webshare.io", 80, &common.ProxyAuth{
-			Username: "teuuumot12z-1",
-			Password: "xjlhl0qpepassf",
-		}),
-	})
+	path, err := DefaultConfigPath()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	store := NewConfigStore(path)
+	cfg, err := store.Load()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
 
-	for _, s := range ListenerServerManager.Servers {
-		fmt.Println(s)
+	err = ListenerServerManager.RestoreConfig(cfg)
+	if err != nil {
+		return errtrace.Wrap(err)
 	}
+	ListenerServerManager.SetConfigStore(store)
+
+	usagePath, err := DefaultUsagePath()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = ListenerServerManager.SetUsageStore(NewUsageStore(usagePath))
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	go ListenerServerManager.Serve()
 
 	return nil
 }
 
+func (s *MyService) ServiceShutdown() error {
+	ListenerServerManager.Shutdown()
+
+	ListenerServerManager.mu.RLock()
+	store := ListenerServerManager.store
+	ListenerServerManager.mu.RUnlock()
+
+	if store == nil {
+		return nil
+	}
+
+	// Traffic totals and usage are only saved periodically, save the latest
+	// ones
+	ListenerServerManager.requestSave()
+	err := ListenerServerManager.saveUsage()
+	if err != nil {
+		fmt.Printf("Error saving usage history: %+v\n", err)
+	}
+	return errtrace.Wrap(store.Flush())
+}
+
 func (s *MyService) GetManager() *listenerServerManager {
-	common.DataMutex.RLock()
-	defer common.DataMutex.RUnlock()
-	return ListenerServerManager
+	return ListenerServerManager.snapshot()
 }
 
 type AppState struct {
@@ -108,91 +99,252 @@ func (s *MyService) GetAppState() (state AppState) {
 }
 
 func (s *MyService) DeleteServers(ids []string) {
-	for _, id := range ids {
-		common.DataMutex.RLock()
-		server, ok := ListenerServerManager.Servers[id]
-		common.DataMutex.RUnlock()
-
-		if ok {
-			// Server shutdown
-			server.Server.Cleanup()
-		}
+	ListenerServerManager.RemoveServers(ids)
+}
 
-		common.DataMutex.Lock()
-		delete(ListenerServerManager.Servers, id)
-		common.DataMutex.Unlock()
+// Open a listener, returns its port or the id of a Unix socket listener
+func (s *MyService) AddListener(bind ListenerBind, port int, filter ServerFilter) (int, error) {
+	l, err := ListenerServerManager.AddListener(bind, port, filter)
+	if err != nil {
+		return 0, errtrace.Wrap(err)
 	}
+	return l.Port, nil
 }
 
 func (s *MyService) DeleteListeners(ports []int) {
-	for _, port := range ports {
-		common.DataMutex.RLock()
-		listener, ok := ListenerServerManager.Listeners[port]
-		common.DataMutex.RUnlock()
+	ListenerServerManager.RemoveListeners(ports)
+}
+
+func (s *MyService) GetManagerSettings() ManagerConfig {
+	return ListenerServerManager.SnapshotConfig().Manager
+}
+
+func (s *MyService) UpdateManagerSettings(settings ManagerConfig) error {
+	return ListenerServerManager.ApplySettings(settings)
+}
+
+// Set the state of the servers matching the filter, drainTimeout only applies
+// to draining. Returns the number of matched servers.
+func (s *MyService) SetServersState(filter ServerFilter, state string, drainTimeout time.Duration) (int, error) {
+	return ListenerServerManager.SetServersState(filter, state, drainTimeout)
+}
+
+func (s *MyService) RestoreServers(ids []string) error {
+	return ListenerServerManager.RestoreServers(ids)
+}
+
+func (s *MyService) MergeDuplicateServers(sameExitIp bool) MergeDuplicatesResult {
+	return ListenerServerManager.MergeDuplicates(sameExitIp)
+}
+
+func (s *MyService) ImportProxyFile(content, sep string, skipCol, defaultPort int, skipHeader bool) (*ProxyImportReport, error) {
+	return s.ImportProxies(content, ProxyFileFormat{
+		Separator:   sep,
+		SkipColumns: skipCol,
+		DefaultPort: defaultPort,
+		SkipHeader:  skipHeader,
+	})
+}
+
+func (s *MyService) ImportProxies(content string, format ProxyFileFormat) (*ProxyImportReport, error) {
+	report := ParseProxyFile(content, format)
+	result, err := ListenerServerManager.AddServers(report.Servers())
+	report.Fleet = result
+	return report, errtrace.Wrap(err)
+}
+
+// Parse without importing, to preview the result
+func (s *MyService) PreviewProxyImport(content string, format ProxyFileFormat) *ProxyImportReport {
+	return ParseProxyFile(content, format)
+}
+
+func (s *MyService) ParseProxyLine(proxyStr, sep string, skip int, defaultPort int) (*proxyserver.Server, error) {
+	return ParseProxyLine(proxyStr, ProxyFileFormat{
+		Separator:   sep,
+		SkipColumns: skip,
+		DefaultPort: defaultPort,
+	})
+}
+
+func (s *MyService) SetListenerRules(port int, rules []RoutingRule) error {
+	return ListenerServerManager.SetListenerRules(port, rules)
+}
+
+// Parse a rule list and append the rules to the listener, or replace the
+// current rules. Lines that cannot be parsed are reported and skipped.
+func (s *MyService) ImportListenerRules(port int, content, action string, filter ServerFilter, replace bool) (RuleImportResult, error) {
+	return ListenerServerManager.ImportListenerRules(port, content, action, filter, replace)
+}
+
+func (s *MyService) ExportServers(opts ProxyExportOptions) (string, error) {
+	return ListenerServerManager.ExportServers(opts)
+}
 
-		if ok {
-			// Stop listener
-			listener.cancel()
-		}
+func (s *MyService) ExportEndpoints(opts ProxyExportOptions) (string, error) {
+	return ListenerServerManager.ExportEndpoints(getLocalIp(), opts)
+}
 
-		common.DataMutex.Lock()
-		delete(ListenerServerManager.Listeners, port)
-		common.DataMutex.Unlock()
+func (s *MyService) AddSubscription(name, source string, format ProxyFileFormat, refreshInterval time.Duration, tags []string) (*Subscription, error) {
+	sub := NewSubscription(name, source, format, refreshInterval, tags)
+	err := ListenerServerManager.AddSubscription(sub)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
 	}
+
+	go ListenerServerManager.SyncSubscription(sub.Id)
+	return sub, nil
 }
 
-func (s *MyService) ImportProxyFile(content, sep string, skipCol, defaultPort int, skipHeader bool) error {
-	content = strings.TrimSpace(content)
-	lines := strings.Split(content, "\n")
-	if skipHeader {
-		lines = lines[1:]
+func (s *MyService) UpdateSubscription(sub Subscription) error {
+	err := sub.Validate()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	ListenerServerManager.mu.Lock()
+	current, ok := ListenerServerManager.Subscriptions[sub.Id]
+	if ok {
+		current.Name = sub.Name
+		current.Source = sub.Source
+		current.Format = sub.Format
+		current.RefreshInterval = sub.RefreshInterval
+		current.Tags = sub.Tags
 	}
+	ListenerServerManager.mu.Unlock()
 
-	servers := make([]*proxyserver.Server, 0, len(lines))
-	for _, line := range lines {
-		s := s.ParseProxyLine(line, sep, skipCol, defaultPort)
-		if s != nil {
-			servers = append(servers, s)
-		}
+	if !ok {
+		return errtrace.Errorf("Subscription %s not found", sub.Id)
 	}
 
-	return ListenerServerManager.AddServers(servers)
+	Events.Publish(EVENT_SubscriptionChanged, sub.Id, SubscriptionEvent{sub.Id})
+	ListenerServerManager.requestSave()
+	return nil
+}
+
+func (s *MyService) DeleteSubscriptions(ids []string, removeServers bool) {
+	ListenerServerManager.RemoveSubscriptions(ids, removeServers)
 }
 
-func (s *MyService) ParseProxyLine(proxyStr, sep string, skip int, defaultPort int) *proxyserver.Server {
-	parts := strings.Split(proxyStr, sep)
-	parts = parts[skip:]
+func (s *MyService) SyncSubscription(id string) (SubscriptionSyncResult, error) {
+	return ListenerServerManager.SyncSubscription(id)
+}
 
-	host := ""
-	port := 0
-	var auth *common.ProxyAuth = nil
+// Current traffic totals of the manager, every listener and every server
+func (s *MyService) GetTrafficTotals() StatsTickEvent {
+	return ListenerServerManager.TrafficTotals()
+}
+
+// Traffic samples of the whole manager ("total"), a listener ("listener", id
+// is the port), a server ("server") or a listener user ("user", id is
+// "port/username") since the given time
+func (s *MyService) GetTrafficHistory(scope, id string, since time.Time) ([]TrafficSample, error) {
+	return ListenerServerManager.TrafficHistory(scope, id, since)
+}
 
-	for i, p := range parts {
-		switch i {
-		case 0:
-			host = p
-		case 1:
-			prt, err := strconv.Atoi(p)
-			if err == nil {
-				port = prt
-			}
-		case 2:
-			auth = &common.ProxyAuth{}
-			auth.Username = p
-		case 3:
-			auth.Password = p
-		}
+func (s *MyService) GetConnections(filter ConnectionFilter) []ActiveConnection {
+	return ListenerServerManager.ListConnections(filter)
+}
+
+func (s *MyService) KillConnection(id uint64) error {
+	return ListenerServerManager.KillConnection(id)
+}
+
+// Close every open tunnel matching the filter, e.g. all tunnels through a
+// server
+func (s *MyService) KillConnections(filter ConnectionFilter) int {
+	return ListenerServerManager.KillConnections(filter)
+}
+
+// Servers ("server") or destination hosts ("destination") using the most
+// traffic within the last "hour", "day" or "month"
+func (s *MyService) GetTopUsage(kind, window string, n int) ([]UsageEntry, error) {
+	return ListenerServerManager.TopUsage(kind, window, n)
+}
+
+// Limit the listener and its users by username, 0 means no limit. Open
+// tunnels follow the new limits.
+func (s *MyService) SetListenerRateLimits(port int, limit RateLimit, users map[string]RateLimit) error {
+	return ListenerServerManager.SetListenerRateLimits(port, limit, users)
+}
+
+func (s *MyService) SetServersRateLimit(ids []string, limit RateLimit) {
+	ListenerServerManager.SetServersRateLimit(ids, limit)
+}
+
+// Set the data quota of the listener and of its users by username, a zero
+// limit removes the quota
+func (s *MyService) SetListenerQuotas(port int, quota DataQuota, users map[string]DataQuota) error {
+	return ListenerServerManager.SetListenerQuotas(port, quota, users)
+}
+
+// Quota usage of the listener, under an empty username, and of its users
+func (s *MyService) GetListenerQuotas(port int) (map[string]QuotaStatus, error) {
+	l, err := ListenerServerManager.listener(port)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
 	}
+	return l.QuotaStatus(), nil
+}
 
-	if port == 0 {
-		port = defaultPort
+func (s *MyService) ResetListenerQuota(port int, user string) error {
+	l, err := ListenerServerManager.listener(port)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = l.ResetQuota(user)
+	if err != nil {
+		return errtrace.Wrap(err)
 	}
 
-	return proxyserver.NewServer(host, port, auth)
+	ListenerServerManager.requestSave()
+	return nil
+}
+
+// Replace the users of the listener, without users it accepts clients
+// without auth
+func (s *MyService) SetListenerUsers(port int, users []ListenerUser) error {
+	return ListenerServerManager.SetListenerUsers(port, users)
+}
+
+// Set which parameters clients may append to their username to pick servers,
+// e.g. alice-country-us-session-x1-ttl-10m
+func (s *MyService) SetListenerUsernameParams(port int, params UsernameParamsConfig) error {
+	return ListenerServerManager.SetListenerUsernameParams(port, params)
+}
+
+// Set the CIDRs allowed and denied to connect to the listener
+func (s *MyService) SetListenerAcl(port int, acl ListenerAcl) error {
+	return ListenerServerManager.SetListenerAcl(port, acl)
+}
+
+func (s *MyService) GetListenerUsers(port int) ([]ListenerUserStatus, error) {
+	return ListenerServerManager.ListenerUsers(port)
+}
+
+func (s *MyService) SetListenerConnectionLimits(port int, limits ConnectionLimits) error {
+	return ListenerServerManager.SetListenerConnectionLimits(port, limits)
+}
+
+func (s *MyService) SetListenerTimeouts(port int, timeouts rwutil.TunnelOptions) error {
+	return ListenerServerManager.SetListenerTimeouts(port, timeouts)
 }
 
-func (s *MyService) RecheckServer(id string) {
-	ListenerServerManager.Servers[id].checkServer()
+func (s *MyService) GetListenerStatus(port int) (ListenerStatus, error) {
+	return ListenerServerManager.ListenerStatus(port)
+}
+
+func (s *MyService) RecheckServer(id string) error {
+	ListenerServerManager.mu.RLock()
+	server, ok := ListenerServerManager.Servers[id]
+	ListenerServerManager.mu.RUnlock()
+
+	if !ok {
+		return errtrace.Errorf("Server %s not found", id)
+	}
+
+	server.checkServer()
+	return nil
 }
 
 func getLocalIp() string {
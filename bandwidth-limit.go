@@ -0,0 +1,191 @@
+package main
+
+import (
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+// Largest read or write a limited tunnel makes at once, so tunnels sharing a
+// limit take turns in small steps
+const rateLimitChunk = 16 * 1024
+
+type RateLimit struct {
+	// Bytes per second from clients, 0 for no limit
+	Upload uint64
+	// Bytes per second to clients, 0 for no limit
+	Download uint64
+}
+
+// Token bucket holding up to one second of traffic. Tokens can go negative:
+// callers reserve bytes and wait out the debt, so reservations are served in
+// order and concurrent tunnels get a fair share.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+func (b *tokenBucket) setRate(rate uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	b.rate = float64(rate)
+	b.tokens = min(b.tokens, b.rate)
+}
+
+// Take n bytes from the bucket, returns how long to wait before using them
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rate == 0 {
+		return 0
+	}
+
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) limited() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate > 0
+}
+
+type bandwidthLimiter struct {
+	upload   tokenBucket
+	download tokenBucket
+}
+
+func newBandwidthLimiter(limit RateLimit) *bandwidthLimiter {
+	limiter := &bandwidthLimiter{}
+	limiter.set(limit)
+	return limiter
+}
+
+// Change the limit in place, tunnels already using the limiter follow it
+func (l *bandwidthLimiter) set(limit RateLimit) {
+	l.upload.setRate(limit.Upload)
+	l.download.setRate(limit.Download)
+}
+
+func (l *bandwidthLimiter) limited() bool {
+	return l.upload.limited() || l.download.limited()
+}
+
+// Reserve the bytes from every limiter and wait for the slowest, so stacked
+// limits all hold
+func waitBandwidth(limiters []*bandwidthLimiter, upload, download int) {
+	var wait time.Duration
+	for _, l := range limiters {
+		if upload > 0 {
+			wait = max(wait, l.upload.reserve(upload))
+		}
+		if download > 0 {
+			wait = max(wait, l.download.reserve(download))
+		}
+	}
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Limiters of a tunnel with a rate set: the listener, the authenticated user
+// and the server. Empty for unlimited tunnels, which then copy in full
+// chunks.
+func (l *LocalListener) tunnelLimiters(user string, s *ManagedProxyServer) []*bandwidthLimiter {
+	l.mu.RLock()
+	candidates := []*bandwidthLimiter{l.limiter}
+	if userLimiter, ok := l.userLimiters[user]; ok {
+		candidates = append(candidates, userLimiter)
+	}
+	l.mu.RUnlock()
+	candidates = append(candidates, s.limiter)
+
+	limiters := []*bandwidthLimiter{}
+	for _, limiter := range candidates {
+		if limiter.limited() {
+			limiters = append(limiters, limiter)
+		}
+	}
+	return limiters
+}
+
+// Set the limit of the whole listener and of its users by username. Open
+// tunnels follow changes of the limits they started with without
+// reconnecting, limits set later apply to new tunnels.
+func (l *LocalListener) SetRateLimits(limit RateLimit, users map[string]RateLimit) {
+	l.mu.Lock()
+	l.RateLimit = limit
+	l.UserRateLimits = maps.Clone(users)
+	l.limiter.set(limit)
+
+	for user, userLimiter := range l.userLimiters {
+		if _, ok := users[user]; !ok {
+			userLimiter.set(RateLimit{})
+			delete(l.userLimiters, user)
+		}
+	}
+	for user, userLimit := range users {
+		if userLimiter, ok := l.userLimiters[user]; ok {
+			userLimiter.set(userLimit)
+		} else {
+			l.userLimiters[user] = newBandwidthLimiter(userLimit)
+		}
+	}
+	l.mu.Unlock()
+
+	Events.Publish(EVENT_ListenerChanged, strconv.Itoa(l.Port), ListenerEvent{l.Port})
+}
+
+func (s *ManagedProxyServer) SetRateLimit(limit RateLimit) {
+	s.mu.Lock()
+	s.RateLimit = limit
+	s.mu.Unlock()
+
+	s.limiter.set(limit)
+	Events.Publish(EVENT_ServerChanged, s.Server.Id, ServerEvent{s.Server.Id})
+}
+
+func (m *listenerServerManager) SetListenerRateLimits(port int, limit RateLimit, users map[string]RateLimit) error {
+	l, err := m.listener(port)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	l.SetRateLimits(limit, users)
+	m.requestSave()
+	return nil
+}
+
+func (m *listenerServerManager) SetServersRateLimit(ids []string, limit RateLimit) {
+	m.mu.RLock()
+	servers := []*ManagedProxyServer{}
+	for _, id := range ids {
+		if s, ok := m.Servers[id]; ok {
+			servers = append(servers, s)
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, s := range servers {
+		s.SetRateLimit(limit)
+	}
+	m.requestSave()
+}
@@ -5,20 +5,27 @@ import (
 	"encoding/base64"
 	"go-proxy/binary"
 	"net/netip"
-	"strings"
 	"sync"
 	"time"
 
 	"braces.dev/errtrace"
 	"github.com/oschwald/maxminddb-golang/v2"
-	"github.com/wailsapp/wails/v3/pkg/application"
 )
 
 const (
 	IP_CHECK_HOST = "api.ipify.org"
 )
 
-var ip2countryDb *maxminddb.Reader
+// Opened on first lookup, connections may look up concurrently
+var ip2countryDb = sync.OnceValues(func() (*maxminddb.Reader, error) {
+	file, err := binary.BinaryFS.ReadFile("files/ip-to-country.mmdb")
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	db, err := maxminddb.OpenBytes(file)
+	return db, errtrace.Wrap(err)
+})
 
 func RunWithTimeout[T any](f func() *T, deadline time.Time) (*T, error) {
 	ctx, stop := context.WithDeadline(context.Background(), deadline)
@@ -52,37 +59,22 @@ func (a *ProxyAuth) Base64() string {
 	return base64.StdEncoding.EncodeToString([]byte(a.String()))
 }
 
-func (a *ProxyAuth) VerifyBasic(basic string) bool {
-	basic = strings.ToLower(basic)
-	basic = strings.TrimPrefix(basic, "basic")
-	basic = strings.TrimLeft(basic, " ")
-
-	return basic == a.Base64()
-}
-
 func GetIpCountry(ip netip.Addr) (string, error) {
-	if ip2countryDb == nil {
-		file, err := binary.BinaryFS.ReadFile("files/ip-to-country.mmdb")
-		if err != nil {
-			return "", errtrace.Wrap(err)
-		}
-
-		ip2countryDb, err = maxminddb.OpenBytes(file)
-		if err != nil {
-			return "", errtrace.Wrap(err)
-		}
+	db, err := ip2countryDb()
+	if err != nil {
+		return "", errtrace.Wrap(err)
 	}
 
 	var res struct {
 		CountryCode string `maxminddb:"country_code"`
 	}
-	lookup := ip2countryDb.Lookup(ip)
+	lookup := db.Lookup(ip)
 
 	if !lookup.Found() {
 		return "", errtrace.Errorf("Country code not found")
 	}
 
-	err := lookup.Decode(&res)
+	err = lookup.Decode(&res)
 	if err != nil {
 		return "", errtrace.Wrap(err)
 	}
@@ -94,14 +86,3 @@ func GetIpCountry(ip netip.Addr) (string, error) {
 
 	return code, nil
 }
-
-type GlobalDataMutext struct {
-	sync.RWMutex
-}
-
-func (m *GlobalDataMutext) Unlock() {
-	m.RWMutex.Unlock()
-	application.Get().Event.Emit("goproxy:data-changed")
-}
-
-var DataMutex GlobalDataMutext
@@ -0,0 +1,758 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-proxy/common"
+	"go-proxy/proxyserver"
+	"go-proxy/rwutil"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	CONFIG_VERSION   = 10
+	CONFIG_FILE_NAME = "config.json"
+
+	configSaveDelay = 500 * time.Millisecond
+)
+
+type AppConfig struct {
+	Version         int
+	Manager         ManagerConfig
+	Listeners       []ListenerConfig
+	Servers         []ServerConfig
+	ArchivedServers []ServerConfig
+	Subscriptions   []Subscription
+
+	// Bytes through every listener since the first start
+	Traffic TrafficStat
+}
+
+type ManagerConfig struct {
+	ServerRecheckInterval time.Duration
+	DuplicatePolicy       string
+	Lifecycle             LifecycleConfig
+	Recheck               RecheckConfig
+	// How long removed listeners wait for open connections before closing
+	// them
+	DrainTimeout time.Duration
+}
+
+type ListenerConfig struct {
+	// Reassigned for Unix socket listeners
+	Port             int
+	Bind             ListenerBind
+	Users            []ListenerUser
+	UsernameParams   UsernameParamsConfig
+	Acl              ListenerAcl
+	ConnectionLimits ConnectionLimits
+	Timeouts         rwutil.TunnelOptions
+	Filter           ServerFilter
+	Rules            []RoutingRule
+
+	RateLimit      RateLimit
+	UserRateLimits map[string]RateLimit
+	Quota          DataQuota
+	UserQuotas     map[string]DataQuota
+	// Keyed by username, the listener quota under an empty username
+	QuotaUsage map[string]QuotaUsage
+
+	Traffic     TrafficStat
+	UserTraffic map[string]TrafficStat
+}
+
+type ServerConfig struct {
+	Id           string
+	Host         string
+	Port         int
+	Auth         *common.ProxyAuth
+	Protocols    map[string]bool
+	ProtocolHint string
+	Tags         []string
+	Country      string
+	PublicIp     string
+	Latency      time.Duration
+	LastChecked  time.Time
+
+	Lifecycle            string
+	ConsecutiveFailures  int
+	ConsecutiveSuccesses int
+	QuarantinedAt        time.Time
+
+	AdminState string
+	RateLimit  RateLimit
+
+	SubscriptionId string
+
+	Traffic TrafficStat
+}
+
+// Upgrades a raw config of version N to version N+1 in place
+type configMigration func(raw map[string]any) error
+
+var configMigrations = map[int]configMigration{
+	1: migrateConfigV1,
+	2: migrateConfigV2,
+	3: migrateConfigV3,
+	4: migrateConfigV4,
+	5: migrateConfigV5,
+	6: migrateConfigV6,
+	7: migrateConfigV7,
+	8: migrateConfigV8,
+	9: migrateConfigV9,
+}
+
+// Version 2 merges duplicate servers on import. Keep the previous behavior of
+// adding every server for existing configs.
+func migrateConfigV1(raw map[string]any) error {
+	manager, ok := raw["Manager"].(map[string]any)
+	if !ok {
+		return errtrace.Errorf("Config has no manager settings")
+	}
+
+	manager["DuplicatePolicy"] = DUPLICATE_KeepBoth
+	return nil
+}
+
+// Version 3 adds the quarantine and eviction lifecycle, zero values would
+// disable it so the defaults are filled in
+func migrateConfigV2(raw map[string]any) error {
+	manager, ok := raw["Manager"].(map[string]any)
+	if !ok {
+		return errtrace.Errorf("Config has no manager settings")
+	}
+
+	lifecycle := NewLifecycleConfig()
+	manager["Lifecycle"] = map[string]any{
+		"QuarantineAfterFailures":   lifecycle.QuarantineAfterFailures,
+		"QuarantineRecheckInterval": int64(lifecycle.QuarantineRecheckInterval),
+		"EvictAfter":                int64(lifecycle.EvictAfter),
+		"EvictAction":               lifecycle.EvictAction,
+	}
+	return nil
+}
+
+// Version 4 replaces the fixed recheck interval with the adaptive schedule,
+// zero factors are invalid so the defaults are filled in
+func migrateConfigV3(raw map[string]any) error {
+	manager, ok := raw["Manager"].(map[string]any)
+	if !ok {
+		return errtrace.Errorf("Config has no manager settings")
+	}
+
+	recheck := NewRecheckConfig()
+	manager["Recheck"] = map[string]any{
+		"ActiveFactor":            recheck.ActiveFactor,
+		"StableFactor":            recheck.StableFactor,
+		"StableAfterSuccesses":    recheck.StableAfterSuccesses,
+		"MaxBackoff":              int64(recheck.MaxBackoff),
+		"Jitter":                  recheck.Jitter,
+		"ProviderChecksPerMinute": recheck.ProviderChecksPerMinute,
+	}
+	return nil
+}
+
+// Version 5 replaces the single listener credentials with a user table
+func migrateConfigV4(raw map[string]any) error {
+	listeners, _ := raw["Listeners"].([]any)
+	for _, l := range listeners {
+		listener, ok := l.(map[string]any)
+		if !ok {
+			return errtrace.Errorf("Config has an invalid listener")
+		}
+
+		users := []any{}
+		if auth, ok := listener["Auth"].(map[string]any); ok {
+			users = append(users, map[string]any{
+				"Username": auth["Username"],
+				"Password": auth["Password"],
+				"Strategy": STRATEGY_Random,
+				"Enabled":  true,
+			})
+		}
+		listener["Users"] = users
+		delete(listener, "Auth")
+	}
+	return nil
+}
+
+// Version 6 adds bind addresses, listeners used to bind every IPv4 interface
+func migrateConfigV5(raw map[string]any) error {
+	listeners, _ := raw["Listeners"].([]any)
+	for _, l := range listeners {
+		listener, ok := l.(map[string]any)
+		if !ok {
+			return errtrace.Errorf("Config has an invalid listener")
+		}
+
+		listener["Bind"] = map[string]any{"Network": NETWORK_Tcp, "Address": "0.0.0.0", "SocketMode": 0}
+	}
+	return nil
+}
+
+// Version 7 drains removed listeners, a zero timeout would close open
+// connections right away
+func migrateConfigV6(raw map[string]any) error {
+	manager, ok := raw["Manager"].(map[string]any)
+	if !ok {
+		return errtrace.Errorf("Config has no manager settings")
+	}
+
+	manager["DrainTimeout"] = int64(defaultDrainTimeout)
+	return nil
+}
+
+// Version 8 adds connection limits, an empty backlog policy is invalid so the
+// defaults are filled in
+func migrateConfigV7(raw map[string]any) error {
+	listeners, _ := raw["Listeners"].([]any)
+	for _, l := range listeners {
+		listener, ok := l.(map[string]any)
+		if !ok {
+			return errtrace.Errorf("Config has an invalid listener")
+		}
+
+		limits := NewConnectionLimits()
+		listener["ConnectionLimits"] = map[string]any{
+			"MaxConnections":      limits.MaxConnections,
+			"MaxConnectionsPerIp": limits.MaxConnectionsPerIp,
+			"MaxConnectionRate":   limits.MaxConnectionRate,
+			"Backlog":             limits.Backlog,
+			"QueueTimeout":        int64(limits.QueueTimeout),
+		}
+	}
+	return nil
+}
+
+// Version 9 closes idle tunnels of new listeners. Existing listeners kept
+// their tunnels open until either side closed them, so they get no timeouts.
+func migrateConfigV8(raw map[string]any) error {
+	listeners, _ := raw["Listeners"].([]any)
+	for _, l := range listeners {
+		listener, ok := l.(map[string]any)
+		if !ok {
+			return errtrace.Errorf("Config has an invalid listener")
+		}
+
+		listener["Timeouts"] = map[string]any{"IdleTimeout": 0, "MaxLifetime": 0}
+	}
+	return nil
+}
+
+// Version 10 binds new 1:1 listeners to loopback. Existing ones still on every
+// interface without users were open proxies, they move to loopback as well.
+func migrateConfigV9(raw map[string]any) error {
+	listeners, _ := raw["Listeners"].([]any)
+	for _, l := range listeners {
+		listener, ok := l.(map[string]any)
+		if !ok {
+			return errtrace.Errorf("Config has an invalid listener")
+		}
+
+		filter, _ := listener["Filter"].(map[string]any)
+		ids, _ := filter["ServerIds"].(map[string]any)
+		tags, _ := filter["Tags"].([]any)
+		ignoreAll, _ := filter["IgnoreAll"].(bool)
+		if len(ids) != 1 || len(tags) != 0 || ignoreAll {
+			continue
+		}
+
+		users, _ := listener["Users"].([]any)
+		bind, _ := listener["Bind"].(map[string]any)
+		if len(users) > 0 || bind["Network"] != NETWORK_Tcp || bind["Address"] != "0.0.0.0" {
+			continue
+		}
+
+		bind["Address"] = newDedicatedListenerBind().Address
+	}
+	return nil
+}
+
+func NewAppConfig() *AppConfig {
+	return &AppConfig{
+		CONFIG_VERSION,
+		ManagerConfig{60 * time.Second, DUPLICATE_UpdateCredentials, NewLifecycleConfig(), NewRecheckConfig(), defaultDrainTimeout},
+		[]ListenerConfig{},
+		[]ServerConfig{},
+		[]ServerConfig{},
+		[]Subscription{},
+		TrafficStat{},
+	}
+}
+
+type ConfigStore struct {
+	Path string
+
+	mu          sync.Mutex
+	saveTimer   *time.Timer
+	pendingSave func() *AppConfig
+}
+
+func NewConfigStore(path string) *ConfigStore {
+	return &ConfigStore{Path: path}
+}
+
+func DefaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	return filepath.Join(dir, "go-proxy", CONFIG_FILE_NAME), nil
+}
+
+func (c *ConfigStore) Printlnf(f string, a ...any) {
+	f = fmt.Sprintf("[ConfigStore %s] ", c.Path) + f + "\n"
+	fmt.Printf(f, a...)
+}
+
+// Load the config from disk, migrating it to the current version if needed.
+// A missing file results in an empty default config.
+func (c *ConfigStore) Load() (*AppConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	content, err := os.ReadFile(c.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewAppConfig(), nil
+	}
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	raw := map[string]any{}
+	err = json.Unmarshal(content, &raw)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	// Files without a version are in the first format
+	version := 1
+	if v, ok := raw["Version"].(float64); ok {
+		version = int(v)
+	}
+
+	if version > CONFIG_VERSION {
+		return nil, errtrace.Errorf("Config version %d is newer than supported version %d", version, CONFIG_VERSION)
+	}
+
+	for ; version < CONFIG_VERSION; version++ {
+		migrate, ok := configMigrations[version]
+		if !ok {
+			return nil, errtrace.Errorf("No migration from config version %d", version)
+		}
+
+		err = migrate(raw)
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		raw["Version"] = version + 1
+	}
+
+	content, err = json.Marshal(raw)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	cfg := NewAppConfig()
+	err = json.Unmarshal(content, cfg)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	return cfg, nil
+}
+
+// Save the config atomically, a crash never leaves a partial config behind
+func (c *ConfigStore) Save(cfg *AppConfig) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return errtrace.Wrap(c.save(cfg))
+}
+
+func (c *ConfigStore) save(cfg *AppConfig) error {
+	cfg.Version = CONFIG_VERSION
+
+	content, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap(writeFileAtomic(c.Path, content))
+}
+
+// Write a file atomically: write into a temp file next to the target, sync
+// it to disk, then rename it over the previous file
+func writeFileAtomic(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	success := false
+	defer func() {
+		if !success {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	_, err = tmp.Write(content)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = tmp.Sync()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	success = true
+	return nil
+}
+
+// Schedule a save shortly after, so bursts of changes are written only once.
+// The snapshot func is called when the save actually happens.
+func (c *ConfigStore) ScheduleSave(snapshot func() *AppConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pendingSave = snapshot
+	if c.saveTimer != nil {
+		return
+	}
+
+	c.saveTimer = time.AfterFunc(configSaveDelay, func() {
+		err := c.Flush()
+		if err != nil {
+			c.Printlnf("Error saving config: %+v", err)
+		}
+	})
+}
+
+// Write the pending scheduled save immediately, if any
+func (c *ConfigStore) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.saveTimer != nil {
+		c.saveTimer.Stop()
+		c.saveTimer = nil
+	}
+
+	snapshot := c.pendingSave
+	c.pendingSave = nil
+	if snapshot == nil {
+		return nil
+	}
+
+	return errtrace.Wrap(c.save(snapshot()))
+}
+
+func (m *listenerServerManager) SetConfigStore(store *ConfigStore) {
+	m.mu.Lock()
+	m.store = store
+	m.mu.Unlock()
+}
+
+// Persist the current manager state in the background, if a store is set
+func (m *listenerServerManager) requestSave() {
+	m.mu.RLock()
+	store := m.store
+	m.mu.RUnlock()
+
+	if store == nil {
+		return
+	}
+
+	store.ScheduleSave(m.SnapshotConfig)
+}
+
+func (m *listenerServerManager) ApplySettings(settings ManagerConfig) error {
+	if settings.ServerRecheckInterval < time.Second {
+		return errtrace.Errorf("Server recheck interval must be at least 1 second")
+	}
+
+	if settings.DrainTimeout < 0 {
+		return errtrace.Errorf("Listener drain timeout cannot be negative")
+	}
+
+	err := settings.Lifecycle.Validate()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = settings.Recheck.Validate()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = m.SetDuplicatePolicy(settings.DuplicatePolicy)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	m.mu.Lock()
+	m.ServerRecheckInterval = settings.ServerRecheckInterval
+	m.Lifecycle = settings.Lifecycle
+	m.Recheck = settings.Recheck
+	m.DrainTimeout = settings.DrainTimeout
+	m.mu.Unlock()
+
+	Events.Publish(EVENT_SettingsChanged, "", settings)
+
+	m.requestSave()
+	return nil
+}
+
+func (m *listenerServerManager) SnapshotConfig() *AppConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cfg := NewAppConfig()
+	cfg.Manager.ServerRecheckInterval = m.ServerRecheckInterval
+	cfg.Manager.DuplicatePolicy = m.DuplicatePolicy
+	cfg.Manager.Lifecycle = m.Lifecycle
+	cfg.Manager.Recheck = m.Recheck
+	cfg.Manager.DrainTimeout = m.DrainTimeout
+	cfg.Traffic = m.traffic.load()
+
+	for _, l := range m.Listeners {
+		cfg.Listeners = append(cfg.Listeners, newListenerConfig(l.Listener))
+	}
+
+	for _, s := range m.Servers {
+		cfg.Servers = append(cfg.Servers, newServerConfig(s))
+	}
+
+	for _, s := range m.ArchivedServers {
+		cfg.ArchivedServers = append(cfg.ArchivedServers, newServerConfig(s))
+	}
+
+	for _, sub := range m.Subscriptions {
+		cfg.Subscriptions = append(cfg.Subscriptions, *sub)
+	}
+
+	slices.SortFunc(cfg.Listeners, func(a, b ListenerConfig) int { return a.Port - b.Port })
+	slices.SortFunc(cfg.Servers, func(a, b ServerConfig) int { return strings.Compare(a.Id, b.Id) })
+	slices.SortFunc(cfg.ArchivedServers, func(a, b ServerConfig) int { return strings.Compare(a.Id, b.Id) })
+	slices.SortFunc(cfg.Subscriptions, func(a, b Subscription) int { return strings.Compare(a.Id, b.Id) })
+
+	return cfg
+}
+
+// Restore listeners and servers from a loaded config. Listeners that cannot
+// bind their port anymore are skipped, servers without a dedicated listener
+// get a new one.
+func (m *listenerServerManager) RestoreConfig(cfg *AppConfig) error {
+	err := m.ApplySettings(cfg.Manager)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	m.traffic.restore(cfg.Traffic)
+
+	m.mu.Lock()
+	for _, sub := range cfg.Subscriptions {
+		m.Subscriptions[sub.Id] = &sub
+	}
+	m.mu.Unlock()
+
+	listeners := make([]*LocalListener, 0, len(cfg.Listeners))
+	for _, lc := range cfg.Listeners {
+		port := lc.Port
+		if lc.Bind.Network == NETWORK_Unix {
+			port = 0
+		}
+
+		l, err := NewLocalListener(lc.Bind, port, lc.Filter)
+		if err != nil {
+			fmt.Printf("Cannot restore listener at %s port %d: %+v\n", lc.Bind.Address, lc.Port, err)
+			continue
+		}
+
+		err = l.SetUsers(lc.Users)
+		if err == nil {
+			err = l.SetAcl(lc.Acl)
+		}
+		if err != nil {
+			// Without its users or ACL the listener would accept anyone
+			l.Printlnf("Cannot restore access control: %+v", err)
+			l.Listener.Close()
+			continue
+		}
+		l.restoreUserTraffic(lc.UserTraffic)
+
+		err = l.SetConnectionLimits(lc.ConnectionLimits)
+		if err != nil {
+			l.Printlnf("Cannot restore connection limits: %+v", err)
+		}
+		err = l.SetTimeouts(lc.Timeouts)
+		if err != nil {
+			l.Printlnf("Cannot restore tunnel timeouts: %+v", err)
+		}
+
+		err = l.SetUsernameParams(lc.UsernameParams)
+		if err != nil {
+			l.Printlnf("Cannot restore username parameters: %+v", err)
+		}
+
+		err = l.SetRules(lc.Rules)
+		if err != nil {
+			l.Printlnf("Cannot restore routing rules: %+v", err)
+		}
+		l.SetRateLimits(lc.RateLimit, lc.UserRateLimits)
+		err = l.SetQuotas(lc.Quota, lc.UserQuotas)
+		if err != nil {
+			l.Printlnf("Cannot restore data quotas: %+v", err)
+		}
+		l.restoreQuotaUsage(lc.QuotaUsage)
+		l.traffic.restore(lc.Traffic)
+		listeners = append(listeners, l)
+	}
+	m.AddListeners(listeners)
+
+	m.mu.Lock()
+	for _, sc := range cfg.ArchivedServers {
+		m.ArchivedServers[sc.Id] = sc.restore()
+	}
+	m.mu.Unlock()
+
+	defer m.refreshSelection()
+
+	for _, sc := range cfg.Servers {
+		s := sc.restore()
+		m.mu.Lock()
+		m.Servers[sc.Id] = s
+		m.mu.Unlock()
+
+		m.scheduleRestoredCheck(s)
+
+		if _, ok := m.dedicatedListenerPort(sc.Id); !ok {
+			err := m.addDedicatedListener(sc.Id)
+			if err != nil {
+				// The server is usable without it, the others still get theirs
+				s.Server.Printlnf("Cannot restore 1:1 listener: %+v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func newListenerConfig(l *LocalListener) ListenerConfig {
+	quotaUsage := l.quotaUsage()
+	users := l.userList()
+	userTraffic := l.userTrafficTotals()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return ListenerConfig{
+		l.Port,
+		l.Bind,
+		users,
+		l.UsernameParams,
+		l.Acl,
+		l.ConnectionLimits,
+		l.Timeouts,
+		l.Filter,
+		l.Rules,
+		l.RateLimit,
+		maps.Clone(l.UserRateLimits),
+		l.Quota,
+		maps.Clone(l.UserQuotas),
+		quotaUsage,
+		l.traffic.load(),
+		userTraffic,
+	}
+}
+
+func newServerConfig(s *ManagedProxyServer) ServerConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.Server.RLock()
+	defer s.Server.RUnlock()
+
+	return ServerConfig{
+		s.Server.Id,
+		s.Server.Host,
+		s.Server.Port,
+		s.Server.Auth,
+		maps.Clone(s.Server.Protocols),
+		s.Server.ProtocolHint,
+		s.tagList(),
+		s.Country,
+		s.Server.PublicIp,
+		s.Server.Latency,
+		s.Server.LastChecked,
+		s.Lifecycle,
+		s.ConsecutiveFailures,
+		s.ConsecutiveSuccesses,
+		s.QuarantinedAt,
+		s.AdminState,
+		s.RateLimit,
+		s.SubscriptionId,
+		s.traffic.load(),
+	}
+}
+
+func (sc ServerConfig) restore() *ManagedProxyServer {
+	server := proxyserver.NewServer(sc.Host, sc.Port, sc.Auth)
+	server.Id = sc.Id
+	maps.Copy(server.Protocols, sc.Protocols)
+	server.ProtocolHint = sc.ProtocolHint
+	server.PublicIp = sc.PublicIp
+	server.Latency = sc.Latency
+	server.LastChecked = sc.LastChecked
+
+	managedServer := NewManagedProxyServer(server)
+	managedServer.Country = sc.Country
+	managedServer.SubscriptionId = sc.SubscriptionId
+	managedServer.ConsecutiveFailures = sc.ConsecutiveFailures
+	managedServer.ConsecutiveSuccesses = sc.ConsecutiveSuccesses
+	managedServer.QuarantinedAt = sc.QuarantinedAt
+	managedServer.traffic.restore(sc.Traffic)
+	managedServer.RateLimit = sc.RateLimit
+	managedServer.limiter.set(sc.RateLimit)
+	if sc.Lifecycle != "" {
+		managedServer.Lifecycle = sc.Lifecycle
+	}
+	switch sc.AdminState {
+	case ADMIN_Disabled, ADMIN_Draining:
+		// Tunnels do not survive a restart, so draining is already done
+		managedServer.AdminState = ADMIN_Disabled
+	}
+	for _, t := range sc.Tags {
+		managedServer.Tags[t] = true
+	}
+
+	return managedServer
+}
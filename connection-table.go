@@ -0,0 +1,221 @@
+package main
+
+import (
+	"cmp"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	INBOUND_Http        = "http"
+	INBOUND_HttpConnect = "http-connect"
+	INBOUND_Socks5      = "socks5"
+)
+
+// Snapshot of an open tunnel
+type ActiveConnection struct {
+	Id       uint64
+	Port     int
+	Protocol string
+	User     string
+	Client   string
+	Process  string
+	Pid      int32
+	Target   string
+	ServerId string
+	Started  time.Time
+	Traffic  TrafficStat
+}
+
+// Zero fields match every connection. Client, Target and Process match by
+// substring.
+type ConnectionFilter struct {
+	Ids      []uint64
+	Port     int
+	User     string
+	ServerId string
+	Client   string
+	Target   string
+	Process  string
+}
+
+type trackedConnection struct {
+	info   ActiveConnection
+	conn   *IncomingConnection
+	remote io.Closer
+	// Traffic of the client connection before the tunnel opened, as HTTP
+	// clients may send requests through several upstreams in turn
+	base TrafficStat
+
+	// Traffic already added to the usage stats, guarded by their lock
+	accounted TrafficStat
+}
+
+// Close both ends, which ends the tunnel copy loops
+func (c *trackedConnection) kill() {
+	c.conn.Close()
+	c.remote.Close()
+}
+
+func (c *trackedConnection) traffic() TrafficStat {
+	total := c.conn.traffic.load()
+	return TrafficStat{total.Sent - c.base.Sent, total.Received - c.base.Received}
+}
+
+func (c *trackedConnection) snapshot() ActiveConnection {
+	info := c.info
+	info.Traffic = c.traffic()
+	return info
+}
+
+func (f ConnectionFilter) Matches(c ActiveConnection) bool {
+	if len(f.Ids) > 0 && !slices.Contains(f.Ids, c.Id) {
+		return false
+	}
+	if f.Port != 0 && f.Port != c.Port {
+		return false
+	}
+	if f.User != "" && f.User != c.User {
+		return false
+	}
+	if f.ServerId != "" && f.ServerId != c.ServerId {
+		return false
+	}
+	if !strings.Contains(c.Client, f.Client) {
+		return false
+	}
+	if !strings.Contains(c.Target, f.Target) {
+		return false
+	}
+	if !strings.Contains(strings.ToLower(c.Process), strings.ToLower(f.Process)) {
+		return false
+	}
+	return true
+}
+
+// Open tunnels of every listener
+type connectionTable struct {
+	mu    sync.RWMutex
+	conns map[uint64]*trackedConnection
+}
+
+func newConnectionTable() *connectionTable {
+	return &connectionTable{
+		sync.RWMutex{},
+		map[uint64]*trackedConnection{},
+	}
+}
+
+func (t *connectionTable) add(c *trackedConnection) {
+	t.mu.Lock()
+	t.conns[c.info.Id] = c
+	t.mu.Unlock()
+}
+
+func (t *connectionTable) remove(id uint64) {
+	t.mu.Lock()
+	delete(t.conns, id)
+	t.mu.Unlock()
+}
+
+func (t *connectionTable) matching(filter ConnectionFilter) []*trackedConnection {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	matched := []*trackedConnection{}
+	for _, c := range t.conns {
+		if filter.Matches(c.info) {
+			matched = append(matched, c)
+		}
+	}
+	return matched
+}
+
+// Open tunnels matching the filter, oldest first
+func (m *listenerServerManager) ListConnections(filter ConnectionFilter) []ActiveConnection {
+	conns := []ActiveConnection{}
+	for _, c := range m.connections.matching(filter) {
+		conns = append(conns, c.snapshot())
+	}
+
+	slices.SortFunc(conns, func(a, b ActiveConnection) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+	return conns
+}
+
+func (m *listenerServerManager) KillConnection(id uint64) error {
+	n := m.KillConnections(ConnectionFilter{Ids: []uint64{id}})
+	if n == 0 {
+		return errtrace.Errorf("Connection %d is not open", id)
+	}
+	return nil
+}
+
+// Close every open tunnel matching the filter, returns how many were closed
+func (m *listenerServerManager) KillConnections(filter ConnectionFilter) int {
+	conns := m.connections.matching(filter)
+	for _, c := range conns {
+		c.kill()
+	}
+	return len(conns)
+}
+
+var lastConnectionId atomic.Uint64
+
+// Register an established tunnel with its server and the connection table,
+// and announce it. The returned func undoes all of it once the tunnel is
+// closed.
+func (l *LocalListener) openTunnel(conn *IncomingConnection, protocol string, s *ManagedProxyServer, target string, remote io.Closer) func() {
+	info := ActiveConnection{
+		lastConnectionId.Add(1),
+		l.Port,
+		protocol,
+		conn.User,
+		conn.RemoteAddr().String(),
+		"",
+		0,
+		target,
+		s.Server.Id,
+		time.Now(),
+		TrafficStat{},
+	}
+	if conn.Process != nil {
+		info.Pid = conn.Process.Pid
+		info.Process, _ = conn.Process.Name()
+	}
+
+	base := conn.traffic.load()
+	tracked := &trackedConnection{info, conn, remote, base, base}
+	event := ConnectionEvent{info.Id, info.Port, info.ServerId, info.Client, info.Target, TrafficStat{}, ""}
+	key := strconv.FormatUint(info.Id, 10)
+
+	conn.server.Store(s)
+	quotas := l.tunnelQuotas(conn.User)
+	conn.accounting.Store(&tunnelAccounting{
+		l.userTrafficCounter(conn.User),
+		l.tunnelLimiters(conn.User, s),
+		quotas,
+		availableQuotas(quotas),
+	})
+	s.trackTunnel(remote)
+	ListenerServerManager.connections.add(tracked)
+	ListenerServerManager.usage.record(info.Started, info.ServerId, target, UsageStat{TrafficStat{}, 1, 0})
+	Events.Publish(EVENT_ConnectionOpened, key, event)
+
+	return func() {
+		ListenerServerManager.connections.remove(info.Id)
+		ListenerServerManager.usage.account(time.Now(), tracked)
+		s.untrackTunnel(remote)
+		event.Traffic = tracked.traffic()
+		event.EndReason = conn.endReason
+		Events.Publish(EVENT_ConnectionClosed, key, event)
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	QUOTA_Daily   = "daily"
+	QUOTA_Weekly  = "weekly"
+	QUOTA_Monthly = "monthly"
+	// A single allowance starting at ResetAt, only reset by hand
+	QUOTA_Fixed = "fixed"
+
+	EXHAUSTED_Block    = "block"
+	EXHAUSTED_Fallback = "fallback"
+
+	// End reason of a tunnel closed as a quota ran out
+	TUNNEL_QuotaExhausted = "quota-exhausted"
+)
+
+var quotaPeriods = []string{QUOTA_Daily, QUOTA_Weekly, QUOTA_Monthly, QUOTA_Fixed}
+
+var (
+	ErrQuotaExhausted     = errtrace.New("Listener data quota exhausted")
+	ErrUserQuotaExhausted = errtrace.New("User data quota exhausted")
+)
+
+type DataQuota struct {
+	// Bytes sent and received per period, 0 for no quota
+	Limit  uint64
+	Period string
+	// Start of a period. Periods reset at the same time of day, weekday or day
+	// of month.
+	ResetAt time.Time
+
+	OnExhausted string
+	// Servers used instead of the listener filter once exhausted, for
+	// EXHAUSTED_Fallback
+	FallbackFilter ServerFilter
+}
+
+type QuotaUsage struct {
+	PeriodStart time.Time
+	Used        uint64
+}
+
+type QuotaStatus struct {
+	Quota DataQuota
+	QuotaUsage
+	Exhausted bool
+}
+
+func (q DataQuota) Validate() error {
+	if q.Limit == 0 {
+		return nil
+	}
+
+	if q.ResetAt.IsZero() {
+		return errtrace.Errorf("Quota reset time is required")
+	}
+
+	if !slices.Contains(quotaPeriods, q.Period) {
+		return errtrace.Errorf("Unknown quota period %q, expected one of %s", q.Period, strings.Join(quotaPeriods, ", "))
+	}
+
+	switch q.OnExhausted {
+	case EXHAUSTED_Block, EXHAUSTED_Fallback:
+	default:
+		return errtrace.Errorf("Unknown quota exhausted action %q", q.OnExhausted)
+	}
+
+	return nil
+}
+
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	// Reset days past the end of a month fall on its last day
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(t.Day(), last)-1)
+}
+
+// Start of the period containing now
+func (q DataQuota) periodStart(now time.Time) time.Time {
+	var nth func(k int) time.Time
+	var approx time.Duration
+
+	switch q.Period {
+	case QUOTA_Daily:
+		nth = func(k int) time.Time { return q.ResetAt.AddDate(0, 0, k) }
+		approx = 24 * time.Hour
+	case QUOTA_Weekly:
+		nth = func(k int) time.Time { return q.ResetAt.AddDate(0, 0, 7*k) }
+		approx = 7 * 24 * time.Hour
+	case QUOTA_Monthly:
+		nth = func(k int) time.Time { return addMonths(q.ResetAt, k) }
+		approx = 30 * 24 * time.Hour
+	default:
+		return q.ResetAt
+	}
+
+	k := int(now.Sub(q.ResetAt) / approx)
+	for nth(k).After(now) {
+		k--
+	}
+	for !nth(k + 1).After(now) {
+		k++
+	}
+	return nth(k)
+}
+
+// Usage of one quota. Bytes are added without locking, the lock only guards
+// switching to a new period.
+type quotaCounter struct {
+	mu          sync.Mutex
+	quota       DataQuota
+	periodStart time.Time
+	used        atomic.Uint64
+	// Copy of quota.Limit, checked on every chunk without locking
+	limit atomic.Uint64
+}
+
+func newQuotaCounter(quota DataQuota) *quotaCounter {
+	c := &quotaCounter{
+		sync.Mutex{},
+		quota,
+		quota.periodStart(time.Now()),
+		atomic.Uint64{},
+		atomic.Uint64{},
+	}
+	c.limit.Store(quota.Limit)
+	return c
+}
+
+func (c *quotaCounter) add(n int) {
+	if n > 0 {
+		c.used.Add(uint64(n))
+	}
+}
+
+// Start a new period if the current one is over
+func (c *quotaCounter) roll(now time.Time) {
+	start := c.quota.periodStart(now)
+	if !start.Equal(c.periodStart) {
+		c.periodStart = start
+		c.used.Store(0)
+	}
+}
+
+func (c *quotaCounter) status() QuotaStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.roll(time.Now())
+	used := c.used.Load()
+	return QuotaStatus{c.quota, QuotaUsage{c.periodStart, used}, used >= c.quota.Limit}
+}
+
+// Whether the quota ran out. Only a full status check, which also starts
+// a new period, confirms it.
+func (c *quotaCounter) exhausted() bool {
+	if c.used.Load() < c.limit.Load() {
+		return false
+	}
+	return c.status().Exhausted
+}
+
+func (c *quotaCounter) setQuota(quota DataQuota) {
+	c.mu.Lock()
+	c.quota = quota
+	c.limit.Store(quota.Limit)
+	c.roll(time.Now())
+	c.mu.Unlock()
+}
+
+// Continue from saved usage if it is of the current period
+func (c *quotaCounter) restore(usage QuotaUsage) {
+	c.mu.Lock()
+	c.roll(time.Now())
+	if usage.PeriodStart.Equal(c.periodStart) {
+		c.used.Store(usage.Used)
+	}
+	c.mu.Unlock()
+}
+
+func (c *quotaCounter) reset() {
+	c.mu.Lock()
+	c.used.Store(0)
+	c.mu.Unlock()
+}
+
+// Counters of the listener quota and of the user's quota, if any
+func (l *LocalListener) tunnelQuotas(user string) []*quotaCounter {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	quotas := []*quotaCounter{}
+	if l.quotaCounter != nil {
+		quotas = append(quotas, l.quotaCounter)
+	}
+	if c, ok := l.userQuotaCounters[user]; ok {
+		quotas = append(quotas, c)
+	}
+	return quotas
+}
+
+// Quotas with data left, tunnels opened while one was exhausted already
+// went to the fallback servers
+func availableQuotas(quotas []*quotaCounter) []*quotaCounter {
+	available := []*quotaCounter{}
+	for _, c := range quotas {
+		if !c.status().Exhausted {
+			available = append(available, c)
+		}
+	}
+	return available
+}
+
+// Close the client connection as a quota ran out, the tunnel ends with it
+func (c *IncomingConnection) closeExhausted() {
+	if !c.quotaExhausted.CompareAndSwap(false, true) {
+		return
+	}
+	c.Listener.Printlnf("Closing tunnel of %s: data quota exhausted", c.RemoteAddr())
+	c.Conn.Close()
+}
+
+// Check the quotas before opening a tunnel. Returns the filter to use instead
+// of the listener filter when an exhausted quota falls back to other servers.
+func (l *LocalListener) checkQuotas(user string) (*ServerFilter, error) {
+	l.mu.RLock()
+	listenerQuota := l.quotaCounter
+	userQuota := l.userQuotaCounters[user]
+	l.mu.RUnlock()
+
+	var fallback *ServerFilter
+	for _, c := range []*quotaCounter{listenerQuota, userQuota} {
+		if c == nil {
+			continue
+		}
+
+		status := c.status()
+		if !status.Exhausted {
+			continue
+		}
+
+		if status.Quota.OnExhausted == EXHAUSTED_Fallback {
+			fallback = &status.Quota.FallbackFilter
+			continue
+		}
+
+		if c == listenerQuota {
+			return nil, ErrQuotaExhausted
+		}
+		return nil, ErrUserQuotaExhausted
+	}
+	return fallback, nil
+}
+
+// Set the quota of the whole listener and of its users by username. Usage of
+// kept quotas carries over.
+func (l *LocalListener) SetQuotas(quota DataQuota, users map[string]DataQuota) error {
+	err := quota.Validate()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	for user, q := range users {
+		err := q.Validate()
+		if err != nil {
+			return errtrace.Errorf("User %s: %w", user, err)
+		}
+	}
+
+	l.mu.Lock()
+	l.Quota = quota
+	l.UserQuotas = maps.Clone(users)
+
+	if quota.Limit == 0 {
+		l.quotaCounter = nil
+	} else if l.quotaCounter == nil {
+		l.quotaCounter = newQuotaCounter(quota)
+	} else {
+		l.quotaCounter.setQuota(quota)
+	}
+
+	counters := map[string]*quotaCounter{}
+	for user, q := range users {
+		if q.Limit == 0 {
+			continue
+		}
+		if c, ok := l.userQuotaCounters[user]; ok {
+			c.setQuota(q)
+			counters[user] = c
+		} else {
+			counters[user] = newQuotaCounter(q)
+		}
+	}
+	l.userQuotaCounters = counters
+	l.mu.Unlock()
+
+	Events.Publish(EVENT_ListenerChanged, strconv.Itoa(l.Port), ListenerEvent{l.Port})
+	return nil
+}
+
+// Status of the listener quota, keyed by an empty username, and of the user
+// quotas
+func (l *LocalListener) QuotaStatus() map[string]QuotaStatus {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	statuses := map[string]QuotaStatus{}
+	if l.quotaCounter != nil {
+		statuses[""] = l.quotaCounter.status()
+	}
+	for user, c := range l.userQuotaCounters {
+		statuses[user] = c.status()
+	}
+	return statuses
+}
+
+func (l *LocalListener) quotaUsage() map[string]QuotaUsage {
+	usage := map[string]QuotaUsage{}
+	for user, status := range l.QuotaStatus() {
+		usage[user] = status.QuotaUsage
+	}
+	return usage
+}
+
+func (l *LocalListener) restoreQuotaUsage(usage map[string]QuotaUsage) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if u, ok := usage[""]; ok && l.quotaCounter != nil {
+		l.quotaCounter.restore(u)
+	}
+	for user, c := range l.userQuotaCounters {
+		if u, ok := usage[user]; ok {
+			c.restore(u)
+		}
+	}
+}
+
+// Reset the usage of the listener quota (empty user) or of a user quota
+func (l *LocalListener) ResetQuota(user string) error {
+	l.mu.RLock()
+	c := l.userQuotaCounters[user]
+	if user == "" {
+		c = l.quotaCounter
+	}
+	l.mu.RUnlock()
+
+	if c == nil {
+		return errtrace.Errorf("No quota set for user %q", user)
+	}
+
+	c.reset()
+	Events.Publish(EVENT_ListenerChanged, strconv.Itoa(l.Port), ListenerEvent{l.Port})
+	return nil
+}
+
+func (m *listenerServerManager) listener(port int) (*LocalListener, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	l, ok := m.Listeners[port]
+	if !ok {
+		return nil, errtrace.Errorf("Listener at port %d not found", port)
+	}
+	return l.Listener, nil
+}
+
+func (m *listenerServerManager) SetListenerQuotas(port int, quota DataQuota, users map[string]DataQuota) error {
+	l, err := m.listener(port)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = l.SetQuotas(quota, users)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	m.requestSave()
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v3/pkg/application"
+)
+
+const (
+	EVENT_ServerAdded         = "goproxy:server-added"
+	EVENT_ServerRemoved       = "goproxy:server-removed"
+	EVENT_ServerChanged       = "goproxy:server-changed"
+	EVENT_CheckFinished       = "goproxy:check-finished"
+	EVENT_ProtocolsChanged    = "goproxy:protocols-changed"
+	EVENT_TagsChanged         = "goproxy:tags-changed"
+	EVENT_ListenerStarted     = "goproxy:listener-started"
+	EVENT_ListenerStopped     = "goproxy:listener-stopped"
+	EVENT_ListenerChanged     = "goproxy:listener-changed"
+	EVENT_ConnectionOpened    = "goproxy:connection-opened"
+	EVENT_ConnectionClosed    = "goproxy:connection-closed"
+	EVENT_StatsTick           = "goproxy:stats-tick"
+	EVENT_SettingsChanged     = "goproxy:settings-changed"
+	EVENT_SubscriptionChanged = "goproxy:subscription-changed"
+
+	// Events of the same name and key published within this window are
+	// delivered once, with the last payload
+	eventCoalesceDelay = 100 * time.Millisecond
+)
+
+func init() {
+	application.RegisterEvent[ServerEvent](EVENT_ServerAdded)
+	application.RegisterEvent[ServerEvent](EVENT_ServerRemoved)
+	application.RegisterEvent[ServerEvent](EVENT_ServerChanged)
+	application.RegisterEvent[CheckFinishedEvent](EVENT_CheckFinished)
+	application.RegisterEvent[ProtocolsChangedEvent](EVENT_ProtocolsChanged)
+	application.RegisterEvent[TagsChangedEvent](EVENT_TagsChanged)
+	application.RegisterEvent[ListenerEvent](EVENT_ListenerStarted)
+	application.RegisterEvent[ListenerEvent](EVENT_ListenerStopped)
+	application.RegisterEvent[ListenerEvent](EVENT_ListenerChanged)
+	application.RegisterEvent[ConnectionEvent](EVENT_ConnectionOpened)
+	application.RegisterEvent[ConnectionEvent](EVENT_ConnectionClosed)
+	application.RegisterEvent[StatsTickEvent](EVENT_StatsTick)
+	application.RegisterEvent[ManagerConfig](EVENT_SettingsChanged)
+	application.RegisterEvent[SubscriptionEvent](EVENT_SubscriptionChanged)
+}
+
+type ServerEvent struct {
+	ServerId string
+}
+
+type CheckFinishedEvent struct {
+	ServerId  string
+	Alive     bool
+	Latency   time.Duration
+	Lifecycle string
+	NextCheck time.Time
+}
+
+type ProtocolsChangedEvent struct {
+	ServerId  string
+	Protocols map[string]bool
+}
+
+type TagsChangedEvent struct {
+	ServerId string
+	Tags     []string
+}
+
+type ListenerEvent struct {
+	Port int
+}
+
+type ConnectionEvent struct {
+	Id       uint64
+	Port     int
+	ServerId string
+	Client   string
+	Target   string
+	// Bytes through the connection and why it ended, set once it is closed
+	Traffic   TrafficStat
+	EndReason string
+}
+
+// Samples of the manager total, and of the listeners and servers with
+// traffic since the previous tick
+type StatsTickEvent struct {
+	Total     TrafficSample
+	Listeners map[int]TrafficSample
+	Servers   map[string]TrafficSample
+}
+
+type SubscriptionEvent struct {
+	SubscriptionId string
+}
+
+type Event struct {
+	Name    string
+	Time    time.Time
+	Payload any
+}
+
+type eventSubscriber struct {
+	names   map[string]bool
+	handler func(Event)
+}
+
+// Publishes typed events to Go subscribers and the frontend. Events are
+// coalesced by name and key, so a burst of changes to one server only reaches
+// subscribers once.
+type EventBus struct {
+	mu          sync.Mutex
+	pending     map[string]Event
+	order       []string
+	flushTimer  *time.Timer
+	subscribers map[int]eventSubscriber
+	nextId      int
+}
+
+var Events = NewEventBus()
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		sync.Mutex{},
+		map[string]Event{},
+		[]string{},
+		nil,
+		map[int]eventSubscriber{},
+		0,
+	}
+}
+
+// Queue an event for delivery. Pending events of the same name and key are
+// replaced, so the key should identify the changed server, listener, etc.
+func (b *EventBus) Publish(name, key string, payload any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := name + "\x00" + key
+	if _, ok := b.pending[id]; !ok {
+		b.order = append(b.order, id)
+	}
+	b.pending[id] = Event{name, time.Now(), payload}
+
+	if b.flushTimer == nil {
+		b.flushTimer = time.AfterFunc(eventCoalesceDelay, b.flush)
+	}
+}
+
+// Call the handler for every event with one of the names, or every event
+// when no name is given. Returns a func removing the subscription.
+func (b *EventBus) Subscribe(handler func(Event), names ...string) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextId
+	b.nextId++
+
+	set := map[string]bool{}
+	for _, n := range names {
+		set[n] = true
+	}
+	b.subscribers[id] = eventSubscriber{set, handler}
+
+	return func() {
+		b.mu.Lock()
+		delete(b.subscribers, id)
+		b.mu.Unlock()
+	}
+}
+
+// Subscribe to a single event name with its typed payload
+func SubscribeEvent[T any](b *EventBus, name string, handler func(T)) func() {
+	return b.Subscribe(func(e Event) {
+		if payload, ok := e.Payload.(T); ok {
+			handler(payload)
+		}
+	}, name)
+}
+
+func (b *EventBus) flush() {
+	b.mu.Lock()
+	events := make([]Event, 0, len(b.order))
+	for _, id := range b.order {
+		events = append(events, b.pending[id])
+	}
+	b.pending = map[string]Event{}
+	b.order = []string{}
+	b.flushTimer = nil
+	subscribers := slices.Collect(maps.Values(b.subscribers))
+	b.mu.Unlock()
+
+	app := application.Get()
+	for _, e := range events {
+		for _, sub := range subscribers {
+			if len(sub.names) == 0 || sub.names[e.Name] {
+				sub.handler(e)
+			}
+		}
+
+		if app != nil {
+			app.Event.Emit(e.Name, e.Payload)
+		}
+	}
+}
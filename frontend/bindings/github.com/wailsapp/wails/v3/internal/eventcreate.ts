@@ -6,9 +6,38 @@
 // @ts-ignore: Unused imports
 import { Create as $Create } from "@wailsio/runtime";
 
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import * as main$0 from "../../../../../go-proxy/models.js";
+
 function configure() {
     Object.freeze(Object.assign($Create.Events, {
+        "goproxy:check-finished": $$createType0,
+        "goproxy:connection-closed": $$createType1,
+        "goproxy:connection-opened": $$createType1,
+        "goproxy:listener-changed": $$createType2,
+        "goproxy:listener-started": $$createType2,
+        "goproxy:listener-stopped": $$createType2,
+        "goproxy:protocols-changed": $$createType3,
+        "goproxy:server-added": $$createType4,
+        "goproxy:server-changed": $$createType4,
+        "goproxy:server-removed": $$createType4,
+        "goproxy:settings-changed": $$createType5,
+        "goproxy:stats-tick": $$createType6,
+        "goproxy:subscription-changed": $$createType7,
+        "goproxy:tags-changed": $$createType8,
     }));
 }
 
+// Private type creation functions
+const $$createType0 = main$0.CheckFinishedEvent.createFrom;
+const $$createType1 = main$0.ConnectionEvent.createFrom;
+const $$createType2 = main$0.ListenerEvent.createFrom;
+const $$createType3 = main$0.ProtocolsChangedEvent.createFrom;
+const $$createType4 = main$0.ServerEvent.createFrom;
+const $$createType5 = main$0.ManagerConfig.createFrom;
+const $$createType6 = main$0.StatsTickEvent.createFrom;
+const $$createType7 = main$0.SubscriptionEvent.createFrom;
+const $$createType8 = main$0.TagsChangedEvent.createFrom;
+
 configure();
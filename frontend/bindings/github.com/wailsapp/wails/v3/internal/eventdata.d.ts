@@ -5,10 +5,27 @@
 // @ts-ignore: Unused imports
 import type { Events } from "@wailsio/runtime";
 
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import type * as main$0 from "../../../../../go-proxy/models.js";
+
 declare module "@wailsio/runtime" {
     namespace Events {
         interface CustomEvents {
-            "goproxy:data-changed": void;
+            "goproxy:check-finished": main$0.CheckFinishedEvent;
+            "goproxy:connection-closed": main$0.ConnectionEvent;
+            "goproxy:connection-opened": main$0.ConnectionEvent;
+            "goproxy:listener-changed": main$0.ListenerEvent;
+            "goproxy:listener-started": main$0.ListenerEvent;
+            "goproxy:listener-stopped": main$0.ListenerEvent;
+            "goproxy:protocols-changed": main$0.ProtocolsChangedEvent;
+            "goproxy:server-added": main$0.ServerEvent;
+            "goproxy:server-changed": main$0.ServerEvent;
+            "goproxy:server-removed": main$0.ServerEvent;
+            "goproxy:settings-changed": main$0.ManagerConfig;
+            "goproxy:stats-tick": main$0.StatsTickEvent;
+            "goproxy:subscription-changed": main$0.SubscriptionEvent;
+            "goproxy:tags-changed": main$0.TagsChangedEvent;
         }
     }
 }
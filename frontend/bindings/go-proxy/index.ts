@@ -7,10 +7,46 @@ export {
 };
 
 export {
+    ActiveConnection,
+    AddServersResult,
     AppState,
-    ListenerStat,
+    CheckFinishedEvent,
+    ConnectionEvent,
+    ConnectionFilter,
+    ConnectionLimits,
+    DataQuota,
+    LifecycleConfig,
+    ListenerAcl,
+    ListenerBind,
+    ListenerEvent,
+    ListenerStatus,
+    ListenerUser,
+    ListenerUserStatus,
     LocalListener,
     ManagedLocalListener,
     ManagedProxyServer,
-    ServerFilter
+    ManagerConfig,
+    MergeDuplicatesResult,
+    ProtocolsChangedEvent,
+    ProxyExportOptions,
+    ProxyFileFormat,
+    ProxyImportEntry,
+    ProxyImportReport,
+    QuotaStatus,
+    RateLimit,
+    RecheckConfig,
+    RejectStat,
+    RoutingRule,
+    RuleImportResult,
+    ServerEvent,
+    ServerFilter,
+    StatsTickEvent,
+    Subscription,
+    SubscriptionEvent,
+    SubscriptionSyncResult,
+    TagsChangedEvent,
+    TrafficSample,
+    TrafficStat,
+    UsageEntry,
+    UsernameParamsConfig
 } from "./models.js";
@@ -7,10 +7,13 @@ import { Create as $Create } from "@wailsio/runtime";
 
 // eslint-disable-next-line @typescript-eslint/ban-ts-comment
 // @ts-ignore: Unused imports
-import * as common$0 from "./common/models.js";
+import * as proxyserver$0 from "./proxyserver/models.js";
 // eslint-disable-next-line @typescript-eslint/ban-ts-comment
 // @ts-ignore: Unused imports
-import * as proxyserver$0 from "./proxyserver/models.js";
+import * as rwutil$0 from "./rwutil/models.js";
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import * as fs$0 from "../io/fs/models.js";
 // eslint-disable-next-line @typescript-eslint/ban-ts-comment
 // @ts-ignore: Unused imports
 import * as net$0 from "../net/models.js";
@@ -21,204 +24,1904 @@ import * as sync$0 from "../sync/models.js";
 // @ts-ignore: Unused imports
 import * as time$0 from "../time/models.js";
 
-export class AppState {
-    "LocalIp": string;
+/**
+ * Snapshot of an open tunnel
+ */
+export class ActiveConnection {
+    "Id": number;
+    "Port": number;
+    "Protocol": string;
+    "User": string;
+    "Client": string;
+    "Process": string;
+    "Pid": number;
+    "Target": string;
+    "ServerId": string;
+    "Started": time$0.Time;
+    "Traffic": TrafficStat;
+
+    /** Creates a new ActiveConnection instance. */
+    constructor($$source: Partial<ActiveConnection> = {}) {
+        if (!("Id" in $$source)) {
+            this["Id"] = 0;
+        }
+        if (!("Port" in $$source)) {
+            this["Port"] = 0;
+        }
+        if (!("Protocol" in $$source)) {
+            this["Protocol"] = "";
+        }
+        if (!("User" in $$source)) {
+            this["User"] = "";
+        }
+        if (!("Client" in $$source)) {
+            this["Client"] = "";
+        }
+        if (!("Process" in $$source)) {
+            this["Process"] = "";
+        }
+        if (!("Pid" in $$source)) {
+            this["Pid"] = 0;
+        }
+        if (!("Target" in $$source)) {
+            this["Target"] = "";
+        }
+        if (!("ServerId" in $$source)) {
+            this["ServerId"] = "";
+        }
+        if (!("Started" in $$source)) {
+            this["Started"] = null;
+        }
+        if (!("Traffic" in $$source)) {
+            this["Traffic"] = (new TrafficStat());
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ActiveConnection instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ActiveConnection {
+        const $$createField10_0 = $$createType0;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Traffic" in $$parsedSource) {
+            $$parsedSource["Traffic"] = $$createField10_0($$parsedSource["Traffic"]);
+        }
+        return new ActiveConnection($$parsedSource as Partial<ActiveConnection>);
+    }
+}
+
+export class AddServersResult {
+    "Added": number;
+    "Merged": number;
+    "Updated": number;
+
+    /** Creates a new AddServersResult instance. */
+    constructor($$source: Partial<AddServersResult> = {}) {
+        if (!("Added" in $$source)) {
+            this["Added"] = 0;
+        }
+        if (!("Merged" in $$source)) {
+            this["Merged"] = 0;
+        }
+        if (!("Updated" in $$source)) {
+            this["Updated"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new AddServersResult instance from a string or object.
+     */
+    static createFrom($$source: any = {}): AddServersResult {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new AddServersResult($$parsedSource as Partial<AddServersResult>);
+    }
+}
+
+export class AppState {
+    "LocalIp": string;
+
+    /** Creates a new AppState instance. */
+    constructor($$source: Partial<AppState> = {}) {
+        if (!("LocalIp" in $$source)) {
+            this["LocalIp"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new AppState instance from a string or object.
+     */
+    static createFrom($$source: any = {}): AppState {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new AppState($$parsedSource as Partial<AppState>);
+    }
+}
+
+export class CheckFinishedEvent {
+    "ServerId": string;
+    "Alive": boolean;
+    "Latency": time$0.Duration;
+    "Lifecycle": string;
+    "NextCheck": time$0.Time;
+
+    /** Creates a new CheckFinishedEvent instance. */
+    constructor($$source: Partial<CheckFinishedEvent> = {}) {
+        if (!("ServerId" in $$source)) {
+            this["ServerId"] = "";
+        }
+        if (!("Alive" in $$source)) {
+            this["Alive"] = false;
+        }
+        if (!("Latency" in $$source)) {
+            this["Latency"] = time$0.Duration.$zero;
+        }
+        if (!("Lifecycle" in $$source)) {
+            this["Lifecycle"] = "";
+        }
+        if (!("NextCheck" in $$source)) {
+            this["NextCheck"] = null;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new CheckFinishedEvent instance from a string or object.
+     */
+    static createFrom($$source: any = {}): CheckFinishedEvent {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new CheckFinishedEvent($$parsedSource as Partial<CheckFinishedEvent>);
+    }
+}
+
+export class ConnectionEvent {
+    "Id": number;
+    "Port": number;
+    "ServerId": string;
+    "Client": string;
+    "Target": string;
+
+    /**
+     * Bytes through the connection and why it ended, set once it is closed
+     */
+    "Traffic": TrafficStat;
+    "EndReason": string;
+
+    /** Creates a new ConnectionEvent instance. */
+    constructor($$source: Partial<ConnectionEvent> = {}) {
+        if (!("Id" in $$source)) {
+            this["Id"] = 0;
+        }
+        if (!("Port" in $$source)) {
+            this["Port"] = 0;
+        }
+        if (!("ServerId" in $$source)) {
+            this["ServerId"] = "";
+        }
+        if (!("Client" in $$source)) {
+            this["Client"] = "";
+        }
+        if (!("Target" in $$source)) {
+            this["Target"] = "";
+        }
+        if (!("Traffic" in $$source)) {
+            this["Traffic"] = (new TrafficStat());
+        }
+        if (!("EndReason" in $$source)) {
+            this["EndReason"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ConnectionEvent instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ConnectionEvent {
+        const $$createField5_0 = $$createType0;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Traffic" in $$parsedSource) {
+            $$parsedSource["Traffic"] = $$createField5_0($$parsedSource["Traffic"]);
+        }
+        return new ConnectionEvent($$parsedSource as Partial<ConnectionEvent>);
+    }
+}
+
+/**
+ * Zero fields match every connection. Client, Target and Process match by
+ * substring.
+ */
+export class ConnectionFilter {
+    "Ids": number[];
+    "Port": number;
+    "User": string;
+    "ServerId": string;
+    "Client": string;
+    "Target": string;
+    "Process": string;
+
+    /** Creates a new ConnectionFilter instance. */
+    constructor($$source: Partial<ConnectionFilter> = {}) {
+        if (!("Ids" in $$source)) {
+            this["Ids"] = [];
+        }
+        if (!("Port" in $$source)) {
+            this["Port"] = 0;
+        }
+        if (!("User" in $$source)) {
+            this["User"] = "";
+        }
+        if (!("ServerId" in $$source)) {
+            this["ServerId"] = "";
+        }
+        if (!("Client" in $$source)) {
+            this["Client"] = "";
+        }
+        if (!("Target" in $$source)) {
+            this["Target"] = "";
+        }
+        if (!("Process" in $$source)) {
+            this["Process"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ConnectionFilter instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ConnectionFilter {
+        const $$createField0_0 = $$createType1;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Ids" in $$parsedSource) {
+            $$parsedSource["Ids"] = $$createField0_0($$parsedSource["Ids"]);
+        }
+        return new ConnectionFilter($$parsedSource as Partial<ConnectionFilter>);
+    }
+}
+
+/**
+ * Limits on the clients of a listener, 0 for no limit. The backlog policy
+ * applies to the listener limit, clients over their IP limit or the rate
+ * limit are always rejected.
+ */
+export class ConnectionLimits {
+    "MaxConnections": number;
+    "MaxConnectionsPerIp": number;
+
+    /**
+     * New connections per second
+     */
+    "MaxConnectionRate": number;
+    "Backlog": string;
+    "QueueTimeout": time$0.Duration;
+
+    /** Creates a new ConnectionLimits instance. */
+    constructor($$source: Partial<ConnectionLimits> = {}) {
+        if (!("MaxConnections" in $$source)) {
+            this["MaxConnections"] = 0;
+        }
+        if (!("MaxConnectionsPerIp" in $$source)) {
+            this["MaxConnectionsPerIp"] = 0;
+        }
+        if (!("MaxConnectionRate" in $$source)) {
+            this["MaxConnectionRate"] = 0;
+        }
+        if (!("Backlog" in $$source)) {
+            this["Backlog"] = "";
+        }
+        if (!("QueueTimeout" in $$source)) {
+            this["QueueTimeout"] = time$0.Duration.$zero;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ConnectionLimits instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ConnectionLimits {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new ConnectionLimits($$parsedSource as Partial<ConnectionLimits>);
+    }
+}
+
+export class DataQuota {
+    /**
+     * Bytes sent and received per period, 0 for no quota
+     */
+    "Limit": number;
+    "Period": string;
+
+    /**
+     * Start of a period. Periods reset at the same time of day, weekday or day
+     * of month.
+     */
+    "ResetAt": time$0.Time;
+    "OnExhausted": string;
+
+    /**
+     * Servers used instead of the listener filter once exhausted, for
+     * EXHAUSTED_Fallback
+     */
+    "FallbackFilter": ServerFilter;
+
+    /** Creates a new DataQuota instance. */
+    constructor($$source: Partial<DataQuota> = {}) {
+        if (!("Limit" in $$source)) {
+            this["Limit"] = 0;
+        }
+        if (!("Period" in $$source)) {
+            this["Period"] = "";
+        }
+        if (!("ResetAt" in $$source)) {
+            this["ResetAt"] = null;
+        }
+        if (!("OnExhausted" in $$source)) {
+            this["OnExhausted"] = "";
+        }
+        if (!("FallbackFilter" in $$source)) {
+            this["FallbackFilter"] = (new ServerFilter());
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new DataQuota instance from a string or object.
+     */
+    static createFrom($$source: any = {}): DataQuota {
+        const $$createField4_0 = $$createType2;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("FallbackFilter" in $$parsedSource) {
+            $$parsedSource["FallbackFilter"] = $$createField4_0($$parsedSource["FallbackFilter"]);
+        }
+        return new DataQuota($$parsedSource as Partial<DataQuota>);
+    }
+}
+
+export class LifecycleConfig {
+    /**
+     * Consecutive failed checks before quarantine, 0 disables quarantine
+     */
+    "QuarantineAfterFailures": number;
+
+    /**
+     * Recheck interval of quarantined servers
+     */
+    "QuarantineRecheckInterval": time$0.Duration;
+
+    /**
+     * Time in quarantine before eviction, 0 disables eviction
+     */
+    "EvictAfter": time$0.Duration;
+    "EvictAction": string;
+
+    /** Creates a new LifecycleConfig instance. */
+    constructor($$source: Partial<LifecycleConfig> = {}) {
+        if (!("QuarantineAfterFailures" in $$source)) {
+            this["QuarantineAfterFailures"] = 0;
+        }
+        if (!("QuarantineRecheckInterval" in $$source)) {
+            this["QuarantineRecheckInterval"] = time$0.Duration.$zero;
+        }
+        if (!("EvictAfter" in $$source)) {
+            this["EvictAfter"] = time$0.Duration.$zero;
+        }
+        if (!("EvictAction" in $$source)) {
+            this["EvictAction"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new LifecycleConfig instance from a string or object.
+     */
+    static createFrom($$source: any = {}): LifecycleConfig {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new LifecycleConfig($$parsedSource as Partial<LifecycleConfig>);
+    }
+}
+
+/**
+ * Source addresses allowed to connect to a listener. Entries are CIDRs or
+ * single IPs, deny entries win over allow entries.
+ */
+export class ListenerAcl {
+    /**
+     * Empty allows every source that is not denied
+     */
+    "Allow": string[];
+    "Deny": string[];
+
+    /**
+     * Clients from allowed sources connect without credentials
+     */
+    "SkipAuthForAllowed": boolean;
+
+    /** Creates a new ListenerAcl instance. */
+    constructor($$source: Partial<ListenerAcl> = {}) {
+        if (!("Allow" in $$source)) {
+            this["Allow"] = [];
+        }
+        if (!("Deny" in $$source)) {
+            this["Deny"] = [];
+        }
+        if (!("SkipAuthForAllowed" in $$source)) {
+            this["SkipAuthForAllowed"] = false;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ListenerAcl instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ListenerAcl {
+        const $$createField0_0 = $$createType3;
+        const $$createField1_0 = $$createType3;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Allow" in $$parsedSource) {
+            $$parsedSource["Allow"] = $$createField0_0($$parsedSource["Allow"]);
+        }
+        if ("Deny" in $$parsedSource) {
+            $$parsedSource["Deny"] = $$createField1_0($$parsedSource["Deny"]);
+        }
+        return new ListenerAcl($$parsedSource as Partial<ListenerAcl>);
+    }
+}
+
+export class ListenerBind {
+    "Network": string;
+
+    /**
+     * Interface address for TCP, empty for every interface. Socket path for
+     * Unix sockets.
+     */
+    "Address": string;
+
+    /**
+     * Permissions of the Unix socket file, 0 for the default
+     */
+    "SocketMode": fs$0.FileMode;
+
+    /** Creates a new ListenerBind instance. */
+    constructor($$source: Partial<ListenerBind> = {}) {
+        if (!("Network" in $$source)) {
+            this["Network"] = "";
+        }
+        if (!("Address" in $$source)) {
+            this["Address"] = "";
+        }
+        if (!("SocketMode" in $$source)) {
+            this["SocketMode"] = fs$0.FileMode.$zero;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ListenerBind instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ListenerBind {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new ListenerBind($$parsedSource as Partial<ListenerBind>);
+    }
+}
+
+export class ListenerEvent {
+    "Port": number;
+
+    /** Creates a new ListenerEvent instance. */
+    constructor($$source: Partial<ListenerEvent> = {}) {
+        if (!("Port" in $$source)) {
+            this["Port"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ListenerEvent instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ListenerEvent {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new ListenerEvent($$parsedSource as Partial<ListenerEvent>);
+    }
+}
+
+export class ListenerStatus {
+    "State": string;
+    "Connections": number;
+
+    /**
+     * Open connections are closed at this time, zero unless draining
+     */
+    "DrainDeadline": time$0.Time;
+    "Rejected": RejectStat;
+
+    /** Creates a new ListenerStatus instance. */
+    constructor($$source: Partial<ListenerStatus> = {}) {
+        if (!("State" in $$source)) {
+            this["State"] = "";
+        }
+        if (!("Connections" in $$source)) {
+            this["Connections"] = 0;
+        }
+        if (!("DrainDeadline" in $$source)) {
+            this["DrainDeadline"] = null;
+        }
+        if (!("Rejected" in $$source)) {
+            this["Rejected"] = (new RejectStat());
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ListenerStatus instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ListenerStatus {
+        const $$createField3_0 = $$createType4;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Rejected" in $$parsedSource) {
+            $$parsedSource["Rejected"] = $$createField3_0($$parsedSource["Rejected"]);
+        }
+        return new ListenerStatus($$parsedSource as Partial<ListenerStatus>);
+    }
+}
+
+export class ListenerUser {
+    "Username": string;
+    "Password": string;
+
+    /**
+     * Servers of this user instead of the listener filter, nil to use the
+     * listener filter
+     */
+    "Filter": ServerFilter | null;
+    "Strategy": string;
+    "Enabled": boolean;
+
+    /** Creates a new ListenerUser instance. */
+    constructor($$source: Partial<ListenerUser> = {}) {
+        if (!("Username" in $$source)) {
+            this["Username"] = "";
+        }
+        if (!("Password" in $$source)) {
+            this["Password"] = "";
+        }
+        if (!("Filter" in $$source)) {
+            this["Filter"] = null;
+        }
+        if (!("Strategy" in $$source)) {
+            this["Strategy"] = "";
+        }
+        if (!("Enabled" in $$source)) {
+            this["Enabled"] = false;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ListenerUser instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ListenerUser {
+        const $$createField2_0 = $$createType5;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Filter" in $$parsedSource) {
+            $$parsedSource["Filter"] = $$createField2_0($$parsedSource["Filter"]);
+        }
+        return new ListenerUser($$parsedSource as Partial<ListenerUser>);
+    }
+}
+
+export class ListenerUserStatus {
+    "Username": string;
+    "Password": string;
+
+    /**
+     * Servers of this user instead of the listener filter, nil to use the
+     * listener filter
+     */
+    "Filter": ServerFilter | null;
+    "Strategy": string;
+    "Enabled": boolean;
+    "Traffic": TrafficStat;
+    "Connections": number;
+
+    /** Creates a new ListenerUserStatus instance. */
+    constructor($$source: Partial<ListenerUserStatus> = {}) {
+        if (!("Username" in $$source)) {
+            this["Username"] = "";
+        }
+        if (!("Password" in $$source)) {
+            this["Password"] = "";
+        }
+        if (!("Filter" in $$source)) {
+            this["Filter"] = null;
+        }
+        if (!("Strategy" in $$source)) {
+            this["Strategy"] = "";
+        }
+        if (!("Enabled" in $$source)) {
+            this["Enabled"] = false;
+        }
+        if (!("Traffic" in $$source)) {
+            this["Traffic"] = (new TrafficStat());
+        }
+        if (!("Connections" in $$source)) {
+            this["Connections"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ListenerUserStatus instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ListenerUserStatus {
+        const $$createField2_0 = $$createType5;
+        const $$createField5_0 = $$createType0;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Filter" in $$parsedSource) {
+            $$parsedSource["Filter"] = $$createField2_0($$parsedSource["Filter"]);
+        }
+        if ("Traffic" in $$parsedSource) {
+            $$parsedSource["Traffic"] = $$createField5_0($$parsedSource["Traffic"]);
+        }
+        return new ListenerUserStatus($$parsedSource as Partial<ListenerUserStatus>);
+    }
+}
+
+export class LocalListener {
+    "IsServing": boolean;
+    "State": string;
+
+    /**
+     * Negative for Unix socket listeners, which are keyed by it all the same
+     */
+    "Port": number;
+    "Bind": ListenerBind;
+    "Listener": net$0.Listener;
+    "Filter": ServerFilter;
+    "Rules": RoutingRule[];
+
+    /**
+     * Keyed by username, replaced on change. Clients must authenticate as
+     * one of them unless there are none.
+     */
+    "Users": { [_: string]: ListenerUser };
+    "UsernameParams": UsernameParamsConfig;
+    "Acl": ListenerAcl;
+
+    /**
+     * Applied to clients after the ACL, before the handshake
+     */
+    "ConnectionLimits": ConnectionLimits;
+
+    /**
+     * Of tunnels opened from now on
+     */
+    "Timeouts": rwutil$0.TunnelOptions;
+    "RateLimit": RateLimit;
+    "UserRateLimits": { [_: string]: RateLimit };
+    "Quota": DataQuota;
+    "UserQuotas": { [_: string]: DataQuota };
+
+    /** Creates a new LocalListener instance. */
+    constructor($$source: Partial<LocalListener> = {}) {
+        if (!("IsServing" in $$source)) {
+            this["IsServing"] = false;
+        }
+        if (!("State" in $$source)) {
+            this["State"] = "";
+        }
+        if (!("Port" in $$source)) {
+            this["Port"] = 0;
+        }
+        if (!("Bind" in $$source)) {
+            this["Bind"] = (new ListenerBind());
+        }
+        if (!("Listener" in $$source)) {
+            this["Listener"] = null;
+        }
+        if (!("Filter" in $$source)) {
+            this["Filter"] = (new ServerFilter());
+        }
+        if (!("Rules" in $$source)) {
+            this["Rules"] = [];
+        }
+        if (!("Users" in $$source)) {
+            this["Users"] = {};
+        }
+        if (!("UsernameParams" in $$source)) {
+            this["UsernameParams"] = (new UsernameParamsConfig());
+        }
+        if (!("Acl" in $$source)) {
+            this["Acl"] = (new ListenerAcl());
+        }
+        if (!("ConnectionLimits" in $$source)) {
+            this["ConnectionLimits"] = (new ConnectionLimits());
+        }
+        if (!("Timeouts" in $$source)) {
+            this["Timeouts"] = (new rwutil$0.TunnelOptions());
+        }
+        if (!("RateLimit" in $$source)) {
+            this["RateLimit"] = (new RateLimit());
+        }
+        if (!("UserRateLimits" in $$source)) {
+            this["UserRateLimits"] = {};
+        }
+        if (!("Quota" in $$source)) {
+            this["Quota"] = (new DataQuota());
+        }
+        if (!("UserQuotas" in $$source)) {
+            this["UserQuotas"] = {};
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new LocalListener instance from a string or object.
+     */
+    static createFrom($$source: any = {}): LocalListener {
+        const $$createField3_0 = $$createType6;
+        const $$createField5_0 = $$createType2;
+        const $$createField6_0 = $$createType8;
+        const $$createField7_0 = $$createType10;
+        const $$createField8_0 = $$createType11;
+        const $$createField9_0 = $$createType12;
+        const $$createField10_0 = $$createType13;
+        const $$createField11_0 = $$createType14;
+        const $$createField12_0 = $$createType15;
+        const $$createField13_0 = $$createType16;
+        const $$createField14_0 = $$createType17;
+        const $$createField15_0 = $$createType18;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Bind" in $$parsedSource) {
+            $$parsedSource["Bind"] = $$createField3_0($$parsedSource["Bind"]);
+        }
+        if ("Filter" in $$parsedSource) {
+            $$parsedSource["Filter"] = $$createField5_0($$parsedSource["Filter"]);
+        }
+        if ("Rules" in $$parsedSource) {
+            $$parsedSource["Rules"] = $$createField6_0($$parsedSource["Rules"]);
+        }
+        if ("Users" in $$parsedSource) {
+            $$parsedSource["Users"] = $$createField7_0($$parsedSource["Users"]);
+        }
+        if ("UsernameParams" in $$parsedSource) {
+            $$parsedSource["UsernameParams"] = $$createField8_0($$parsedSource["UsernameParams"]);
+        }
+        if ("Acl" in $$parsedSource) {
+            $$parsedSource["Acl"] = $$createField9_0($$parsedSource["Acl"]);
+        }
+        if ("ConnectionLimits" in $$parsedSource) {
+            $$parsedSource["ConnectionLimits"] = $$createField10_0($$parsedSource["ConnectionLimits"]);
+        }
+        if ("Timeouts" in $$parsedSource) {
+            $$parsedSource["Timeouts"] = $$createField11_0($$parsedSource["Timeouts"]);
+        }
+        if ("RateLimit" in $$parsedSource) {
+            $$parsedSource["RateLimit"] = $$createField12_0($$parsedSource["RateLimit"]);
+        }
+        if ("UserRateLimits" in $$parsedSource) {
+            $$parsedSource["UserRateLimits"] = $$createField13_0($$parsedSource["UserRateLimits"]);
+        }
+        if ("Quota" in $$parsedSource) {
+            $$parsedSource["Quota"] = $$createField14_0($$parsedSource["Quota"]);
+        }
+        if ("UserQuotas" in $$parsedSource) {
+            $$parsedSource["UserQuotas"] = $$createField15_0($$parsedSource["UserQuotas"]);
+        }
+        return new LocalListener($$parsedSource as Partial<LocalListener>);
+    }
+}
+
+export class ManagedLocalListener {
+    "Listener": LocalListener | null;
+
+    /** Creates a new ManagedLocalListener instance. */
+    constructor($$source: Partial<ManagedLocalListener> = {}) {
+        if (!("Listener" in $$source)) {
+            this["Listener"] = null;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ManagedLocalListener instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ManagedLocalListener {
+        const $$createField0_0 = $$createType20;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Listener" in $$parsedSource) {
+            $$parsedSource["Listener"] = $$createField0_0($$parsedSource["Listener"]);
+        }
+        return new ManagedLocalListener($$parsedSource as Partial<ManagedLocalListener>);
+    }
+}
+
+export class ManagedProxyServer {
+    "Server": proxyserver$0.Server | null;
+
+    /**
+     * Replaced on change and never modified in place, so selection snapshots
+     * can share it
+     */
+    "Tags": { [_: string]: boolean };
+    "Country": string;
+    "Lifecycle": string;
+    "ConsecutiveFailures": number;
+    "ConsecutiveSuccesses": number;
+    "QuarantinedAt": time$0.Time;
+    "NextCheck": time$0.Time;
+    "LastUsed": time$0.Time;
+    "AdminState": string;
+    "DrainDeadline": time$0.Time;
+    "ActiveTunnels": number;
+    "RateLimit": RateLimit;
+
+    /**
+     * Set when the server is owned by a subscription and synced from its source
+     */
+    "SubscriptionId": string;
+
+    /** Creates a new ManagedProxyServer instance. */
+    constructor($$source: Partial<ManagedProxyServer> = {}) {
+        if (!("Server" in $$source)) {
+            this["Server"] = null;
+        }
+        if (!("Tags" in $$source)) {
+            this["Tags"] = {};
+        }
+        if (!("Country" in $$source)) {
+            this["Country"] = "";
+        }
+        if (!("Lifecycle" in $$source)) {
+            this["Lifecycle"] = "";
+        }
+        if (!("ConsecutiveFailures" in $$source)) {
+            this["ConsecutiveFailures"] = 0;
+        }
+        if (!("ConsecutiveSuccesses" in $$source)) {
+            this["ConsecutiveSuccesses"] = 0;
+        }
+        if (!("QuarantinedAt" in $$source)) {
+            this["QuarantinedAt"] = null;
+        }
+        if (!("NextCheck" in $$source)) {
+            this["NextCheck"] = null;
+        }
+        if (!("LastUsed" in $$source)) {
+            this["LastUsed"] = null;
+        }
+        if (!("AdminState" in $$source)) {
+            this["AdminState"] = "";
+        }
+        if (!("DrainDeadline" in $$source)) {
+            this["DrainDeadline"] = null;
+        }
+        if (!("ActiveTunnels" in $$source)) {
+            this["ActiveTunnels"] = 0;
+        }
+        if (!("RateLimit" in $$source)) {
+            this["RateLimit"] = (new RateLimit());
+        }
+        if (!("SubscriptionId" in $$source)) {
+            this["SubscriptionId"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ManagedProxyServer instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ManagedProxyServer {
+        const $$createField0_0 = $$createType22;
+        const $$createField1_0 = $$createType23;
+        const $$createField12_0 = $$createType15;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Server" in $$parsedSource) {
+            $$parsedSource["Server"] = $$createField0_0($$parsedSource["Server"]);
+        }
+        if ("Tags" in $$parsedSource) {
+            $$parsedSource["Tags"] = $$createField1_0($$parsedSource["Tags"]);
+        }
+        if ("RateLimit" in $$parsedSource) {
+            $$parsedSource["RateLimit"] = $$createField12_0($$parsedSource["RateLimit"]);
+        }
+        return new ManagedProxyServer($$parsedSource as Partial<ManagedProxyServer>);
+    }
+}
+
+export class ManagerConfig {
+    "ServerRecheckInterval": time$0.Duration;
+    "DuplicatePolicy": string;
+    "Lifecycle": LifecycleConfig;
+    "Recheck": RecheckConfig;
+
+    /**
+     * How long removed listeners wait for open connections before closing
+     * them
+     */
+    "DrainTimeout": time$0.Duration;
+
+    /** Creates a new ManagerConfig instance. */
+    constructor($$source: Partial<ManagerConfig> = {}) {
+        if (!("ServerRecheckInterval" in $$source)) {
+            this["ServerRecheckInterval"] = time$0.Duration.$zero;
+        }
+        if (!("DuplicatePolicy" in $$source)) {
+            this["DuplicatePolicy"] = "";
+        }
+        if (!("Lifecycle" in $$source)) {
+            this["Lifecycle"] = (new LifecycleConfig());
+        }
+        if (!("Recheck" in $$source)) {
+            this["Recheck"] = (new RecheckConfig());
+        }
+        if (!("DrainTimeout" in $$source)) {
+            this["DrainTimeout"] = time$0.Duration.$zero;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ManagerConfig instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ManagerConfig {
+        const $$createField2_0 = $$createType24;
+        const $$createField3_0 = $$createType25;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Lifecycle" in $$parsedSource) {
+            $$parsedSource["Lifecycle"] = $$createField2_0($$parsedSource["Lifecycle"]);
+        }
+        if ("Recheck" in $$parsedSource) {
+            $$parsedSource["Recheck"] = $$createField3_0($$parsedSource["Recheck"]);
+        }
+        return new ManagerConfig($$parsedSource as Partial<ManagerConfig>);
+    }
+}
+
+export class MergeDuplicatesResult {
+    "Groups": number;
+    "Removed": number;
+
+    /** Creates a new MergeDuplicatesResult instance. */
+    constructor($$source: Partial<MergeDuplicatesResult> = {}) {
+        if (!("Groups" in $$source)) {
+            this["Groups"] = 0;
+        }
+        if (!("Removed" in $$source)) {
+            this["Removed"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new MergeDuplicatesResult instance from a string or object.
+     */
+    static createFrom($$source: any = {}): MergeDuplicatesResult {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new MergeDuplicatesResult($$parsedSource as Partial<MergeDuplicatesResult>);
+    }
+}
+
+export class ProtocolsChangedEvent {
+    "ServerId": string;
+    "Protocols": { [_: string]: boolean };
+
+    /** Creates a new ProtocolsChangedEvent instance. */
+    constructor($$source: Partial<ProtocolsChangedEvent> = {}) {
+        if (!("ServerId" in $$source)) {
+            this["ServerId"] = "";
+        }
+        if (!("Protocols" in $$source)) {
+            this["Protocols"] = {};
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ProtocolsChangedEvent instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ProtocolsChangedEvent {
+        const $$createField1_0 = $$createType23;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Protocols" in $$parsedSource) {
+            $$parsedSource["Protocols"] = $$createField1_0($$parsedSource["Protocols"]);
+        }
+        return new ProtocolsChangedEvent($$parsedSource as Partial<ProtocolsChangedEvent>);
+    }
+}
+
+export class ProxyExportOptions {
+    /**
+     * One of PROXY_FORMAT_Uri, PROXY_FORMAT_HostPortUserPass, PROXY_FORMAT_Csv,
+     * PROXY_FORMAT_Json or PROXY_FORMAT_Yaml
+     */
+    "Format": string;
+    "Filter": ServerFilter;
+
+    /**
+     * Add country, latency and public IP from the last check. host:port:user:pass
+     * has no room for it, URIs carry it in the fragment.
+     */
+    "IncludeCheckData": boolean;
+
+    /**
+     * Protocol advertised for listener endpoints, socks5 when empty
+     */
+    "EndpointProtocol": string;
+
+    /** Creates a new ProxyExportOptions instance. */
+    constructor($$source: Partial<ProxyExportOptions> = {}) {
+        if (!("Format" in $$source)) {
+            this["Format"] = "";
+        }
+        if (!("Filter" in $$source)) {
+            this["Filter"] = (new ServerFilter());
+        }
+        if (!("IncludeCheckData" in $$source)) {
+            this["IncludeCheckData"] = false;
+        }
+        if (!("EndpointProtocol" in $$source)) {
+            this["EndpointProtocol"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ProxyExportOptions instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ProxyExportOptions {
+        const $$createField1_0 = $$createType2;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Filter" in $$parsedSource) {
+            $$parsedSource["Filter"] = $$createField1_0($$parsedSource["Filter"]);
+        }
+        return new ProxyExportOptions($$parsedSource as Partial<ProxyExportOptions>);
+    }
+}
+
+export class ProxyFileFormat {
+    /**
+     * One of PROXY_FORMAT_*, empty means separated when Separator is set
+     * and autodetection otherwise
+     */
+    "Type": string;
+    "Separator": string;
+    "SkipColumns": number;
+    "DefaultPort": number;
+    "SkipHeader": boolean;
+
+    /**
+     * CSV header to FIELD_* mapping, headers matching known aliases are
+     * mapped automatically
+     */
+    "CsvColumns": { [_: string]: string };
+
+    /** Creates a new ProxyFileFormat instance. */
+    constructor($$source: Partial<ProxyFileFormat> = {}) {
+        if (!("Type" in $$source)) {
+            this["Type"] = "";
+        }
+        if (!("Separator" in $$source)) {
+            this["Separator"] = "";
+        }
+        if (!("SkipColumns" in $$source)) {
+            this["SkipColumns"] = 0;
+        }
+        if (!("DefaultPort" in $$source)) {
+            this["DefaultPort"] = 0;
+        }
+        if (!("SkipHeader" in $$source)) {
+            this["SkipHeader"] = false;
+        }
+        if (!("CsvColumns" in $$source)) {
+            this["CsvColumns"] = {};
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ProxyFileFormat instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ProxyFileFormat {
+        const $$createField5_0 = $$createType26;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("CsvColumns" in $$parsedSource) {
+            $$parsedSource["CsvColumns"] = $$createField5_0($$parsedSource["CsvColumns"]);
+        }
+        return new ProxyFileFormat($$parsedSource as Partial<ProxyFileFormat>);
+    }
+}
+
+export class ProxyImportEntry {
+    "Line": number;
+    "Raw": string;
+    "Status": string;
+    "Error": string;
+    "Server": proxyserver$0.Server | null;
+
+    /** Creates a new ProxyImportEntry instance. */
+    constructor($$source: Partial<ProxyImportEntry> = {}) {
+        if (!("Line" in $$source)) {
+            this["Line"] = 0;
+        }
+        if (!("Raw" in $$source)) {
+            this["Raw"] = "";
+        }
+        if (!("Status" in $$source)) {
+            this["Status"] = "";
+        }
+        if (!("Error" in $$source)) {
+            this["Error"] = "";
+        }
+        if (!("Server" in $$source)) {
+            this["Server"] = null;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ProxyImportEntry instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ProxyImportEntry {
+        const $$createField4_0 = $$createType22;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Server" in $$parsedSource) {
+            $$parsedSource["Server"] = $$createField4_0($$parsedSource["Server"]);
+        }
+        return new ProxyImportEntry($$parsedSource as Partial<ProxyImportEntry>);
+    }
+}
+
+export class ProxyImportReport {
+    "Format": string;
+    "Entries": ProxyImportEntry[];
+    "Parsed": number;
+    "Duplicates": number;
+    "Invalid": number;
+
+    /**
+     * Outcome of adding the parsed servers to the manager
+     */
+    "Fleet": AddServersResult;
+
+    /** Creates a new ProxyImportReport instance. */
+    constructor($$source: Partial<ProxyImportReport> = {}) {
+        if (!("Format" in $$source)) {
+            this["Format"] = "";
+        }
+        if (!("Entries" in $$source)) {
+            this["Entries"] = [];
+        }
+        if (!("Parsed" in $$source)) {
+            this["Parsed"] = 0;
+        }
+        if (!("Duplicates" in $$source)) {
+            this["Duplicates"] = 0;
+        }
+        if (!("Invalid" in $$source)) {
+            this["Invalid"] = 0;
+        }
+        if (!("Fleet" in $$source)) {
+            this["Fleet"] = (new AddServersResult());
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ProxyImportReport instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ProxyImportReport {
+        const $$createField1_0 = $$createType28;
+        const $$createField5_0 = $$createType29;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Entries" in $$parsedSource) {
+            $$parsedSource["Entries"] = $$createField1_0($$parsedSource["Entries"]);
+        }
+        if ("Fleet" in $$parsedSource) {
+            $$parsedSource["Fleet"] = $$createField5_0($$parsedSource["Fleet"]);
+        }
+        return new ProxyImportReport($$parsedSource as Partial<ProxyImportReport>);
+    }
+}
+
+export class QuotaStatus {
+    "Quota": DataQuota;
+    "PeriodStart": time$0.Time;
+    "Used": number;
+    "Exhausted": boolean;
+
+    /** Creates a new QuotaStatus instance. */
+    constructor($$source: Partial<QuotaStatus> = {}) {
+        if (!("Quota" in $$source)) {
+            this["Quota"] = (new DataQuota());
+        }
+        if (!("PeriodStart" in $$source)) {
+            this["PeriodStart"] = null;
+        }
+        if (!("Used" in $$source)) {
+            this["Used"] = 0;
+        }
+        if (!("Exhausted" in $$source)) {
+            this["Exhausted"] = false;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new QuotaStatus instance from a string or object.
+     */
+    static createFrom($$source: any = {}): QuotaStatus {
+        const $$createField0_0 = $$createType17;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Quota" in $$parsedSource) {
+            $$parsedSource["Quota"] = $$createField0_0($$parsedSource["Quota"]);
+        }
+        return new QuotaStatus($$parsedSource as Partial<QuotaStatus>);
+    }
+}
+
+export class RateLimit {
+    /**
+     * Bytes per second from clients, 0 for no limit
+     */
+    "Upload": number;
+
+    /**
+     * Bytes per second to clients, 0 for no limit
+     */
+    "Download": number;
+
+    /** Creates a new RateLimit instance. */
+    constructor($$source: Partial<RateLimit> = {}) {
+        if (!("Upload" in $$source)) {
+            this["Upload"] = 0;
+        }
+        if (!("Download" in $$source)) {
+            this["Download"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new RateLimit instance from a string or object.
+     */
+    static createFrom($$source: any = {}): RateLimit {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new RateLimit($$parsedSource as Partial<RateLimit>);
+    }
+}
+
+export class RecheckConfig {
+    /**
+     * Multiplier of ServerRecheckInterval for servers with tunnels open or
+     * used within the last interval
+     */
+    "ActiveFactor": number;
+
+    /**
+     * Multiplier of ServerRecheckInterval for servers that passed
+     * StableAfterSuccesses checks in a row
+     */
+    "StableFactor": number;
+    "StableAfterSuccesses": number;
+
+    /**
+     * Failing servers back off exponentially up to this delay
+     */
+    "MaxBackoff": time$0.Duration;
+
+    /**
+     * Random spread of each delay, as a fraction of it (0.2 = ±20%)
+     */
+    "Jitter": number;
+
+    /**
+     * Checks per minute allowed against one host, 0 disables the limit
+     */
+    "ProviderChecksPerMinute": number;
+
+    /** Creates a new RecheckConfig instance. */
+    constructor($$source: Partial<RecheckConfig> = {}) {
+        if (!("ActiveFactor" in $$source)) {
+            this["ActiveFactor"] = 0;
+        }
+        if (!("StableFactor" in $$source)) {
+            this["StableFactor"] = 0;
+        }
+        if (!("StableAfterSuccesses" in $$source)) {
+            this["StableAfterSuccesses"] = 0;
+        }
+        if (!("MaxBackoff" in $$source)) {
+            this["MaxBackoff"] = time$0.Duration.$zero;
+        }
+        if (!("Jitter" in $$source)) {
+            this["Jitter"] = 0;
+        }
+        if (!("ProviderChecksPerMinute" in $$source)) {
+            this["ProviderChecksPerMinute"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new RecheckConfig instance from a string or object.
+     */
+    static createFrom($$source: any = {}): RecheckConfig {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new RecheckConfig($$parsedSource as Partial<RecheckConfig>);
+    }
+}
+
+/**
+ * Connections turned away since the app started, by reason
+ */
+export class RejectStat {
+    "ConnectionLimit": number;
+    "IpLimit": number;
+    "RateLimit": number;
+    "QueueTimeout": number;
+
+    /** Creates a new RejectStat instance. */
+    constructor($$source: Partial<RejectStat> = {}) {
+        if (!("ConnectionLimit" in $$source)) {
+            this["ConnectionLimit"] = 0;
+        }
+        if (!("IpLimit" in $$source)) {
+            this["IpLimit"] = 0;
+        }
+        if (!("RateLimit" in $$source)) {
+            this["RateLimit"] = 0;
+        }
+        if (!("QueueTimeout" in $$source)) {
+            this["QueueTimeout"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new RejectStat instance from a string or object.
+     */
+    static createFrom($$source: any = {}): RejectStat {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new RejectStat($$parsedSource as Partial<RejectStat>);
+    }
+}
+
+export class RoutingRule {
+    "Match": string;
+    "Value": string;
+    "Action": string;
+
+    /**
+     * Servers to route through for ACTION_Route
+     */
+    "Filter": ServerFilter;
+
+    /** Creates a new RoutingRule instance. */
+    constructor($$source: Partial<RoutingRule> = {}) {
+        if (!("Match" in $$source)) {
+            this["Match"] = "";
+        }
+        if (!("Value" in $$source)) {
+            this["Value"] = "";
+        }
+        if (!("Action" in $$source)) {
+            this["Action"] = "";
+        }
+        if (!("Filter" in $$source)) {
+            this["Filter"] = (new ServerFilter());
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new RoutingRule instance from a string or object.
+     */
+    static createFrom($$source: any = {}): RoutingRule {
+        const $$createField3_0 = $$createType2;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Filter" in $$parsedSource) {
+            $$parsedSource["Filter"] = $$createField3_0($$parsedSource["Filter"]);
+        }
+        return new RoutingRule($$parsedSource as Partial<RoutingRule>);
+    }
+}
+
+export class RuleImportResult {
+    "Rules": RoutingRule[];
+    "Errors": string[];
 
-    /** Creates a new AppState instance. */
-    constructor($$source: Partial<AppState> = {}) {
-        if (!("LocalIp" in $$source)) {
-            this["LocalIp"] = "";
+    /** Creates a new RuleImportResult instance. */
+    constructor($$source: Partial<RuleImportResult> = {}) {
+        if (!("Rules" in $$source)) {
+            this["Rules"] = [];
+        }
+        if (!("Errors" in $$source)) {
+            this["Errors"] = [];
         }
 
         Object.assign(this, $$source);
     }
 
     /**
-     * Creates a new AppState instance from a string or object.
+     * Creates a new RuleImportResult instance from a string or object.
      */
-    static createFrom($$source: any = {}): AppState {
+    static createFrom($$source: any = {}): RuleImportResult {
+        const $$createField0_0 = $$createType8;
+        const $$createField1_0 = $$createType3;
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
-        return new AppState($$parsedSource as Partial<AppState>);
+        if ("Rules" in $$parsedSource) {
+            $$parsedSource["Rules"] = $$createField0_0($$parsedSource["Rules"]);
+        }
+        if ("Errors" in $$parsedSource) {
+            $$parsedSource["Errors"] = $$createField1_0($$parsedSource["Errors"]);
+        }
+        return new RuleImportResult($$parsedSource as Partial<RuleImportResult>);
     }
 }
 
-export class ListenerStat {
-    "Sent": number;
-    "Received": number;
+export class ServerEvent {
+    "ServerId": string;
 
-    /** Creates a new ListenerStat instance. */
-    constructor($$source: Partial<ListenerStat> = {}) {
-        if (!("Sent" in $$source)) {
-            this["Sent"] = 0;
+    /** Creates a new ServerEvent instance. */
+    constructor($$source: Partial<ServerEvent> = {}) {
+        if (!("ServerId" in $$source)) {
+            this["ServerId"] = "";
         }
-        if (!("Received" in $$source)) {
-            this["Received"] = 0;
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new ServerEvent instance from a string or object.
+     */
+    static createFrom($$source: any = {}): ServerEvent {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new ServerEvent($$parsedSource as Partial<ServerEvent>);
+    }
+}
+
+export class ServerFilter {
+    "Tags": string[];
+    "ServerIds": { [_: string]: boolean };
+    "IgnoreAll": boolean;
+
+    /** Creates a new ServerFilter instance. */
+    constructor($$source: Partial<ServerFilter> = {}) {
+        if (!("Tags" in $$source)) {
+            this["Tags"] = [];
+        }
+        if (!("ServerIds" in $$source)) {
+            this["ServerIds"] = {};
+        }
+        if (!("IgnoreAll" in $$source)) {
+            this["IgnoreAll"] = false;
         }
 
         Object.assign(this, $$source);
     }
 
     /**
-     * Creates a new ListenerStat instance from a string or object.
+     * Creates a new ServerFilter instance from a string or object.
      */
-    static createFrom($$source: any = {}): ListenerStat {
+    static createFrom($$source: any = {}): ServerFilter {
+        const $$createField0_0 = $$createType3;
+        const $$createField1_0 = $$createType23;
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
-        return new ListenerStat($$parsedSource as Partial<ListenerStat>);
+        if ("Tags" in $$parsedSource) {
+            $$parsedSource["Tags"] = $$createField0_0($$parsedSource["Tags"]);
+        }
+        if ("ServerIds" in $$parsedSource) {
+            $$parsedSource["ServerIds"] = $$createField1_0($$parsedSource["ServerIds"]);
+        }
+        return new ServerFilter($$parsedSource as Partial<ServerFilter>);
     }
 }
 
-export class LocalListener {
-    "IsServing": boolean;
-    "Port": number;
-    "Listener": net$0.Listener;
-    "Auth": common$0.ProxyAuth | null;
-    "Filter": ServerFilter;
-    "Stat": ListenerStat;
+/**
+ * Samples of the manager total, and of the listeners and servers with
+ * traffic since the previous tick
+ */
+export class StatsTickEvent {
+    "Total": TrafficSample;
+    "Listeners": { [_: `${number}`]: TrafficSample };
+    "Servers": { [_: string]: TrafficSample };
 
-    /** Creates a new LocalListener instance. */
-    constructor($$source: Partial<LocalListener> = {}) {
-        if (!("IsServing" in $$source)) {
-            this["IsServing"] = false;
+    /** Creates a new StatsTickEvent instance. */
+    constructor($$source: Partial<StatsTickEvent> = {}) {
+        if (!("Total" in $$source)) {
+            this["Total"] = (new TrafficSample());
         }
-        if (!("Port" in $$source)) {
-            this["Port"] = 0;
+        if (!("Listeners" in $$source)) {
+            this["Listeners"] = {};
         }
-        if (!("Listener" in $$source)) {
-            this["Listener"] = null;
+        if (!("Servers" in $$source)) {
+            this["Servers"] = {};
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new StatsTickEvent instance from a string or object.
+     */
+    static createFrom($$source: any = {}): StatsTickEvent {
+        const $$createField0_0 = $$createType30;
+        const $$createField1_0 = $$createType31;
+        const $$createField2_0 = $$createType32;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Total" in $$parsedSource) {
+            $$parsedSource["Total"] = $$createField0_0($$parsedSource["Total"]);
         }
-        if (!("Auth" in $$source)) {
-            this["Auth"] = null;
+        if ("Listeners" in $$parsedSource) {
+            $$parsedSource["Listeners"] = $$createField1_0($$parsedSource["Listeners"]);
         }
-        if (!("Filter" in $$source)) {
-            this["Filter"] = (new ServerFilter());
+        if ("Servers" in $$parsedSource) {
+            $$parsedSource["Servers"] = $$createField2_0($$parsedSource["Servers"]);
+        }
+        return new StatsTickEvent($$parsedSource as Partial<StatsTickEvent>);
+    }
+}
+
+export class Subscription {
+    "Id": string;
+    "Name": string;
+
+    /**
+     * HTTP(S) URL or local file path
+     */
+    "Source": string;
+    "Format": ProxyFileFormat;
+    "RefreshInterval": time$0.Duration;
+    "Tags": string[];
+
+    /**
+     * Tags the last sync gave the servers, replaced by the current ones on
+     * the next sync
+     */
+    "SyncedTags": string[];
+    "LastSynced": time$0.Time;
+    "History": SubscriptionSyncResult[];
+
+    /** Creates a new Subscription instance. */
+    constructor($$source: Partial<Subscription> = {}) {
+        if (!("Id" in $$source)) {
+            this["Id"] = "";
+        }
+        if (!("Name" in $$source)) {
+            this["Name"] = "";
+        }
+        if (!("Source" in $$source)) {
+            this["Source"] = "";
+        }
+        if (!("Format" in $$source)) {
+            this["Format"] = (new ProxyFileFormat());
+        }
+        if (!("RefreshInterval" in $$source)) {
+            this["RefreshInterval"] = time$0.Duration.$zero;
+        }
+        if (!("Tags" in $$source)) {
+            this["Tags"] = [];
         }
-        if (!("Stat" in $$source)) {
-            this["Stat"] = (new ListenerStat());
+        if (!("SyncedTags" in $$source)) {
+            this["SyncedTags"] = [];
+        }
+        if (!("LastSynced" in $$source)) {
+            this["LastSynced"] = null;
+        }
+        if (!("History" in $$source)) {
+            this["History"] = [];
         }
 
         Object.assign(this, $$source);
     }
 
     /**
-     * Creates a new LocalListener instance from a string or object.
+     * Creates a new Subscription instance from a string or object.
      */
-    static createFrom($$source: any = {}): LocalListener {
-        const $$createField3_0 = $$createType1;
-        const $$createField4_0 = $$createType2;
+    static createFrom($$source: any = {}): Subscription {
+        const $$createField3_0 = $$createType33;
         const $$createField5_0 = $$createType3;
+        const $$createField6_0 = $$createType3;
+        const $$createField8_0 = $$createType35;
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
-        if ("Auth" in $$parsedSource) {
-            $$parsedSource["Auth"] = $$createField3_0($$parsedSource["Auth"]);
+        if ("Format" in $$parsedSource) {
+            $$parsedSource["Format"] = $$createField3_0($$parsedSource["Format"]);
         }
-        if ("Filter" in $$parsedSource) {
-            $$parsedSource["Filter"] = $$createField4_0($$parsedSource["Filter"]);
+        if ("Tags" in $$parsedSource) {
+            $$parsedSource["Tags"] = $$createField5_0($$parsedSource["Tags"]);
         }
-        if ("Stat" in $$parsedSource) {
-            $$parsedSource["Stat"] = $$createField5_0($$parsedSource["Stat"]);
+        if ("SyncedTags" in $$parsedSource) {
+            $$parsedSource["SyncedTags"] = $$createField6_0($$parsedSource["SyncedTags"]);
         }
-        return new LocalListener($$parsedSource as Partial<LocalListener>);
+        if ("History" in $$parsedSource) {
+            $$parsedSource["History"] = $$createField8_0($$parsedSource["History"]);
+        }
+        return new Subscription($$parsedSource as Partial<Subscription>);
     }
 }
 
-export class ManagedLocalListener {
-    "Listener": LocalListener | null;
+export class SubscriptionEvent {
+    "SubscriptionId": string;
 
-    /** Creates a new ManagedLocalListener instance. */
-    constructor($$source: Partial<ManagedLocalListener> = {}) {
-        if (!("Listener" in $$source)) {
-            this["Listener"] = null;
+    /** Creates a new SubscriptionEvent instance. */
+    constructor($$source: Partial<SubscriptionEvent> = {}) {
+        if (!("SubscriptionId" in $$source)) {
+            this["SubscriptionId"] = "";
         }
 
         Object.assign(this, $$source);
     }
 
     /**
-     * Creates a new ManagedLocalListener instance from a string or object.
+     * Creates a new SubscriptionEvent instance from a string or object.
      */
-    static createFrom($$source: any = {}): ManagedLocalListener {
-        const $$createField0_0 = $$createType5;
+    static createFrom($$source: any = {}): SubscriptionEvent {
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
-        if ("Listener" in $$parsedSource) {
-            $$parsedSource["Listener"] = $$createField0_0($$parsedSource["Listener"]);
+        return new SubscriptionEvent($$parsedSource as Partial<SubscriptionEvent>);
+    }
+}
+
+export class SubscriptionSyncResult {
+    "Time": time$0.Time;
+    "Duration": time$0.Duration;
+    "Added": number;
+    "Updated": number;
+    "Removed": number;
+    "Unchanged": number;
+    "Invalid": number;
+    "Error": string;
+
+    /** Creates a new SubscriptionSyncResult instance. */
+    constructor($$source: Partial<SubscriptionSyncResult> = {}) {
+        if (!("Time" in $$source)) {
+            this["Time"] = null;
         }
-        return new ManagedLocalListener($$parsedSource as Partial<ManagedLocalListener>);
+        if (!("Duration" in $$source)) {
+            this["Duration"] = time$0.Duration.$zero;
+        }
+        if (!("Added" in $$source)) {
+            this["Added"] = 0;
+        }
+        if (!("Updated" in $$source)) {
+            this["Updated"] = 0;
+        }
+        if (!("Removed" in $$source)) {
+            this["Removed"] = 0;
+        }
+        if (!("Unchanged" in $$source)) {
+            this["Unchanged"] = 0;
+        }
+        if (!("Invalid" in $$source)) {
+            this["Invalid"] = 0;
+        }
+        if (!("Error" in $$source)) {
+            this["Error"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new SubscriptionSyncResult instance from a string or object.
+     */
+    static createFrom($$source: any = {}): SubscriptionSyncResult {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new SubscriptionSyncResult($$parsedSource as Partial<SubscriptionSyncResult>);
     }
 }
 
-export class ManagedProxyServer {
-    "Server": proxyserver$0.Server | null;
-    "Tags": { [_: string]: boolean };
+export class TagsChangedEvent {
+    "ServerId": string;
+    "Tags": string[];
 
-    /** Creates a new ManagedProxyServer instance. */
-    constructor($$source: Partial<ManagedProxyServer> = {}) {
-        if (!("Server" in $$source)) {
-            this["Server"] = null;
+    /** Creates a new TagsChangedEvent instance. */
+    constructor($$source: Partial<TagsChangedEvent> = {}) {
+        if (!("ServerId" in $$source)) {
+            this["ServerId"] = "";
         }
         if (!("Tags" in $$source)) {
-            this["Tags"] = {};
+            this["Tags"] = [];
         }
 
         Object.assign(this, $$source);
     }
 
     /**
-     * Creates a new ManagedProxyServer instance from a string or object.
+     * Creates a new TagsChangedEvent instance from a string or object.
      */
-    static createFrom($$source: any = {}): ManagedProxyServer {
-        const $$createField0_0 = $$createType7;
-        const $$createField1_0 = $$createType8;
+    static createFrom($$source: any = {}): TagsChangedEvent {
+        const $$createField1_0 = $$createType3;
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
-        if ("Server" in $$parsedSource) {
-            $$parsedSource["Server"] = $$createField0_0($$parsedSource["Server"]);
-        }
         if ("Tags" in $$parsedSource) {
             $$parsedSource["Tags"] = $$createField1_0($$parsedSource["Tags"]);
         }
-        return new ManagedProxyServer($$parsedSource as Partial<ManagedProxyServer>);
+        return new TagsChangedEvent($$parsedSource as Partial<TagsChangedEvent>);
     }
 }
 
-export class ServerFilter {
-    "Tags": string[];
-    "ServerIds": { [_: string]: boolean };
-    "IgnoreAll": boolean;
+export class TrafficSample {
+    "Time": time$0.Time;
+    "Sent": number;
+    "Received": number;
 
-    /** Creates a new ServerFilter instance. */
-    constructor($$source: Partial<ServerFilter> = {}) {
-        if (!("Tags" in $$source)) {
-            this["Tags"] = [];
+    /**
+     * Bytes per second since the previous sample
+     */
+    "SentRate": number;
+    "ReceivedRate": number;
+
+    /** Creates a new TrafficSample instance. */
+    constructor($$source: Partial<TrafficSample> = {}) {
+        if (!("Time" in $$source)) {
+            this["Time"] = null;
         }
-        if (!("ServerIds" in $$source)) {
-            this["ServerIds"] = {};
+        if (!("Sent" in $$source)) {
+            this["Sent"] = 0;
         }
-        if (!("IgnoreAll" in $$source)) {
-            this["IgnoreAll"] = false;
+        if (!("Received" in $$source)) {
+            this["Received"] = 0;
+        }
+        if (!("SentRate" in $$source)) {
+            this["SentRate"] = 0;
+        }
+        if (!("ReceivedRate" in $$source)) {
+            this["ReceivedRate"] = 0;
         }
 
         Object.assign(this, $$source);
     }
 
     /**
-     * Creates a new ServerFilter instance from a string or object.
+     * Creates a new TrafficSample instance from a string or object.
      */
-    static createFrom($$source: any = {}): ServerFilter {
-        const $$createField0_0 = $$createType9;
-        const $$createField1_0 = $$createType8;
+    static createFrom($$source: any = {}): TrafficSample {
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
-        if ("Tags" in $$parsedSource) {
-            $$parsedSource["Tags"] = $$createField0_0($$parsedSource["Tags"]);
+        return new TrafficSample($$parsedSource as Partial<TrafficSample>);
+    }
+}
+
+export class TrafficStat {
+    "Sent": number;
+    "Received": number;
+
+    /** Creates a new TrafficStat instance. */
+    constructor($$source: Partial<TrafficStat> = {}) {
+        if (!("Sent" in $$source)) {
+            this["Sent"] = 0;
         }
-        if ("ServerIds" in $$parsedSource) {
-            $$parsedSource["ServerIds"] = $$createField1_0($$parsedSource["ServerIds"]);
+        if (!("Received" in $$source)) {
+            this["Received"] = 0;
         }
-        return new ServerFilter($$parsedSource as Partial<ServerFilter>);
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new TrafficStat instance from a string or object.
+     */
+    static createFrom($$source: any = {}): TrafficStat {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new TrafficStat($$parsedSource as Partial<TrafficStat>);
+    }
+}
+
+export class UsageEntry {
+    "Key": string;
+    "Sent": number;
+    "Received": number;
+    "Connections": number;
+    "Errors": number;
+
+    /** Creates a new UsageEntry instance. */
+    constructor($$source: Partial<UsageEntry> = {}) {
+        if (!("Key" in $$source)) {
+            this["Key"] = "";
+        }
+        if (!("Sent" in $$source)) {
+            this["Sent"] = 0;
+        }
+        if (!("Received" in $$source)) {
+            this["Received"] = 0;
+        }
+        if (!("Connections" in $$source)) {
+            this["Connections"] = 0;
+        }
+        if (!("Errors" in $$source)) {
+            this["Errors"] = 0;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new UsageEntry instance from a string or object.
+     */
+    static createFrom($$source: any = {}): UsageEntry {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new UsageEntry($$parsedSource as Partial<UsageEntry>);
+    }
+}
+
+/**
+ * Parameters clients may append to their username, provider style:
+ * alice-country-us-session-x1-ttl-10m
+ */
+export class UsernameParamsConfig {
+    /**
+     * No parameters disables parsing, the username must then match exactly
+     */
+    "Allowed": string[];
+    "Separator": string;
+
+    /** Creates a new UsernameParamsConfig instance. */
+    constructor($$source: Partial<UsernameParamsConfig> = {}) {
+        if (!("Allowed" in $$source)) {
+            this["Allowed"] = [];
+        }
+        if (!("Separator" in $$source)) {
+            this["Separator"] = "";
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new UsernameParamsConfig instance from a string or object.
+     */
+    static createFrom($$source: any = {}): UsernameParamsConfig {
+        const $$createField0_0 = $$createType3;
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        if ("Allowed" in $$parsedSource) {
+            $$parsedSource["Allowed"] = $$createField0_0($$parsedSource["Allowed"]);
+        }
+        return new UsernameParamsConfig($$parsedSource as Partial<UsernameParamsConfig>);
     }
 }
 
 export class listenerServerManager {
     "Listeners": { [_: `${number}`]: ManagedLocalListener | null };
     "Servers": { [_: string]: ManagedProxyServer | null };
+    "Subscriptions": { [_: string]: Subscription | null };
+
+    /**
+     * Evicted servers kept out of rotation, restorable by hand
+     */
+    "ArchivedServers": { [_: string]: ManagedProxyServer | null };
     "ServerRecheckInterval": time$0.Duration;
+    "DuplicatePolicy": string;
+    "Lifecycle": LifecycleConfig;
+    "Recheck": RecheckConfig;
+    "DrainTimeout": time$0.Duration;
     "IsServing": boolean;
     "Wg": sync$0.WaitGroup;
 
@@ -230,9 +1933,27 @@ export class listenerServerManager {
         if (!("Servers" in $$source)) {
             this["Servers"] = {};
         }
+        if (!("Subscriptions" in $$source)) {
+            this["Subscriptions"] = {};
+        }
+        if (!("ArchivedServers" in $$source)) {
+            this["ArchivedServers"] = {};
+        }
         if (!("ServerRecheckInterval" in $$source)) {
             this["ServerRecheckInterval"] = time$0.Duration.$zero;
         }
+        if (!("DuplicatePolicy" in $$source)) {
+            this["DuplicatePolicy"] = "";
+        }
+        if (!("Lifecycle" in $$source)) {
+            this["Lifecycle"] = (new LifecycleConfig());
+        }
+        if (!("Recheck" in $$source)) {
+            this["Recheck"] = (new RecheckConfig());
+        }
+        if (!("DrainTimeout" in $$source)) {
+            this["DrainTimeout"] = time$0.Duration.$zero;
+        }
         if (!("IsServing" in $$source)) {
             this["IsServing"] = false;
         }
@@ -247,9 +1968,13 @@ export class listenerServerManager {
      * Creates a new listenerServerManager instance from a string or object.
      */
     static createFrom($$source: any = {}): listenerServerManager {
-        const $$createField0_0 = $$createType12;
-        const $$createField1_0 = $$createType15;
-        const $$createField4_0 = $$createType16;
+        const $$createField0_0 = $$createType38;
+        const $$createField1_0 = $$createType41;
+        const $$createField2_0 = $$createType44;
+        const $$createField3_0 = $$createType41;
+        const $$createField6_0 = $$createType24;
+        const $$createField7_0 = $$createType25;
+        const $$createField10_0 = $$createType45;
         let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
         if ("Listeners" in $$parsedSource) {
             $$parsedSource["Listeners"] = $$createField0_0($$parsedSource["Listeners"]);
@@ -257,28 +1982,69 @@ export class listenerServerManager {
         if ("Servers" in $$parsedSource) {
             $$parsedSource["Servers"] = $$createField1_0($$parsedSource["Servers"]);
         }
+        if ("Subscriptions" in $$parsedSource) {
+            $$parsedSource["Subscriptions"] = $$createField2_0($$parsedSource["Subscriptions"]);
+        }
+        if ("ArchivedServers" in $$parsedSource) {
+            $$parsedSource["ArchivedServers"] = $$createField3_0($$parsedSource["ArchivedServers"]);
+        }
+        if ("Lifecycle" in $$parsedSource) {
+            $$parsedSource["Lifecycle"] = $$createField6_0($$parsedSource["Lifecycle"]);
+        }
+        if ("Recheck" in $$parsedSource) {
+            $$parsedSource["Recheck"] = $$createField7_0($$parsedSource["Recheck"]);
+        }
         if ("Wg" in $$parsedSource) {
-            $$parsedSource["Wg"] = $$createField4_0($$parsedSource["Wg"]);
+            $$parsedSource["Wg"] = $$createField10_0($$parsedSource["Wg"]);
         }
         return new listenerServerManager($$parsedSource as Partial<listenerServerManager>);
     }
 }
 
 // Private type creation functions
-const $$createType0 = common$0.ProxyAuth.createFrom;
-const $$createType1 = $Create.Nullable($$createType0);
+const $$createType0 = TrafficStat.createFrom;
+const $$createType1 = $Create.Array($Create.Any);
 const $$createType2 = ServerFilter.createFrom;
-const $$createType3 = ListenerStat.createFrom;
-const $$createType4 = LocalListener.createFrom;
-const $$createType5 = $Create.Nullable($$createType4);
-const $$createType6 = proxyserver$0.Server.createFrom;
-const $$createType7 = $Create.Nullable($$createType6);
-const $$createType8 = $Create.Map($Create.Any, $Create.Any);
-const $$createType9 = $Create.Array($Create.Any);
-const $$createType10 = ManagedLocalListener.createFrom;
-const $$createType11 = $Create.Nullable($$createType10);
-const $$createType12 = $Create.Map($Create.Any, $$createType11);
-const $$createType13 = ManagedProxyServer.createFrom;
-const $$createType14 = $Create.Nullable($$createType13);
-const $$createType15 = $Create.Map($Create.Any, $$createType14);
-const $$createType16 = sync$0.WaitGroup.createFrom;
+const $$createType3 = $Create.Array($Create.Any);
+const $$createType4 = RejectStat.createFrom;
+const $$createType5 = $Create.Nullable($$createType2);
+const $$createType6 = ListenerBind.createFrom;
+const $$createType7 = RoutingRule.createFrom;
+const $$createType8 = $Create.Array($$createType7);
+const $$createType9 = ListenerUser.createFrom;
+const $$createType10 = $Create.Map($Create.Any, $$createType9);
+const $$createType11 = UsernameParamsConfig.createFrom;
+const $$createType12 = ListenerAcl.createFrom;
+const $$createType13 = ConnectionLimits.createFrom;
+const $$createType14 = rwutil$0.TunnelOptions.createFrom;
+const $$createType15 = RateLimit.createFrom;
+const $$createType16 = $Create.Map($Create.Any, $$createType15);
+const $$createType17 = DataQuota.createFrom;
+const $$createType18 = $Create.Map($Create.Any, $$createType17);
+const $$createType19 = LocalListener.createFrom;
+const $$createType20 = $Create.Nullable($$createType19);
+const $$createType21 = proxyserver$0.Server.createFrom;
+const $$createType22 = $Create.Nullable($$createType21);
+const $$createType23 = $Create.Map($Create.Any, $Create.Any);
+const $$createType24 = LifecycleConfig.createFrom;
+const $$createType25 = RecheckConfig.createFrom;
+const $$createType26 = $Create.Map($Create.Any, $Create.Any);
+const $$createType27 = ProxyImportEntry.createFrom;
+const $$createType28 = $Create.Array($$createType27);
+const $$createType29 = AddServersResult.createFrom;
+const $$createType30 = TrafficSample.createFrom;
+const $$createType31 = $Create.Map($Create.Any, $$createType30);
+const $$createType32 = $Create.Map($Create.Any, $$createType30);
+const $$createType33 = ProxyFileFormat.createFrom;
+const $$createType34 = SubscriptionSyncResult.createFrom;
+const $$createType35 = $Create.Array($$createType34);
+const $$createType36 = ManagedLocalListener.createFrom;
+const $$createType37 = $Create.Nullable($$createType36);
+const $$createType38 = $Create.Map($Create.Any, $$createType37);
+const $$createType39 = ManagedProxyServer.createFrom;
+const $$createType40 = $Create.Nullable($$createType39);
+const $$createType41 = $Create.Map($Create.Any, $$createType40);
+const $$createType42 = Subscription.createFrom;
+const $$createType43 = $Create.Nullable($$createType42);
+const $$createType44 = $Create.Map($Create.Any, $$createType43);
+const $$createType45 = sync$0.WaitGroup.createFrom;
@@ -8,11 +8,30 @@ import { Call as $Call, CancellablePromise as $CancellablePromise, Create as $Cr
 // eslint-disable-next-line @typescript-eslint/ban-ts-comment
 // @ts-ignore: Unused imports
 import * as proxyserver$0 from "./proxyserver/models.js";
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import * as rwutil$0 from "./rwutil/models.js";
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import * as time$0 from "../time/models.js";
 
 // eslint-disable-next-line @typescript-eslint/ban-ts-comment
 // @ts-ignore: Unused imports
 import * as $models from "./models.js";
 
+/**
+ * Open a listener, returns its port or the id of a Unix socket listener
+ */
+export function AddListener(bind: $models.ListenerBind, port: number, filter: $models.ServerFilter): $CancellablePromise<number> {
+    return $Call.ByName("main.MyService.AddListener", bind, port, filter);
+}
+
+export function AddSubscription(name: string, source: string, format: $models.ProxyFileFormat, refreshInterval: time$0.Duration, tags: string[]): $CancellablePromise<$models.Subscription | null> {
+    return $Call.ByName("main.MyService.AddSubscription", name, source, format, refreshInterval, tags).then(($result: any) => {
+        return $$createType1($result);
+    });
+}
+
 export function DeleteListeners(ports: number[]): $CancellablePromise<void> {
     return $Call.ByName("main.MyService.DeleteListeners", ports);
 }
@@ -21,25 +40,145 @@ export function DeleteServers(ids: string[]): $CancellablePromise<void> {
     return $Call.ByName("main.MyService.DeleteServers", ids);
 }
 
+export function DeleteSubscriptions(ids: string[], removeServers: boolean): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.DeleteSubscriptions", ids, removeServers);
+}
+
+export function ExportEndpoints(opts: $models.ProxyExportOptions): $CancellablePromise<string> {
+    return $Call.ByName("main.MyService.ExportEndpoints", opts);
+}
+
+export function ExportServers(opts: $models.ProxyExportOptions): $CancellablePromise<string> {
+    return $Call.ByName("main.MyService.ExportServers", opts);
+}
+
 export function GetAppState(): $CancellablePromise<$models.AppState> {
     return $Call.ByName("main.MyService.GetAppState").then(($result: any) => {
-        return $$createType0($result);
+        return $$createType2($result);
+    });
+}
+
+export function GetConnections(filter: $models.ConnectionFilter): $CancellablePromise<$models.ActiveConnection[]> {
+    return $Call.ByName("main.MyService.GetConnections", filter).then(($result: any) => {
+        return $$createType4($result);
+    });
+}
+
+/**
+ * Quota usage of the listener, under an empty username, and of its users
+ */
+export function GetListenerQuotas(port: number): $CancellablePromise<{ [_: string]: $models.QuotaStatus }> {
+    return $Call.ByName("main.MyService.GetListenerQuotas", port).then(($result: any) => {
+        return $$createType6($result);
+    });
+}
+
+export function GetListenerStatus(port: number): $CancellablePromise<$models.ListenerStatus> {
+    return $Call.ByName("main.MyService.GetListenerStatus", port).then(($result: any) => {
+        return $$createType7($result);
+    });
+}
+
+export function GetListenerUsers(port: number): $CancellablePromise<$models.ListenerUserStatus[]> {
+    return $Call.ByName("main.MyService.GetListenerUsers", port).then(($result: any) => {
+        return $$createType9($result);
     });
 }
 
 export function GetManager(): $CancellablePromise<$models.listenerServerManager | null> {
     return $Call.ByName("main.MyService.GetManager").then(($result: any) => {
-        return $$createType2($result);
+        return $$createType11($result);
+    });
+}
+
+export function GetManagerSettings(): $CancellablePromise<$models.ManagerConfig> {
+    return $Call.ByName("main.MyService.GetManagerSettings").then(($result: any) => {
+        return $$createType12($result);
     });
 }
 
-export function ImportProxyFile(content: string, sep: string, skipCol: number, defaultPort: number, skipHeader: boolean): $CancellablePromise<void> {
-    return $Call.ByName("main.MyService.ImportProxyFile", content, sep, skipCol, defaultPort, skipHeader);
+/**
+ * Servers ("server") or destination hosts ("destination") using the most
+ * traffic within the last "hour", "day" or "month"
+ */
+export function GetTopUsage(kind: string, window: string, n: number): $CancellablePromise<$models.UsageEntry[]> {
+    return $Call.ByName("main.MyService.GetTopUsage", kind, window, n).then(($result: any) => {
+        return $$createType14($result);
+    });
+}
+
+/**
+ * Traffic samples of the whole manager ("total"), a listener ("listener", id
+ * is the port), a server ("server") or a listener user ("user", id is
+ * "port/username") since the given time
+ */
+export function GetTrafficHistory(scope: string, id: string, since: time$0.Time): $CancellablePromise<$models.TrafficSample[]> {
+    return $Call.ByName("main.MyService.GetTrafficHistory", scope, id, since).then(($result: any) => {
+        return $$createType16($result);
+    });
+}
+
+/**
+ * Current traffic totals of the manager, every listener and every server
+ */
+export function GetTrafficTotals(): $CancellablePromise<$models.StatsTickEvent> {
+    return $Call.ByName("main.MyService.GetTrafficTotals").then(($result: any) => {
+        return $$createType17($result);
+    });
+}
+
+/**
+ * Parse a rule list and append the rules to the listener, or replace the
+ * current rules. Lines that cannot be parsed are reported and skipped.
+ */
+export function ImportListenerRules(port: number, content: string, action: string, filter: $models.ServerFilter, replace: boolean): $CancellablePromise<$models.RuleImportResult> {
+    return $Call.ByName("main.MyService.ImportListenerRules", port, content, action, filter, replace).then(($result: any) => {
+        return $$createType18($result);
+    });
+}
+
+export function ImportProxies(content: string, format: $models.ProxyFileFormat): $CancellablePromise<$models.ProxyImportReport | null> {
+    return $Call.ByName("main.MyService.ImportProxies", content, format).then(($result: any) => {
+        return $$createType20($result);
+    });
+}
+
+export function ImportProxyFile(content: string, sep: string, skipCol: number, defaultPort: number, skipHeader: boolean): $CancellablePromise<$models.ProxyImportReport | null> {
+    return $Call.ByName("main.MyService.ImportProxyFile", content, sep, skipCol, defaultPort, skipHeader).then(($result: any) => {
+        return $$createType20($result);
+    });
+}
+
+export function KillConnection(id: number): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.KillConnection", id);
+}
+
+/**
+ * Close every open tunnel matching the filter, e.g. all tunnels through a
+ * server
+ */
+export function KillConnections(filter: $models.ConnectionFilter): $CancellablePromise<number> {
+    return $Call.ByName("main.MyService.KillConnections", filter);
+}
+
+export function MergeDuplicateServers(sameExitIp: boolean): $CancellablePromise<$models.MergeDuplicatesResult> {
+    return $Call.ByName("main.MyService.MergeDuplicateServers", sameExitIp).then(($result: any) => {
+        return $$createType21($result);
+    });
 }
 
 export function ParseProxyLine(proxyStr: string, sep: string, skip: number, defaultPort: number): $CancellablePromise<proxyserver$0.Server | null> {
     return $Call.ByName("main.MyService.ParseProxyLine", proxyStr, sep, skip, defaultPort).then(($result: any) => {
-        return $$createType4($result);
+        return $$createType23($result);
+    });
+}
+
+/**
+ * Parse without importing, to preview the result
+ */
+export function PreviewProxyImport(content: string, format: $models.ProxyFileFormat): $CancellablePromise<$models.ProxyImportReport | null> {
+    return $Call.ByName("main.MyService.PreviewProxyImport", content, format).then(($result: any) => {
+        return $$createType20($result);
     });
 }
 
@@ -47,9 +186,114 @@ export function RecheckServer(id: string): $CancellablePromise<void> {
     return $Call.ByName("main.MyService.RecheckServer", id);
 }
 
+export function ResetListenerQuota(port: number, user: string): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.ResetListenerQuota", port, user);
+}
+
+export function RestoreServers(ids: string[]): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.RestoreServers", ids);
+}
+
+/**
+ * Set the CIDRs allowed and denied to connect to the listener
+ */
+export function SetListenerAcl(port: number, acl: $models.ListenerAcl): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.SetListenerAcl", port, acl);
+}
+
+export function SetListenerConnectionLimits(port: number, limits: $models.ConnectionLimits): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.SetListenerConnectionLimits", port, limits);
+}
+
+/**
+ * Set the data quota of the listener and of its users by username, a zero
+ * limit removes the quota
+ */
+export function SetListenerQuotas(port: number, quota: $models.DataQuota, users: { [_: string]: $models.DataQuota }): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.SetListenerQuotas", port, quota, users);
+}
+
+/**
+ * Limit the listener and its users by username, 0 means no limit. Open
+ * tunnels follow the new limits.
+ */
+export function SetListenerRateLimits(port: number, limit: $models.RateLimit, users: { [_: string]: $models.RateLimit }): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.SetListenerRateLimits", port, limit, users);
+}
+
+export function SetListenerRules(port: number, rules: $models.RoutingRule[]): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.SetListenerRules", port, rules);
+}
+
+export function SetListenerTimeouts(port: number, timeouts: rwutil$0.TunnelOptions): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.SetListenerTimeouts", port, timeouts);
+}
+
+/**
+ * Set which parameters clients may append to their username to pick servers,
+ * e.g. alice-country-us-session-x1-ttl-10m
+ */
+export function SetListenerUsernameParams(port: number, params: $models.UsernameParamsConfig): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.SetListenerUsernameParams", port, params);
+}
+
+/**
+ * Replace the users of the listener, without users it accepts clients
+ * without auth
+ */
+export function SetListenerUsers(port: number, users: $models.ListenerUser[]): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.SetListenerUsers", port, users);
+}
+
+export function SetServersRateLimit(ids: string[], limit: $models.RateLimit): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.SetServersRateLimit", ids, limit);
+}
+
+/**
+ * Set the state of the servers matching the filter, drainTimeout only applies
+ * to draining. Returns the number of matched servers.
+ */
+export function SetServersState(filter: $models.ServerFilter, state: string, drainTimeout: time$0.Duration): $CancellablePromise<number> {
+    return $Call.ByName("main.MyService.SetServersState", filter, state, drainTimeout);
+}
+
+export function SyncSubscription(id: string): $CancellablePromise<$models.SubscriptionSyncResult> {
+    return $Call.ByName("main.MyService.SyncSubscription", id).then(($result: any) => {
+        return $$createType24($result);
+    });
+}
+
+export function UpdateManagerSettings(settings: $models.ManagerConfig): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.UpdateManagerSettings", settings);
+}
+
+export function UpdateSubscription(sub: $models.Subscription): $CancellablePromise<void> {
+    return $Call.ByName("main.MyService.UpdateSubscription", sub);
+}
+
 // Private type creation functions
-const $$createType0 = $models.AppState.createFrom;
-const $$createType1 = $models.listenerServerManager.createFrom;
-const $$createType2 = $Create.Nullable($$createType1);
-const $$createType3 = proxyserver$0.Server.createFrom;
-const $$createType4 = $Create.Nullable($$createType3);
+const $$createType0 = $models.Subscription.createFrom;
+const $$createType1 = $Create.Nullable($$createType0);
+const $$createType2 = $models.AppState.createFrom;
+const $$createType3 = $models.ActiveConnection.createFrom;
+const $$createType4 = $Create.Array($$createType3);
+const $$createType5 = $models.QuotaStatus.createFrom;
+const $$createType6 = $Create.Map($Create.Any, $$createType5);
+const $$createType7 = $models.ListenerStatus.createFrom;
+const $$createType8 = $models.ListenerUserStatus.createFrom;
+const $$createType9 = $Create.Array($$createType8);
+const $$createType10 = $models.listenerServerManager.createFrom;
+const $$createType11 = $Create.Nullable($$createType10);
+const $$createType12 = $models.ManagerConfig.createFrom;
+const $$createType13 = $models.UsageEntry.createFrom;
+const $$createType14 = $Create.Array($$createType13);
+const $$createType15 = $models.TrafficSample.createFrom;
+const $$createType16 = $Create.Array($$createType15);
+const $$createType17 = $models.StatsTickEvent.createFrom;
+const $$createType18 = $models.RuleImportResult.createFrom;
+const $$createType19 = $models.ProxyImportReport.createFrom;
+const $$createType20 = $Create.Nullable($$createType19);
+const $$createType21 = $models.MergeDuplicatesResult.createFrom;
+const $$createType22 = proxyserver$0.Server.createFrom;
+const $$createType23 = $Create.Nullable($$createType22);
+const $$createType24 = $models.SubscriptionSyncResult.createFrom;
@@ -23,6 +23,11 @@ export class Server {
     "LastChecked": time$0.Time;
     "Protocols": { [_: string]: boolean };
 
+    /**
+     * Protocol known up front (e.g. from a socks5:// URI), only this protocol is checked
+     */
+    "ProtocolHint": string;
+
     /** Creates a new Server instance. */
     constructor($$source: Partial<Server> = {}) {
         if (!("Id" in $$source)) {
@@ -52,6 +57,9 @@ export class Server {
         if (!("Protocols" in $$source)) {
             this["Protocols"] = {};
         }
+        if (!("ProtocolHint" in $$source)) {
+            this["ProtocolHint"] = "";
+        }
 
         Object.assign(this, $$source);
     }
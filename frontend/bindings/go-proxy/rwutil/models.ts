@@ -0,0 +1,51 @@
+// Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
+// This file is automatically generated. DO NOT EDIT
+
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import { Create as $Create } from "@wailsio/runtime";
+
+// eslint-disable-next-line @typescript-eslint/ban-ts-comment
+// @ts-ignore: Unused imports
+import * as time$0 from "../../time/models.js";
+
+export class TunnelOptions {
+    /**
+     * Close when neither side sent anything for this long, 0 for no timeout
+     */
+    "IdleTimeout": time$0.Duration;
+
+    /**
+     * Close this long after the start, 0 for no limit
+     */
+    "MaxLifetime": time$0.Duration;
+
+    /**
+     * Copy TCP to TCP with splice where the OS supports it. Off by default,
+     * it showed no gain over the pooled buffer in the benchmarks.
+     */
+    "Splice": boolean;
+
+    /** Creates a new TunnelOptions instance. */
+    constructor($$source: Partial<TunnelOptions> = {}) {
+        if (!("IdleTimeout" in $$source)) {
+            this["IdleTimeout"] = time$0.Duration.$zero;
+        }
+        if (!("MaxLifetime" in $$source)) {
+            this["MaxLifetime"] = time$0.Duration.$zero;
+        }
+        if (!("Splice" in $$source)) {
+            this["Splice"] = false;
+        }
+
+        Object.assign(this, $$source);
+    }
+
+    /**
+     * Creates a new TunnelOptions instance from a string or object.
+     */
+    static createFrom($$source: any = {}): TunnelOptions {
+        let $$parsedSource = typeof $$source === 'string' ? JSON.parse($$source) : $$source;
+        return new TunnelOptions($$parsedSource as Partial<TunnelOptions>);
+    }
+}
@@ -12,12 +12,29 @@ import {
   usePageStore,
 } from "./state";
 
+// Events that change the manager structure, stats ticks and connection
+// events are handled without refetching
+const REFETCH_EVENTS = [
+  "goproxy:server-added",
+  "goproxy:server-removed",
+  "goproxy:server-changed",
+  "goproxy:check-finished",
+  "goproxy:protocols-changed",
+  "goproxy:tags-changed",
+  "goproxy:listener-started",
+  "goproxy:listener-stopped",
+  "goproxy:listener-changed",
+  "goproxy:settings-changed",
+  "goproxy:subscription-changed",
+] as const;
+
 function App() {
   const page = usePageStore((state) => state.page);
   const fetchManager = debounce(
     useManagerStore((state) => state.fetchManager),
     500,
   );
+  const applyStatsTick = useManagerStore((state) => state.applyStatsTick);
   const fetchAppState = useAppStateStore((state) => state.fetchState);
 
   useEffect(() => {
@@ -25,11 +42,16 @@ function App() {
     fetchAppState();
     // const appStateInt = setInterval(fetchAppState, 1000);
 
-    Events.On("goproxy:data-changed", () => setTimeout(fetchManager));
+    const offs = REFETCH_EVENTS.map((name) =>
+      Events.On(name, () => setTimeout(fetchManager)),
+    );
+    offs.push(
+      Events.On("goproxy:stats-tick", (ev) => applyStatsTick(ev.data)),
+    );
 
     return () => {
       // clearInterval(appStateInt);
-      Events.Off("goproxy:data-changed");
+      offs.forEach((off) => off());
     };
     // eslint-disable-next-line react-hooks/exhaustive-deps
   }, []);
@@ -10,6 +10,25 @@ import {
 } from "@tanstack/react-table";
 import { useState } from "react";
 
+function ListenerTraffic({
+  port,
+  field,
+}: {
+  port?: number;
+  field: "Sent" | "Received";
+}) {
+  const sample = useManagerStore((state) =>
+    port === undefined ? undefined : state.listenerStats[port],
+  );
+
+  return (
+    <span>
+      {formatByte(sample?.[field] || 0)} (
+      {formatByte(sample?.[`${field}Rate`] || 0)}/s)
+    </span>
+  );
+}
+
 const columns: ColumnDef<ManagedLocalListener>[] = [
   {
     id: "port",
@@ -44,14 +63,14 @@ const columns: ColumnDef<ManagedLocalListener>[] = [
     id: "received",
     header: "Tải xuống",
     cell: ({ row }) => (
-      <span>{formatByte(row.original.Listener?.Stat.Received || 0)}</span>
+      <ListenerTraffic port={row.original.Listener?.Port} field="Received" />
     ),
   },
   {
     id: "sent",
     header: "Tải lên",
     cell: ({ row }) => (
-      <span>{formatByte(row.original.Listener?.Stat.Sent || 0)}</span>
+      <ListenerTraffic port={row.original.Listener?.Port} field="Sent" />
     ),
   },
 ];
@@ -3,8 +3,14 @@ import {
   listenerServerManager,
   ManagedLocalListener,
   ManagedProxyServer,
+  StatsTickEvent,
+  TrafficSample,
 } from "@bindings/go-proxy/models";
-import { GetAppState, GetManager } from "@bindings/go-proxy/myservice";
+import {
+  GetAppState,
+  GetManager,
+  GetTrafficTotals,
+} from "@bindings/go-proxy/myservice";
 import type {} from "@redux-devtools/extension"; // Required for zustand IDE typing
 import { createWithEqualityFn as create } from "zustand/traditional";
 import { equalJson, sortBy } from "./lib/utils";
@@ -27,32 +33,76 @@ export const useManagerStore = create<{
   manager: listenerServerManager | null;
   servers: ManagedProxyServer[];
   listeners: ManagedLocalListener[];
+  total: TrafficSample | null;
+  listenerStats: Record<number, TrafficSample>;
+  serverStats: Record<string, TrafficSample>;
   fetchManager: () => Promise<void>;
+  applyStatsTick: (tick: StatsTickEvent) => void;
 }>(
   (set) => ({
     manager: null,
     servers: [],
     listeners: [],
+    total: null,
+    listenerStats: {},
+    serverStats: {},
+    applyStatsTick: (tick) =>
+      set((state) => ({
+        total: tick.Total,
+        listenerStats: mergeSamples(state.listenerStats, tick.Listeners),
+        serverStats: mergeSamples(state.serverStats, tick.Servers),
+      })),
     fetchManager: () =>
-      GetManager().then((manager) => {
-        if (manager) {
-          set({
-            manager,
-            servers: sortBy(
-              Object.values(manager.Servers ?? {}).filter(Boolean),
-              (s) => s.Server?.Id,
-            ),
-            listeners: sortBy(
-              Object.values(manager.Listeners ?? {}).filter(Boolean),
-              (l) => l.Listener?.Port,
-            ),
-          });
-        }
-      }),
+      Promise.all([GetManager(), GetTrafficTotals()]).then(
+        ([manager, totals]) => {
+          if (manager) {
+            set((state) => ({
+              manager,
+              servers: sortBy(
+                Object.values(manager.Servers ?? {}).filter(Boolean),
+                (s) => s.Server?.Id,
+              ),
+              listeners: sortBy(
+                Object.values(manager.Listeners ?? {}).filter(Boolean),
+                (l) => l.Listener?.Port,
+              ),
+              total: state.total ?? totals.Total,
+              listenerStats: seedSamples(state.listenerStats, totals.Listeners),
+              serverStats: seedSamples(state.serverStats, totals.Servers),
+            }));
+          }
+        },
+      ),
   }),
   equalJson,
 );
 
+// Ticks only carry samples with traffic, the others keep their totals and
+// drop to a zero rate
+const mergeSamples = <K extends string | number>(
+  current: Record<K, TrafficSample>,
+  tick: Record<K, TrafficSample> | null,
+) => {
+  const merged = { ...current };
+  for (const key in merged) {
+    merged[key] = { ...merged[key], SentRate: 0, ReceivedRate: 0 };
+  }
+  return { ...merged, ...(tick ?? {}) };
+};
+
+// Totals of the listeners and servers that exist now, idle ones never show up
+// in a tick. Samples from ticks are kept, they carry the current rates.
+const seedSamples = <K extends string | number>(
+  current: Record<K, TrafficSample>,
+  totals: Record<K, TrafficSample> | null,
+) => {
+  const seeded = { ...(totals ?? {}) } as Record<K, TrafficSample>;
+  for (const key in seeded) {
+    seeded[key] = current[key] ?? seeded[key];
+  }
+  return seeded;
+};
+
 export const useAppStateStore = create<{
   state: AppState | null;
   fetchState: () => Promise<void>;
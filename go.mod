@@ -9,6 +9,7 @@ require (
 	github.com/shirou/gopsutil/v4 v4.25.10
 	github.com/wailsapp/wails/v3 v3.0.0-alpha.41
 	golang.org/x/crypto v0.45.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
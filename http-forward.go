@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"go-proxy/rwutil"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+// Headers of a single hop, never forwarded
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for field := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Remove the hop-by-hop headers, including those listed in Connection. The
+// upgrade of a WebSocket request is kept.
+func stripHopHeaders(h http.Header, keepUpgrade bool) {
+	upgrade := h.Get("Upgrade")
+
+	for _, v := range h.Values("Connection") {
+		for field := range strings.SplitSeq(v, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				h.Del(field)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+
+	if keepUpgrade && upgrade != "" {
+		h.Set("Connection", "Upgrade")
+		h.Set("Upgrade", upgrade)
+	}
+}
+
+// Write bytes the reader already buffered, before handing the connection to
+// a tunnel
+func passBuffered(reader *bufio.Reader, w io.Writer) error {
+	n := reader.Buffered()
+	if n == 0 {
+		return nil
+	}
+
+	b, err := reader.Peek(n)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	_, err = w.Write(b)
+	return errtrace.Wrap(err)
+}
+
+// Body flushing the writer before blocking on the next read, so streamed
+// bodies reach the other side as they arrive
+type flushingBody struct {
+	io.ReadCloser
+	writer *bufio.Writer
+}
+
+func (b flushingBody) Read(p []byte) (int, error) {
+	err := b.writer.Flush()
+	if err != nil {
+		return 0, errtrace.Wrap(err)
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	return n, err
+}
+
+// Reader of an origin connection renewing the read deadline before every
+// read, so an origin that goes silent for the idle timeout or runs past the
+// lifetime fails the read instead of hanging the forward
+type deadlineReader struct {
+	conn    net.Conn
+	idle    time.Duration
+	expires time.Time
+}
+
+func newDeadlineReader(conn net.Conn, timeouts rwutil.TunnelOptions) deadlineReader {
+	r := deadlineReader{conn, timeouts.IdleTimeout, time.Time{}}
+	if timeouts.MaxLifetime > 0 {
+		r.expires = time.Now().Add(timeouts.MaxLifetime)
+	}
+	return r
+}
+
+func (r deadlineReader) Read(p []byte) (int, error) {
+	deadline := r.expires
+	if r.idle > 0 {
+		idle := time.Now().Add(r.idle)
+		if deadline.IsZero() || idle.Before(deadline) {
+			deadline = idle
+		}
+	}
+	if !deadline.IsZero() {
+		r.conn.SetReadDeadline(deadline)
+	}
+
+	n, err := r.conn.Read(p)
+	return n, err
+}
+
+// Connection to the origin kept open between requests of a client
+type httpUpstream struct {
+	// The user, server and target the connection is for
+	user     string
+	serverId string
+	target   string
+	conn     net.Conn
+	reader   *bufio.Reader
+	writer   *bufio.Writer
+	// Whether a request went through it before
+	reused  bool
+	untrack func()
+}
+
+func (u *httpUpstream) Close() {
+	u.untrack()
+	u.conn.Close()
+}
+
+// Forward plain HTTP requests of a client one by one, each to its own target
+// and server
+func (l *LocalListener) forwardHttp(conn *IncomingConnection, req *http.Request, reader *bufio.Reader, writer *bufio.Writer) error {
+	var upstream *httpUpstream
+	defer func() {
+		if upstream != nil {
+			upstream.Close()
+		}
+	}()
+
+	for {
+		keepAlive, err := l.forwardRequest(conn, req, reader, writer, &upstream)
+		if err != nil || !keepAlive {
+			return errtrace.Wrap(err)
+		}
+
+		req, err = l.readNextRequest(conn, reader)
+		if errors.Is(err, io.EOF) || errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil
+		}
+		if err != nil {
+			return errtrace.Wrap(err)
+		}
+	}
+}
+
+// Wait for the next request of a keep-alive client, at most the idle timeout
+func (l *LocalListener) readNextRequest(conn *IncomingConnection, reader *bufio.Reader) (*http.Request, error) {
+	l.mu.RLock()
+	idle := l.Timeouts.IdleTimeout
+	l.mu.RUnlock()
+
+	if idle > 0 && reader.Buffered() == 0 {
+		conn.Conn.SetReadDeadline(time.Now().Add(idle))
+		defer conn.Conn.SetReadDeadline(time.Time{})
+	}
+
+	req, err := http.ReadRequest(reader)
+	return req, errtrace.Wrap(err)
+}
+
+// Forward one request and its response. Returns whether the client
+// connection stays open for another request.
+func (l *LocalListener) forwardRequest(conn *IncomingConnection, req *http.Request, reader *bufio.Reader, writer *bufio.Writer, upstream **httpUpstream) (bool, error) {
+	res := newHttpResponse(req)
+	res.Close = true
+
+	if req.Method == "CONNECT" || !req.URL.IsAbs() || req.URL.Scheme != "http" {
+		// Expect absolute http URIs, https goes through CONNECT
+		res.StatusCode = http.StatusBadRequest
+		err := rwutil.WriteResponseFlush(writer, res)
+		return false, errtrace.Wrap(err)
+	}
+
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+	}
+	target := net.JoinHostPort(req.URL.Hostname(), port)
+
+	s, err := l.routeHttp(conn, req, target, writer, *upstream)
+	if err != nil {
+		return false, errtrace.Wrap(err)
+	}
+
+	if up := *upstream; up != nil && (up.user != conn.User || up.serverId != s.Server.Id || up.target != target) {
+		up.Close()
+		*upstream = nil
+	}
+
+	upgrade := headerHasToken(req.Header, "Connection", "upgrade")
+	stripHopHeaders(req.Header, upgrade)
+
+	// The client holds back the body until told to continue, the origin
+	// would wait for it as well
+	if strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+		req.Header.Del("Expect")
+		err = rwutil.WriteStringFlush(writer, "HTTP/1.1 100 Continue\r\n\r\n")
+		if err != nil {
+			return false, errtrace.Wrap(err)
+		}
+	}
+
+	upRes, err := l.sendHttpRequest(conn, s, target, req, upstream)
+	if err != nil {
+		res.StatusCode = http.StatusBadGateway
+		er := rwutil.WriteResponseFlush(writer, res)
+		if er != nil {
+			return false, errtrace.Wrap(er)
+		}
+		return false, errtrace.Wrap(err)
+	}
+	up := *upstream
+
+	if upRes.StatusCode == http.StatusSwitchingProtocols {
+		if !upgrade {
+			return false, errtrace.Errorf("Origin switched protocols without an upgrade request")
+		}
+
+		err = rwutil.WriteResponseFlush(writer, *upRes)
+		if err != nil {
+			return false, errtrace.Wrap(err)
+		}
+		err = passBuffered(up.reader, conn)
+		if err == nil {
+			err = passBuffered(reader, up.conn)
+		}
+		if err != nil {
+			return false, errtrace.Wrap(err)
+		}
+
+		// The tunnel watches the timeouts itself
+		up.conn.SetReadDeadline(time.Time{})
+		l.tunnel(conn, up.conn)
+		return false, nil
+	}
+
+	upstreamClose := upRes.Close
+	stripHopHeaders(upRes.Header, false)
+
+	noBody := req.Method == "HEAD" || upRes.StatusCode == http.StatusNoContent || upRes.StatusCode == http.StatusNotModified
+	chunked := slices.Contains(upRes.TransferEncoding, "chunked")
+	if !req.ProtoAtLeast(1, 1) {
+		// HTTP/1.0 clients know no chunks, the body ends with the connection
+		if chunked {
+			upRes.TransferEncoding = nil
+			chunked = false
+		}
+		req.Close = true
+	}
+
+	upRes.Proto, upRes.ProtoMajor, upRes.ProtoMinor = req.Proto, req.ProtoMajor, req.ProtoMinor
+	upRes.Close = req.Close || (!noBody && upRes.ContentLength < 0 && !chunked)
+	if upRes.Body != http.NoBody {
+		upRes.Body = flushingBody{upRes.Body, writer}
+	}
+
+	err = upRes.Write(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		return false, errtrace.Wrap(err)
+	}
+
+	if upstreamClose {
+		up.Close()
+		*upstream = nil
+	}
+	return !upRes.Close, nil
+}
+
+// Send the request to the origin over the kept connection or a new one, and
+// read the response head. Skips informational responses.
+func (l *LocalListener) sendHttpRequest(conn *IncomingConnection, s *ManagedProxyServer, target string, req *http.Request, upstream **httpUpstream) (*http.Response, error) {
+	if req.Body != http.NoBody {
+		// Set below once the upstream is known
+		req.Body = flushingBody{req.Body, nil}
+	}
+
+	for {
+		if *upstream == nil {
+			remoteConn, err := s.Server.Connect(target)
+			if err != nil {
+				ListenerServerManager.recordConnectError(s, target)
+				return nil, errtrace.Wrap(err)
+			}
+
+			l.mu.RLock()
+			timeouts := l.Timeouts
+			l.mu.RUnlock()
+
+			*upstream = &httpUpstream{
+				conn.User,
+				s.Server.Id,
+				target,
+				remoteConn,
+				bufio.NewReader(newDeadlineReader(remoteConn, timeouts)),
+				bufio.NewWriter(remoteConn),
+				false,
+				l.openTunnel(conn, INBOUND_Http, s, target, remoteConn),
+			}
+		}
+		up := *upstream
+
+		if body, ok := req.Body.(flushingBody); ok {
+			body.writer = up.writer
+			req.Body = body
+		}
+
+		err := req.Write(up.writer)
+		if err == nil {
+			err = up.writer.Flush()
+		}
+
+		var res *http.Response
+		for err == nil {
+			res, err = http.ReadResponse(up.reader, req)
+			if err != nil || res.StatusCode >= 200 || res.StatusCode == http.StatusSwitchingProtocols {
+				break
+			}
+			// Interim responses, e.g. 103 Early Hints, are not passed on
+			res.Body.Close()
+		}
+		if err == nil {
+			up.reused = true
+			return res, nil
+		}
+
+		// The origin may have closed a kept connection meanwhile. Requests
+		// without a body can be sent again.
+		up.Close()
+		*upstream = nil
+		if !up.reused || req.Body != http.NoBody {
+			return nil, errtrace.Wrap(err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"braces.dev/errtrace"
+)
+
+// Source addresses allowed to connect to a listener. Entries are CIDRs or
+// single IPs, deny entries win over allow entries.
+type ListenerAcl struct {
+	// Empty allows every source that is not denied
+	Allow []string
+	Deny  []string
+	// Clients from allowed sources connect without credentials
+	SkipAuthForAllowed bool
+
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		prefix, err := parsePrefix(strings.TrimSpace(v))
+		if err != nil {
+			return nil, errtrace.Errorf("Invalid address %q: %w", v, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func (a *ListenerAcl) compile() error {
+	var err error
+	a.allow, err = parsePrefixes(a.Allow)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	a.deny, err = parsePrefixes(a.Deny)
+	return errtrace.Wrap(err)
+}
+
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+	return slices.ContainsFunc(prefixes, func(p netip.Prefix) bool {
+		return p.Contains(ip)
+	})
+}
+
+// Whether the source may connect, and whether it is explicitly allowed
+func (a *ListenerAcl) check(ip netip.Addr) (bool, bool) {
+	ip = ip.Unmap()
+
+	if containsAddr(a.deny, ip) {
+		return false, false
+	}
+	if containsAddr(a.allow, ip) {
+		return true, true
+	}
+	return len(a.allow) == 0, false
+}
+
+// Check the source of an accepted connection. Returns whether it may connect
+// and whether it may skip auth.
+func (l *LocalListener) admit(addr net.Addr) (bool, bool) {
+	l.mu.RLock()
+	acl := l.Acl
+	l.mu.RUnlock()
+
+	// Socket clients are controlled by the file permissions
+	if addr.Network() == NETWORK_Unix {
+		return true, false
+	}
+
+	ip, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		l.Printlnf("Rejected client %s: %+v", addr, err)
+		return false, false
+	}
+
+	ok, allowed := acl.check(ip.Addr())
+	if !ok {
+		l.Printlnf("Rejected client %s by ACL", addr)
+		return false, false
+	}
+	return true, allowed && acl.SkipAuthForAllowed
+}
+
+func (l *LocalListener) SetAcl(acl ListenerAcl) error {
+	err := acl.compile()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	l.mu.Lock()
+	l.Acl = acl
+	l.mu.Unlock()
+
+	Events.Publish(EVENT_ListenerChanged, strconv.Itoa(l.Port), ListenerEvent{l.Port})
+	return nil
+}
+
+func (m *listenerServerManager) SetListenerAcl(port int, acl ListenerAcl) error {
+	l, err := m.listener(port)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = l.SetAcl(acl)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	m.requestSave()
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	// IPv4 and IPv6 when bound to a wildcard address
+	NETWORK_Tcp  = "tcp"
+	NETWORK_Tcp4 = "tcp4"
+	// IPv6 only
+	NETWORK_Tcp6 = "tcp6"
+	NETWORK_Unix = "unix"
+
+	defaultSocketMode = 0o660
+
+	// A listening process accepts right away, only a hung one takes longer
+	staleSocketDialTimeout = time.Second
+)
+
+type ListenerBind struct {
+	Network string
+	// Interface address for TCP, empty for every interface. Socket path for
+	// Unix sockets.
+	Address string
+	// Permissions of the Unix socket file, 0 for the default
+	SocketMode fs.FileMode
+}
+
+func NewListenerBind() ListenerBind {
+	return ListenerBind{NETWORK_Tcp, "0.0.0.0", 0}
+}
+
+// 1:1 listeners have no users, bound to every interface they would be open
+// proxies to the whole network
+func newDedicatedListenerBind() ListenerBind {
+	return ListenerBind{NETWORK_Tcp, "127.0.0.1", 0}
+}
+
+// Unix socket listeners have no port, they are keyed by negative ids instead
+var lastSocketListenerId atomic.Int64
+
+func (b ListenerBind) listen(port int) (net.Listener, int, error) {
+	switch b.Network {
+	case NETWORK_Tcp, NETWORK_Tcp4, NETWORK_Tcp6:
+	case NETWORK_Unix:
+		listener, err := b.listenUnix()
+		if err != nil {
+			return nil, 0, errtrace.Wrap(err)
+		}
+		return listener, -int(lastSocketListenerId.Add(1)), nil
+	default:
+		return nil, 0, errtrace.Errorf("Unknown listener network %q", b.Network)
+	}
+
+	address := b.Address
+	if address == "" && b.Network == NETWORK_Tcp6 {
+		address = "::"
+	}
+
+	listener, err := net.Listen(b.Network, net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return nil, 0, errtrace.Wrap(err)
+	}
+	return listener, listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (b ListenerBind) listenUnix() (net.Listener, error) {
+	if b.Address == "" {
+		return nil, errtrace.Errorf("Unix socket path is required")
+	}
+
+	// A socket left behind by a crash would block the path. One still
+	// accepting connections belongs to a running process and is kept.
+	info, err := os.Lstat(b.Address)
+	if err == nil && info.Mode()&fs.ModeSocket != 0 {
+		conn, err := net.DialTimeout(NETWORK_Unix, b.Address, staleSocketDialTimeout)
+		if err == nil {
+			conn.Close()
+			return nil, errtrace.Errorf("%s is already in use", b.Address)
+		}
+		if !errors.Is(err, errSocketRefused) {
+			return nil, errtrace.Wrap(err)
+		}
+
+		err = os.Remove(b.Address)
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+	} else if err == nil {
+		return nil, errtrace.Errorf("%s exists and is not a socket", b.Address)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, errtrace.Wrap(err)
+	}
+
+	listener, err := net.Listen(NETWORK_Unix, b.Address)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	mode := b.SocketMode
+	if mode == 0 {
+		mode = defaultSocketMode
+	}
+	err = os.Chmod(b.Address, mode)
+	if err != nil {
+		listener.Close()
+		return nil, errtrace.Wrap(err)
+	}
+
+	return listener, nil
+}
+
+// Address clients connect to, e.g. 127.0.0.1:8080, [::1]:8080 or
+// unix:/run/go-proxy.sock
+func (l *LocalListener) Endpoint() string {
+	if l.Bind.Network == NETWORK_Unix {
+		return "unix:" + l.Bind.Address
+	}
+	return l.Listener.Addr().String()
+}
+
+// Host clients reach the listener at, the given host for listeners on every
+// interface. Unix socket listeners have none.
+func (l *LocalListener) endpointHost(defaultHost string) (string, bool) {
+	if l.Bind.Network == NETWORK_Unix {
+		return "", false
+	}
+
+	ip, err := netip.ParseAddr(l.Bind.Address)
+	if err != nil || ip.IsUnspecified() {
+		return defaultHost, true
+	}
+	return ip.String(), true
+}
+
+// Open a listener, port 0 picks a free port
+func (m *listenerServerManager) AddListener(bind ListenerBind, port int, filter ServerFilter) (*LocalListener, error) {
+	l, err := NewLocalListener(bind, port, filter)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	m.AddListeners([]*LocalListener{l})
+	return l, nil
+}
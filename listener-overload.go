@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"go-proxy/protocol/socks5"
+	"go-proxy/rwutil"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	// Turn away clients over the connection limit right away
+	BACKLOG_Reject = "reject"
+	// Hold clients over the connection limit until a slot frees up or the
+	// queue timeout passes
+	BACKLOG_Queue = "queue"
+
+	REJECT_ConnectionLimit = "connection-limit"
+	REJECT_IpLimit         = "ip-limit"
+	REJECT_RateLimit       = "rate-limit"
+	REJECT_QueueTimeout    = "queue-timeout"
+
+	// Time a rejected client gets to read its reply
+	rejectReplyTimeout = 2 * time.Second
+)
+
+var backlogPolicies = []string{BACKLOG_Reject, BACKLOG_Queue}
+
+// Limits on the clients of a listener, 0 for no limit. The backlog policy
+// applies to the listener limit, clients over their IP limit or the rate
+// limit are always rejected.
+type ConnectionLimits struct {
+	MaxConnections      int
+	MaxConnectionsPerIp int
+	// New connections per second
+	MaxConnectionRate int
+
+	Backlog      string
+	QueueTimeout time.Duration
+}
+
+// Connections turned away since the app started, by reason
+type RejectStat struct {
+	ConnectionLimit uint64
+	IpLimit         uint64
+	RateLimit       uint64
+	QueueTimeout    uint64
+}
+
+func NewConnectionLimits() ConnectionLimits {
+	return ConnectionLimits{0, 0, 0, BACKLOG_Reject, 5 * time.Second}
+}
+
+func (c ConnectionLimits) Validate() error {
+	if c.MaxConnections < 0 || c.MaxConnectionsPerIp < 0 || c.MaxConnectionRate < 0 {
+		return errtrace.Errorf("Connection limits cannot be negative")
+	}
+
+	if !slices.Contains(backlogPolicies, c.Backlog) {
+		return errtrace.Errorf("Unknown backlog policy %q, expected one of %s", c.Backlog, strings.Join(backlogPolicies, ", "))
+	}
+
+	if c.Backlog == BACKLOG_Queue && c.QueueTimeout <= 0 {
+		return errtrace.Errorf("Queue timeout is required")
+	}
+
+	return nil
+}
+
+// Slots of the accepted connections of one listener
+type connectionLimiter struct {
+	mu     sync.Mutex
+	limits ConnectionLimits
+	active int
+	queued int
+	perIp  map[netip.Addr]int
+	// Closed and replaced whenever a slot frees up, wakes queued clients
+	freed chan struct{}
+
+	rate     tokenBucket
+	rejected struct {
+		connectionLimit atomic.Uint64
+		ipLimit         atomic.Uint64
+		rateLimit       atomic.Uint64
+		queueTimeout    atomic.Uint64
+	}
+}
+
+func newConnectionLimiter(limits ConnectionLimits) *connectionLimiter {
+	c := &connectionLimiter{}
+	c.perIp = map[netip.Addr]int{}
+	c.freed = make(chan struct{})
+	c.set(limits)
+	return c
+}
+
+func (c *connectionLimiter) set(limits ConnectionLimits) {
+	c.mu.Lock()
+	c.limits = limits
+	// Raising the limit may let queued clients in
+	close(c.freed)
+	c.freed = make(chan struct{})
+	c.mu.Unlock()
+
+	c.rate.setRate(uint64(limits.MaxConnectionRate))
+}
+
+// Source IP of a client, invalid for Unix socket clients
+func clientIp(addr net.Addr) netip.Addr {
+	ip, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Addr().Unmap()
+}
+
+// Take a slot for the client, waiting in the queue if the policy says so.
+// Returns the reject reason if the client gets none.
+func (c *connectionLimiter) acquire(ip netip.Addr) string {
+	if c.rate.reserve(1) > 0 {
+		// Give the token back, a rejected client should not slow down others
+		c.rate.reserve(-1)
+		c.rejected.rateLimit.Add(1)
+		return REJECT_RateLimit
+	}
+
+	var timeout <-chan time.Time
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		limits := c.limits
+		if limits.MaxConnectionsPerIp > 0 && ip.IsValid() && c.perIp[ip] >= limits.MaxConnectionsPerIp {
+			c.rejected.ipLimit.Add(1)
+			return REJECT_IpLimit
+		}
+
+		if limits.MaxConnections == 0 || c.active < limits.MaxConnections {
+			c.active++
+			if ip.IsValid() {
+				c.perIp[ip]++
+			}
+			return ""
+		}
+
+		// At most as many clients wait as there are slots
+		if limits.Backlog != BACKLOG_Queue || (timeout == nil && c.queued >= limits.MaxConnections) {
+			c.rejected.connectionLimit.Add(1)
+			return REJECT_ConnectionLimit
+		}
+
+		if timeout == nil {
+			c.queued++
+			defer func() { c.queued-- }()
+			timer := time.NewTimer(limits.QueueTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		freed := c.freed
+		c.mu.Unlock()
+		select {
+		case <-freed:
+			c.mu.Lock()
+		case <-timeout:
+			c.mu.Lock()
+			c.rejected.queueTimeout.Add(1)
+			return REJECT_QueueTimeout
+		}
+	}
+}
+
+func (c *connectionLimiter) release(ip netip.Addr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.active--
+	if ip.IsValid() {
+		c.perIp[ip]--
+		if c.perIp[ip] <= 0 {
+			delete(c.perIp, ip)
+		}
+	}
+
+	close(c.freed)
+	c.freed = make(chan struct{})
+}
+
+func (c *connectionLimiter) rejectStat() RejectStat {
+	return RejectStat{
+		c.rejected.connectionLimit.Load(),
+		c.rejected.ipLimit.Load(),
+		c.rejected.rateLimit.Load(),
+		c.rejected.queueTimeout.Load(),
+	}
+}
+
+// Answer a client turned away for overload with a 503 or a SOCKS5 general
+// failure, so it does not retry right away as it would on a reset
+func (l *LocalListener) rejectOverloaded(conn net.Conn, reason string) {
+	l.Printlnf("Rejected client %s: %s", conn.RemoteAddr(), reason)
+	conn.SetDeadline(time.Now().Add(rejectReplyTimeout))
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	version, err := reader.Peek(1)
+	if err != nil {
+		return
+	}
+
+	if version[0] != socks5.VER_SOCKS5 {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+
+		rwutil.WriteResponseFlush(writer, http.Response{
+			Status:     "503 Listener overloaded: " + reason,
+			StatusCode: http.StatusServiceUnavailable,
+			Proto:      req.Proto,
+			ProtoMajor: req.ProtoMajor,
+			ProtoMinor: req.ProtoMinor,
+			Header:     http.Header{"Retry-After": {"1"}},
+		})
+		return
+	}
+
+	msg, err := socks5.Read_ClientConnect(reader)
+	if err != nil {
+		return
+	}
+
+	// Clients report a refused method or failed authentication as bad
+	// credentials, so whatever method is offered is accepted and the failure
+	// is only reported for the request
+	method := socks5.AUTH_NoAuth
+	if !slices.Contains(msg.Methods, socks5.AUTH_NoAuth) {
+		method = socks5.AUTH_UsernamePassword
+	}
+	err = socks5.Write_SelectMethod(writer, socks5.MSG_SelectMethod{
+		Version: socks5.VER_SOCKS5,
+		Method:  method,
+	})
+	if err != nil {
+		return
+	}
+
+	if method == socks5.AUTH_UsernamePassword {
+		_, err = socks5.Read_AuthUserPass(reader)
+		if err != nil {
+			return
+		}
+
+		// Credentials are not checked, nothing is served anyway
+		err = socks5.Write_AuthUserPassReply(writer, socks5.MSG_AuthUserPassReply{
+			Version: socks5.AUTH_VER_UsernamePassword,
+			Status:  0x00,
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = socks5.Read_Command(reader)
+	if err != nil {
+		return
+	}
+
+	socks5.Write_CommandReply(writer, socks5.MSG_CommandReply{
+		Version:  socks5.VER_SOCKS5,
+		Reply:    socks5.REP_GeneralFailure,
+		AddrType: socks5.ADDR_IPv4,
+		BindAddr: "127.0.0.1",
+		BindPort: 0,
+	})
+}
+
+func (l *LocalListener) SetConnectionLimits(limits ConnectionLimits) error {
+	err := limits.Validate()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	l.mu.Lock()
+	l.ConnectionLimits = limits
+	l.mu.Unlock()
+	l.connLimiter.set(limits)
+
+	Events.Publish(EVENT_ListenerChanged, strconv.Itoa(l.Port), ListenerEvent{l.Port})
+	return nil
+}
+
+func (m *listenerServerManager) SetListenerConnectionLimits(port int, limits ConnectionLimits) error {
+	l, err := m.listener(port)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = l.SetConnectionLimits(limits)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	m.requestSave()
+	return nil
+}
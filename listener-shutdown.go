@@ -0,0 +1,160 @@
+package main
+
+import (
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	// Created, not accepting yet
+	LISTENER_Idle      = "idle"
+	LISTENER_Listening = "listening"
+	// Not accepting, waiting for open connections to finish
+	LISTENER_Draining = "draining"
+	LISTENER_Stopped  = "stopped"
+
+	defaultDrainTimeout = 30 * time.Second
+	acceptRetryDelay    = time.Second
+)
+
+type ListenerStatus struct {
+	State       string
+	Connections int
+	// Open connections are closed at this time, zero unless draining
+	DrainDeadline time.Time
+	Rejected      RejectStat
+}
+
+// Register an accepted connection, false once the listener drains
+func (l *LocalListener) trackConn(c net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.State != LISTENER_Listening {
+		return false
+	}
+	l.conns[c] = struct{}{}
+	l.connsWg.Add(1)
+	return true
+}
+
+func (l *LocalListener) untrackConn(c net.Conn) {
+	l.mu.Lock()
+	delete(l.conns, c)
+	l.mu.Unlock()
+
+	l.connsWg.Done()
+}
+
+// Wait for the open connections until the drain timeout, then close the rest
+func (l *LocalListener) drain() {
+	l.mu.Lock()
+	l.State = LISTENER_Draining
+	l.drainDeadline = time.Now().Add(l.drainTimeout)
+	timeout := l.drainTimeout
+	open := len(l.conns)
+	l.mu.Unlock()
+
+	Events.Publish(EVENT_ListenerChanged, strconv.Itoa(l.Port), ListenerEvent{l.Port})
+	if open > 0 {
+		l.Printlnf("Draining %d connections for up to %s", open, timeout)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		l.connsWg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		l.mu.RLock()
+		open = len(l.conns)
+		for c := range l.conns {
+			c.Close()
+		}
+		l.mu.RUnlock()
+
+		// Forwards waiting on a silent origin only return once the upstream
+		// is closed as well
+		for _, c := range ListenerServerManager.connections.matching(ConnectionFilter{Port: l.Port}) {
+			c.remote.Close()
+		}
+
+		l.Printlnf("Closed %d connections still open after %s", open, timeout)
+		<-drained
+	}
+
+	l.mu.Lock()
+	l.IsServing = false
+	l.State = LISTENER_Stopped
+	l.drainDeadline = time.Time{}
+	close(l.done)
+	l.mu.Unlock()
+
+	l.Printlnf("Stopped")
+	Events.Publish(EVENT_ListenerStopped, strconv.Itoa(l.Port), ListenerEvent{l.Port})
+}
+
+// Set how long open connections may take to finish once the listener context
+// is cancelled. A listener that never served stops right away.
+func (l *LocalListener) stop(timeout time.Duration) {
+	l.mu.Lock()
+	l.drainTimeout = timeout
+	idle := l.State == LISTENER_Idle
+	if idle {
+		l.State = LISTENER_Stopped
+		close(l.done)
+	}
+	l.mu.Unlock()
+
+	if idle {
+		l.Listener.Close()
+		Events.Publish(EVENT_ListenerStopped, strconv.Itoa(l.Port), ListenerEvent{l.Port})
+	}
+}
+
+func (l *LocalListener) Status() ListenerStatus {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return ListenerStatus{l.State, len(l.conns), l.drainDeadline, l.connLimiter.rejectStat()}
+}
+
+// Stop accepting on the listener, it drains in the background
+func (m *listenerServerManager) stopListener(l *ManagedLocalListener) {
+	m.mu.RLock()
+	timeout := m.DrainTimeout
+	m.mu.RUnlock()
+
+	l.Listener.stop(timeout)
+	l.cancel()
+}
+
+func (m *listenerServerManager) ListenerStatus(port int) (ListenerStatus, error) {
+	l, err := m.listener(port)
+	if err != nil {
+		return ListenerStatus{}, errtrace.Wrap(err)
+	}
+	return l.Status(), nil
+}
+
+// Stop every listener and wait until they drained, for application exit
+func (m *listenerServerManager) Shutdown() {
+	m.mu.Lock()
+	m.IsServing = false
+	listeners := slices.Collect(maps.Values(m.Listeners))
+	m.mu.Unlock()
+
+	for _, l := range listeners {
+		m.stopListener(l)
+	}
+	for _, l := range listeners {
+		<-l.Listener.done
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	STRATEGY_Random           = "random"
+	STRATEGY_RoundRobin       = "round-robin"
+	STRATEGY_LeastConnections = "least-connections"
+	STRATEGY_LowestLatency    = "lowest-latency"
+)
+
+var selectionStrategies = []string{STRATEGY_Random, STRATEGY_RoundRobin, STRATEGY_LeastConnections, STRATEGY_LowestLatency}
+
+type ListenerUser struct {
+	Username string
+	Password string
+	// Servers of this user instead of the listener filter, nil to use the
+	// listener filter
+	Filter   *ServerFilter
+	Strategy string
+	Enabled  bool
+}
+
+type ListenerUserStatus struct {
+	ListenerUser
+	Traffic     TrafficStat
+	Connections int
+}
+
+func (u ListenerUser) Validate() error {
+	if u.Username == "" {
+		return errtrace.Errorf("Username is required")
+	}
+
+	if u.Strategy != "" && !slices.Contains(selectionStrategies, u.Strategy) {
+		return errtrace.Errorf("Unknown selection strategy %q, expected one of %s", u.Strategy, strings.Join(selectionStrategies, ", "))
+	}
+
+	return nil
+}
+
+// Replace the user table. Without users the listener accepts clients without
+// auth. Traffic of kept users carries over.
+func (l *LocalListener) SetUsers(users []ListenerUser) error {
+	table := map[string]ListenerUser{}
+	for _, u := range users {
+		err := u.Validate()
+		if err != nil {
+			return errtrace.Wrap(err)
+		}
+		if _, ok := table[u.Username]; ok {
+			return errtrace.Errorf("Duplicate user %s", u.Username)
+		}
+		if u.Strategy == "" {
+			u.Strategy = STRATEGY_Random
+		}
+		table[u.Username] = u
+	}
+
+	l.mu.Lock()
+	l.Users = table
+
+	traffic := map[string]*trafficCounter{}
+	for name := range table {
+		if counter, ok := l.userTraffic[name]; ok {
+			traffic[name] = counter
+		} else {
+			traffic[name] = &trafficCounter{}
+		}
+	}
+	l.userTraffic = traffic
+	l.mu.Unlock()
+
+	Events.Publish(EVENT_ListenerChanged, strconv.Itoa(l.Port), ListenerEvent{l.Port})
+	return nil
+}
+
+func (l *LocalListener) requiresAuth() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.Users) > 0
+}
+
+var ErrAuthFailed = errtrace.New("Wrong username or password")
+
+// Enabled user with the given credentials, and the routing requested through
+// parameters after the username
+func (l *LocalListener) authenticate(username, password string) (ListenerUser, *usernameTargeting, error) {
+	l.mu.RLock()
+	params := l.UsernameParams
+	l.mu.RUnlock()
+
+	name, targeting, err := params.parse(username)
+	if err != nil {
+		return ListenerUser{}, nil, errtrace.Wrap(err)
+	}
+
+	l.mu.RLock()
+	u, ok := l.Users[name]
+	l.mu.RUnlock()
+
+	if !ok || !u.Enabled {
+		return ListenerUser{}, nil, ErrAuthFailed
+	}
+	if subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) != 1 {
+		return ListenerUser{}, nil, ErrAuthFailed
+	}
+
+	filter, _ := l.userSelection(name)
+	_, err = targeting.apply(filter)
+	if err != nil {
+		return ListenerUser{}, nil, errtrace.Wrap(err)
+	}
+
+	return u, targeting, nil
+}
+
+// Parse a Proxy-Authorization header of the Basic scheme
+func parseBasicAuth(header string) (string, string, bool) {
+	scheme, encoded, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "basic") {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+
+	return strings.Cut(string(decoded), ":")
+}
+
+// Filter and strategy for a tunnel of the user, the listener filter is used
+// for clients without auth or users without their own filter
+func (l *LocalListener) userSelection(username string) (ServerFilter, string) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	u, ok := l.Users[username]
+	if !ok {
+		return l.Filter, STRATEGY_Random
+	}
+	if u.Filter == nil {
+		return l.Filter, u.Strategy
+	}
+	return *u.Filter, u.Strategy
+}
+
+func (l *LocalListener) userTrafficCounter(username string) *trafficCounter {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.userTraffic[username]
+}
+
+func (l *LocalListener) userTrafficTotals() map[string]TrafficStat {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	totals := map[string]TrafficStat{}
+	for name, counter := range l.userTraffic {
+		totals[name] = counter.load()
+	}
+	return totals
+}
+
+func (l *LocalListener) restoreUserTraffic(totals map[string]TrafficStat) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for name, counter := range l.userTraffic {
+		counter.restore(totals[name])
+	}
+}
+
+// Users sorted by name
+func (l *LocalListener) userList() []ListenerUser {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return slices.SortedFunc(maps.Values(l.Users), func(a, b ListenerUser) int {
+		return strings.Compare(a.Username, b.Username)
+	})
+}
+
+// Credentials handed out for the listener, those of the first enabled user
+func (l *LocalListener) exportCredentials() (string, string) {
+	for _, u := range l.userList() {
+		if u.Enabled {
+			return u.Username, u.Password
+		}
+	}
+	return "", ""
+}
+
+func (m *listenerServerManager) SetListenerUsers(port int, users []ListenerUser) error {
+	l, err := m.listener(port)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = l.SetUsers(users)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	m.requestSave()
+	return nil
+}
+
+// Users of the listener with their traffic and open tunnels
+func (m *listenerServerManager) ListenerUsers(port int) ([]ListenerUserStatus, error) {
+	l, err := m.listener(port)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	traffic := l.userTrafficTotals()
+	statuses := []ListenerUserStatus{}
+	for _, u := range l.userList() {
+		conns := m.connections.matching(ConnectionFilter{Port: port, User: u.Username})
+		statuses = append(statuses, ListenerUserStatus{u, traffic[u.Username], len(conns)})
+	}
+	return statuses, nil
+}
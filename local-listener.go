@@ -3,14 +3,18 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
-	"go-proxy/common"
 	"go-proxy/protocol/socks5"
 	"go-proxy/rwutil"
+	"io"
 	"net"
 	"net/http"
-	"net/url"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"braces.dev/errtrace"
 	psutilnet "github.com/shirou/gopsutil/v4/net"
@@ -19,132 +23,403 @@ import (
 
 type LocalListener struct {
 	IsServing bool
-	Port      int
-	Listener  net.Listener
-	Auth      *common.ProxyAuth
-	Filter    ServerFilter
-	Stat      ListenerStat
-}
-
-type ListenerStat struct {
-	Sent     uint64
-	Received uint64
+	State     string
+	// Negative for Unix socket listeners, which are keyed by it all the same
+	Port     int
+	Bind     ListenerBind
+	Listener net.Listener
+	Filter   ServerFilter
+	Rules    []RoutingRule
+	// Keyed by username, replaced on change. Clients must authenticate as
+	// one of them unless there are none.
+	Users          map[string]ListenerUser
+	UsernameParams UsernameParamsConfig
+	Acl            ListenerAcl
+	// Applied to clients after the ACL, before the handshake
+	ConnectionLimits ConnectionLimits
+	// Of tunnels opened from now on
+	Timeouts rwutil.TunnelOptions
+
+	RateLimit      RateLimit
+	UserRateLimits map[string]RateLimit
+	Quota          DataQuota
+	UserQuotas     map[string]DataQuota
+
+	traffic      trafficCounter
+	userTraffic  map[string]*trafficCounter
+	limiter      *bandwidthLimiter
+	userLimiters map[string]*bandwidthLimiter
+	// Nil without a quota
+	quotaCounter      *quotaCounter
+	userQuotaCounters map[string]*quotaCounter
+	connLimiter       *connectionLimiter
+
+	// Accepted connections, from the handshake until they close
+	conns         map[net.Conn]struct{}
+	connsWg       sync.WaitGroup
+	drainTimeout  time.Duration
+	drainDeadline time.Time
+	// Closed once stopped
+	done chan struct{}
+
+	// Guards IsServing, State, Filter, Rules, the users, the ACL, the rate
+	// limits, the quotas and the connections
+	mu sync.RWMutex
 }
 
 type IncomingConnection struct {
-	*net.TCPConn
+	net.Conn
 
 	Listener *LocalListener
 	Process  *process.Process
+
+	// Username the client authenticated with, empty without auth
+	User string
+	// Routing requested through username parameters, nil without
+	targeting *usernameTargeting
+	// Source allowlisted to connect without credentials
+	skipAuth bool
+
+	traffic trafficCounter
+	// Server of the open tunnel, nil before a server is picked
+	server atomic.Pointer[ManagedProxyServer]
+	// Nil before a server is picked
+	accounting atomic.Pointer[tunnelAccounting]
+	// Why the tunnel ended, one of the rwutil.TUNNEL_ reasons or
+	// TUNNEL_QuotaExhausted
+	endReason string
+	// Set once a quota ran out mid-tunnel and the connection was closed
+	quotaExhausted atomic.Bool
+}
+
+// Counters and limits of an open tunnel, stored behind one pointer so the
+// copy loops see either all of them or none
+type tunnelAccounting struct {
+	userTraffic *trafficCounter
+	limiters    []*bandwidthLimiter
+	quotas      []*quotaCounter
+	// Quotas not exhausted yet when the tunnel opened, the tunnel is closed
+	// once one of them runs out. Tunnels opened on fallback servers keep
+	// going.
+	enforced []*quotaCounter
+}
+
+// Limiters of the open tunnel, none before a server is picked
+func (c *IncomingConnection) limiters() []*bandwidthLimiter {
+	if a := c.accounting.Load(); a != nil {
+		return a.limiters
+	}
+	return nil
+}
+
+// Tunnels copy through the connection under the meter, so TCP tunnels can
+// be spliced while the bytes are still counted and limited
+func (c *IncomingConnection) Unwrap() net.Conn {
+	return c.Conn
+}
+
+func (c *IncomingConnection) ChunkSize() int {
+	if len(c.limiters()) > 0 {
+		return rateLimitChunk
+	}
+	return 0
+}
+
+// Bytes read are uploaded by the client, bytes written downloaded
+func (c *IncomingConnection) Reserve(read, written int) {
+	waitBandwidth(c.limiters(), read, written)
+}
+
+func (c *IncomingConnection) Count(read, written int) {
+	c.recordTraffic(written, read)
 }
 
 func (c *IncomingConnection) Write(b []byte) (int, error) {
-	n, err := c.TCPConn.Write(b)
-	c.Listener.RecordStat(n, 0)
-	return n, err
+	chunk := c.ChunkSize()
+	if chunk == 0 {
+		chunk = len(b)
+	}
+
+	written := 0
+	for len(b) > 0 {
+		part := b[:min(len(b), chunk)]
+		c.Reserve(0, len(part))
+
+		n, err := c.Conn.Write(part)
+		c.Count(0, n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
 }
 
 func (c *IncomingConnection) Read(b []byte) (int, error) {
-	n, err := c.TCPConn.Read(b)
-	c.Listener.RecordStat(0, n)
+	if chunk := c.ChunkSize(); chunk > 0 && len(b) > chunk {
+		b = b[:chunk]
+	}
+
+	n, err := c.Conn.Read(b)
+	c.Count(n, 0)
+	c.Reserve(n, 0)
 	return n, err
 }
 
+// The net.TCPConn copy fast paths bypass Read and Write, which would skip the
+// traffic counters and rate limits
+type writerOnly struct{ io.Writer }
+type readerOnly struct{ io.Reader }
+
+func (c *IncomingConnection) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(writerOnly{c}, r)
+	return n, errtrace.Wrap(err)
+}
+
+func (c *IncomingConnection) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.Copy(w, readerOnly{c})
+	return n, errtrace.Wrap(err)
+}
+
+// Count bytes on the connection, its listener, its server and the manager
+func (c *IncomingConnection) recordTraffic(sent int, received int) {
+	c.traffic.add(sent, received)
+	c.Listener.traffic.add(sent, received)
+	ListenerServerManager.traffic.add(sent, received)
+
+	if s := c.server.Load(); s != nil {
+		s.traffic.add(sent, received)
+	}
+	if a := c.accounting.Load(); a != nil {
+		if a.userTraffic != nil {
+			a.userTraffic.add(sent, received)
+		}
+		for _, q := range a.quotas {
+			q.add(sent + received)
+		}
+		if !c.quotaExhausted.Load() && slices.ContainsFunc(a.enforced, (*quotaCounter).exhausted) {
+			c.closeExhausted()
+		}
+	}
+}
+
 type DoneCallback func(err error)
 
-func NewLocalListener(port int, auth *common.ProxyAuth, filter ServerFilter) (*LocalListener, error) {
-	listener, err := net.Listen("tcp", net.JoinHostPort("0.0.0.0", strconv.Itoa(port)))
+func NewLocalListener(bind ListenerBind, port int, filter ServerFilter) (*LocalListener, error) {
+	listener, port, err := bind.listen(port)
 	if err != nil {
 		return nil, errtrace.Wrap(err)
 	}
 
 	return &LocalListener{
 		false,
-		listener.Addr().(*net.TCPAddr).Port,
+		LISTENER_Idle,
+		port,
+		bind,
 		listener,
-		auth,
 		filter,
-		ListenerStat{},
+		[]RoutingRule{},
+		map[string]ListenerUser{},
+		UsernameParamsConfig{[]string{}, "-"},
+		ListenerAcl{},
+		NewConnectionLimits(),
+		NewTunnelTimeouts(),
+		RateLimit{},
+		map[string]RateLimit{},
+		DataQuota{},
+		map[string]DataQuota{},
+		trafficCounter{},
+		map[string]*trafficCounter{},
+		newBandwidthLimiter(RateLimit{}),
+		map[string]*bandwidthLimiter{},
+		nil,
+		map[string]*quotaCounter{},
+		newConnectionLimiter(NewConnectionLimits()),
+		map[net.Conn]struct{}{},
+		sync.WaitGroup{},
+		defaultDrainTimeout,
+		time.Time{},
+		make(chan struct{}),
+		sync.RWMutex{},
 	}, nil
 }
 
 func (l *LocalListener) Printlnf(f string, a ...any) {
-	f = fmt.Sprintf("[LocalListener :%d] ", l.Port) + f + "\n"
+	f = fmt.Sprintf("[LocalListener %s] ", l.Endpoint()) + f + "\n"
 	fmt.Printf(f, a...)
 }
 
-func (l *LocalListener) RecordStat(sent int, received int) {
-	common.DataMutex.Lock()
-	l.Stat.Sent += uint64(sent)
-	l.Stat.Received += uint64(received)
-	common.DataMutex.Unlock()
+func (l *LocalListener) Traffic() TrafficStat {
+	return l.traffic.load()
+}
+
+func (l *LocalListener) state() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.State
+}
+
+func (l *LocalListener) currentFilter() ServerFilter {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.Filter
+}
+
+// Copy of the exported fields, safe to read without the lock. Maps and
+// slices are replaced instead of modified and can be shared.
+func (l *LocalListener) snapshot() *LocalListener {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return &LocalListener{
+		IsServing:        l.IsServing,
+		State:            l.State,
+		Port:             l.Port,
+		Bind:             l.Bind,
+		Listener:         l.Listener,
+		Filter:           l.Filter,
+		Rules:            l.Rules,
+		Users:            l.Users,
+		UsernameParams:   l.UsernameParams,
+		Acl:              l.Acl,
+		ConnectionLimits: l.ConnectionLimits,
+		Timeouts:         l.Timeouts,
+		RateLimit:        l.RateLimit,
+		UserRateLimits:   l.UserRateLimits,
+		Quota:            l.Quota,
+		UserQuotas:       l.UserQuotas,
+	}
 }
 
+// Accept clients until the context is cancelled, then drain the open
+// connections. The callback runs once the listener stopped.
 func (l *LocalListener) Serve(ctx context.Context, cb DoneCallback) {
-	if l.IsServing {
+	l.mu.Lock()
+	if l.State != LISTENER_Idle {
+		l.mu.Unlock()
+		cb(nil)
 		return
 	}
-
-	common.DataMutex.Lock()
 	l.IsServing = true
-	common.DataMutex.Unlock()
+	l.State = LISTENER_Listening
+	l.mu.Unlock()
+
+	l.Printlnf("Listening")
+	Events.Publish(EVENT_ListenerStarted, strconv.Itoa(l.Port), ListenerEvent{l.Port})
+
+	stopAccepting := context.AfterFunc(ctx, func() {
+		l.Listener.Close()
+	})
+	defer stopAccepting()
 
-	l.Printlnf("Listening at %d", l.Port)
+	err := l.acceptLoop(ctx)
+	if err != nil {
+		l.Printlnf("Stopped accepting: %+v", err)
+	}
 
-	running := true
+	l.drain()
+	cb(err)
+}
 
-	for running {
+func (l *LocalListener) acceptLoop(ctx context.Context) error {
+	for {
 		c, err := l.Listener.Accept()
+		if ctx.Err() != nil {
+			if err == nil {
+				c.Close()
+			}
+			return nil
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return errtrace.Wrap(err)
+		}
 		if err != nil {
-			cb(errtrace.Wrap(err))
+			// E.g. out of file descriptors, accepting again may succeed later
+			l.Printlnf("Accept error: %+v", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(acceptRetryDelay):
+			}
+			continue
 		}
-		netConn := c.(*net.TCPConn)
 
-		go func() {
-			defer netConn.Close()
+		admitted, skipAuth := l.admit(c.RemoteAddr())
+		if !admitted || !l.trackConn(c) {
+			c.Close()
+			continue
+		}
 
-			addr := netConn.RemoteAddr().String()
-			proc, err := findTcpProcess(addr)
+		go l.handleConn(c, skipAuth)
+	}
+}
 
-			if err != nil || proc == nil {
-				l.Printlnf("Cannot find associated TCP socks for port %s", addr)
-				if err != nil {
-					l.Printlnf("Error: %+v", err)
-				}
-			} else {
-				name, err := proc.Name()
-				if err != nil {
-					l.Printlnf("Error: %+v", err)
-				}
-				l.Printlnf("Found process: %d %s for %s", proc.Pid, name, addr)
-			}
+func (l *LocalListener) handleConn(netConn net.Conn, skipAuth bool) {
+	defer l.untrackConn(netConn)
+	defer netConn.Close()
 
-			conn := &IncomingConnection{
-				netConn,
-				l,
-				proc,
-			}
+	// Before the process lookup, which scans every TCP connection
+	ip := clientIp(netConn.RemoteAddr())
+	reason := l.connLimiter.acquire(ip)
+	if reason != "" {
+		l.rejectOverloaded(netConn, reason)
+		return
+	}
+	defer l.connLimiter.release(ip)
 
-			reader := bufio.NewReader(conn)
-			writer := bufio.NewWriter(conn)
+	addr := netConn.RemoteAddr().String()
 
-			version, err := reader.Peek(1)
-			if err != nil {
-				l.Printlnf("Proxy handler error: %+v", err)
-			}
+	var proc *process.Process
+	var err error
+	if l.Bind.Network == NETWORK_Unix {
+		// Socket clients have no TCP port to look up
+	} else if proc, err = findTcpProcess(addr); err != nil || proc == nil {
+		l.Printlnf("Cannot find associated TCP socks for port %s", addr)
+		if err != nil {
+			l.Printlnf("Error: %+v", err)
+		}
+	} else {
+		name, err := proc.Name()
+		if err != nil {
+			l.Printlnf("Error: %+v", err)
+		}
+		l.Printlnf("Found process: %d %s for %s", proc.Pid, name, addr)
+	}
 
-			switch version[0] {
-			case socks5.VER_SOCKS5:
-				err = l.handleSocks5(conn, reader, writer)
-			default:
-				// If not recognized, it could be HTTP request
-				err = l.handleHttp(conn, reader, writer)
-			}
+	conn := &IncomingConnection{
+		netConn,
+		l,
+		proc,
+		"",
+		nil,
+		skipAuth,
+		trafficCounter{},
+		atomic.Pointer[ManagedProxyServer]{},
+		atomic.Pointer[tunnelAccounting]{},
+		"",
+		atomic.Bool{},
+	}
 
-			if err != nil {
-				l.Printlnf("Proxy handler error: %+v", err)
-			}
-		}()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	version, err := reader.Peek(1)
+	if err != nil {
+		l.Printlnf("Proxy handler error: %+v", err)
+		return
+	}
+
+	switch version[0] {
+	case socks5.VER_SOCKS5:
+		err = l.handleSocks5(conn, reader, writer)
+	default:
+		// If not recognized, it could be HTTP request
+		err = l.handleHttp(conn, reader, writer)
+	}
+
+	if err != nil {
+		l.Printlnf("Proxy handler error: %+v", err)
 	}
 }
 
@@ -178,106 +453,137 @@ func findTcpProcess(addr string) (*process.Process, error) {
 	return proc, errtrace.Wrap(err)
 }
 
+func newHttpResponse(req *http.Request) http.Response {
+	return http.Response{
+		Proto:      req.Proto,
+		ProtoMajor: req.ProtoMajor,
+		ProtoMinor: req.ProtoMinor,
+		Header:     http.Header{},
+	}
+}
+
 func (l *LocalListener) handleHttp(conn *IncomingConnection, reader *bufio.Reader, writer *bufio.Writer) error {
 	req, err := http.ReadRequest(reader)
 	if err != nil {
 		return errtrace.Wrap(err)
 	}
 
-	res := http.Response{
-		Proto:      req.Proto,
-		ProtoMajor: req.ProtoMajor,
-		ProtoMinor: req.ProtoMinor,
+	if req.Method != "CONNECT" {
+		return errtrace.Wrap(l.forwardHttp(conn, req, reader, writer))
 	}
 
-	target := ""
+	target := req.RequestURI
+	res := newHttpResponse(req)
 
-	if req.Method == "CONNECT" {
-		target = req.RequestURI
-	} else {
-		uri, err := url.Parse(req.RequestURI)
-		if err != nil {
-			// Wrong URL format passed - result in 400 Bad Request
-			res.StatusCode = http.StatusBadRequest
-			err := rwutil.WriteResponseFlush(writer, res)
-			return errtrace.Wrap(err)
-		}
+	s, err := l.routeHttp(conn, req, target, writer, nil)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
 
-		if !uri.IsAbs() {
-			// Non-absolute request URI passed
-			// (expect absolute URI for proxy server to proceed)
-			// - result in 400 Bad Request
-			res.StatusCode = http.StatusBadRequest
-			err := rwutil.WriteResponseFlush(writer, res)
-			return errtrace.Wrap(err)
+	remoteConn, err := s.Server.Connect(target)
+	if err != nil {
+		ListenerServerManager.recordConnectError(s, target)
+		res.StatusCode = http.StatusBadGateway
+		er := rwutil.WriteResponseFlush(writer, res)
+		if er != nil {
+			return errtrace.Wrap(er)
 		}
 
-		target = uri.Host
-		if uri.Port() == "" {
-			target += ":80"
-		}
+		return errtrace.Wrap(err)
+	}
 
-		req.RequestURI = uri.RequestURI()
-		if uri.Fragment != "" {
-			req.RequestURI += "#" + uri.Fragment
-		}
+	defer remoteConn.Close()
+	defer l.openTunnel(conn, INBOUND_HttpConnect, s, target, remoteConn)()
+
+	res.StatusCode = http.StatusOK
+	res.Status = "Connection Etablished"
+	err = rwutil.WriteResponseFlush(writer, res)
+	if err != nil {
+		return errtrace.Wrap(err)
 	}
 
-	if l.Auth != nil {
-		auth := req.Header.Get("proxy-authorization")
+	// Clients may send the first bytes along with the CONNECT request
+	err = passBuffered(reader, remoteConn)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
 
-		if auth == "" || !l.Auth.VerifyBasic(auth) {
+	l.tunnel(conn, remoteConn)
+	return nil
+}
+
+// Authenticate the client and pick the server for the request. Refusals are
+// answered here and returned as errors.
+func (l *LocalListener) routeHttp(conn *IncomingConnection, req *http.Request, target string, writer *bufio.Writer, upstream *httpUpstream) (*ManagedProxyServer, error) {
+	res := newHttpResponse(req)
+	res.Close = true
+
+	if l.requiresAuth() && !conn.skipAuth {
+		username, password, _ := parseBasicAuth(req.Header.Get("proxy-authorization"))
+
+		user, targeting, err := l.authenticate(username, password)
+		if err != nil {
+			// The error may quote the client supplied username, it is only
+			// logged by the handler
 			res.StatusCode = http.StatusProxyAuthRequired
 			res.Header.Add("proxy-authenticate", "Basic realm=\"GoProxy\"")
-			err := rwutil.WriteResponseFlush(writer, res)
-			if err != nil {
-				return errtrace.Wrap(err)
+			er := rwutil.WriteResponseFlush(writer, res)
+			if er != nil {
+				return nil, errtrace.Wrap(er)
 			}
-			return nil
+			return nil, errtrace.Wrap(err)
 		}
 
 		req.Header.Del("proxy-authorization")
+		conn.User = user.Username
+		conn.targeting = targeting
 	}
 
-	s, err := ListenerServerManager.GetServer(l.Filter)
-	if err != nil {
-		return errtrace.Wrap(err)
+	keepId := ""
+	if upstream != nil && upstream.user == conn.User && upstream.target == target {
+		keepId = upstream.serverId
 	}
-	if !s.Server.IsPrepared() {
-		err = s.Server.Prepare()
-		if err != nil {
-			return errtrace.Wrap(err)
+
+	s, err := l.route(conn, target, keepId)
+	if errors.Is(err, ErrUserQuotaExhausted) {
+		// Other credentials may still have quota left
+		res.StatusCode = http.StatusProxyAuthRequired
+		res.Status = "407 Data quota exhausted"
+		res.Header.Add("proxy-authenticate", "Basic realm=\"GoProxy\"")
+		er := rwutil.WriteResponseFlush(writer, res)
+		if er != nil {
+			return nil, errtrace.Wrap(er)
 		}
+		return nil, errtrace.Wrap(err)
 	}
-	remoteConn, err := s.Server.Connect(target)
-	if err != nil {
-		res.StatusCode = http.StatusBadGateway
+	if errors.Is(err, ErrQuotaExhausted) {
+		res.StatusCode = http.StatusForbidden
+		res.Status = "403 Data quota exhausted"
 		er := rwutil.WriteResponseFlush(writer, res)
 		if er != nil {
-			return errtrace.Wrap(er)
+			return nil, errtrace.Wrap(er)
 		}
-
-		return errtrace.Wrap(err)
+		return nil, errtrace.Wrap(err)
 	}
-
-	defer remoteConn.Close()
-
-	if req.Method == "CONNECT" {
-		res.StatusCode = http.StatusOK
-		res.Status = "Connection Etablished"
-		err := rwutil.WriteResponseFlush(writer, res)
-		if err != nil {
-			return errtrace.Wrap(err)
+	if errors.Is(err, ErrRouteBlocked) {
+		res.StatusCode = http.StatusForbidden
+		er := rwutil.WriteResponseFlush(writer, res)
+		if er != nil {
+			return nil, errtrace.Wrap(er)
 		}
-	} else {
-		err = req.Write(remoteConn)
+		return nil, errtrace.Wrap(err)
+	}
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	if !s.Server.IsPrepared() {
+		err = s.Server.Prepare()
 		if err != nil {
-			return errtrace.Wrap(err)
+			return nil, errtrace.Wrap(err)
 		}
 	}
-
-	rwutil.TunnelConns(conn, remoteConn)
-	return nil
+	return s, nil
 }
 
 func (l *LocalListener) handleSocks5(conn *IncomingConnection, reader *bufio.Reader, writer *bufio.Writer) error {
@@ -287,7 +593,7 @@ func (l *LocalListener) handleSocks5(conn *IncomingConnection, reader *bufio.Rea
 	}
 
 	authType := socks5.AUTH_NoAuth
-	if l.Auth != nil {
+	if l.requiresAuth() && !conn.skipAuth {
 		authType = socks5.AUTH_UsernamePassword
 	}
 
@@ -321,12 +627,17 @@ func (l *LocalListener) handleSocks5(conn *IncomingConnection, reader *bufio.Rea
 			return errtrace.Wrap(err)
 		}
 
-		if msg.Username != l.Auth.Username || msg.Password != l.Auth.Password {
+		user, targeting, err := l.authenticate(msg.Username, msg.Password)
+		if err != nil {
 			// Non-zero means failure
-			return socks5.Write_AuthUserPassReply(writer, socks5.MSG_AuthUserPassReply{
+			er := socks5.Write_AuthUserPassReply(writer, socks5.MSG_AuthUserPassReply{
 				Version: socks5.AUTH_VER_UsernamePassword,
 				Status:  0xFF,
 			})
+			if er != nil {
+				return errtrace.Wrap(er)
+			}
+			return errtrace.Wrap(err)
 		}
 
 		// 0x00 indicates authentication succeeded
@@ -337,6 +648,8 @@ func (l *LocalListener) handleSocks5(conn *IncomingConnection, reader *bufio.Rea
 		if err != nil {
 			return errtrace.Wrap(err)
 		}
+		conn.User = user.Username
+		conn.targeting = targeting
 	}
 
 	for {
@@ -347,7 +660,22 @@ func (l *LocalListener) handleSocks5(conn *IncomingConnection, reader *bufio.Rea
 
 		switch msg.Command {
 		case socks5.CMD_Connect:
-			s, err := ListenerServerManager.GetServer(l.Filter)
+			target := net.JoinHostPort(msg.DstAddr, strconv.Itoa(int(msg.DstPort)))
+
+			s, err := l.route(conn, target, "")
+			if errors.Is(err, ErrRouteBlocked) || errors.Is(err, ErrQuotaExhausted) || errors.Is(err, ErrUserQuotaExhausted) {
+				er := socks5.Write_CommandReply(writer, socks5.MSG_CommandReply{
+					Version:  socks5.VER_SOCKS5,
+					Reply:    socks5.REP_ConnectionNotAllowed,
+					AddrType: socks5.ADDR_IPv4,
+					BindAddr: "127.0.0.1",
+					BindPort: 0,
+				})
+				if er != nil {
+					return errtrace.Wrap(er)
+				}
+				return errtrace.Wrap(err)
+			}
 			if err != nil {
 				return errtrace.Wrap(err)
 			}
@@ -357,9 +685,10 @@ func (l *LocalListener) handleSocks5(conn *IncomingConnection, reader *bufio.Rea
 					return errtrace.Wrap(err)
 				}
 			}
-			remoteConn, err := s.Server.Connect(net.JoinHostPort(msg.DstAddr, strconv.Itoa(int(msg.DstPort))))
+			remoteConn, err := s.Server.Connect(target)
 
 			if err != nil {
+				ListenerServerManager.recordConnectError(s, target)
 				er := socks5.Write_CommandReply(writer, socks5.MSG_CommandReply{
 					Version:  socks5.VER_SOCKS5,
 					Reply:    socks5.REP_GeneralFailure,
@@ -374,6 +703,7 @@ func (l *LocalListener) handleSocks5(conn *IncomingConnection, reader *bufio.Rea
 			}
 
 			defer remoteConn.Close()
+			defer l.openTunnel(conn, INBOUND_Socks5, s, target, remoteConn)()
 
 			addr := remoteConn.LocalAddr().(*net.TCPAddr)
 			host, port, err := net.SplitHostPort(addr.String())
@@ -401,7 +731,7 @@ func (l *LocalListener) handleSocks5(conn *IncomingConnection, reader *bufio.Rea
 				return errtrace.Wrap(err)
 			}
 
-			rwutil.TunnelConns(conn, remoteConn)
+			l.tunnel(conn, remoteConn)
 			return nil
 		default:
 			return errtrace.Wrap(socks5.Write_CommandReply(writer, socks5.MSG_CommandReply{
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"go-proxy/proxyserver"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"braces.dev/errtrace"
+	"gopkg.in/yaml.v3"
+)
+
+type ProxyExportOptions struct {
+	// One of PROXY_FORMAT_Uri, PROXY_FORMAT_HostPortUserPass, PROXY_FORMAT_Csv,
+	// PROXY_FORMAT_Json or PROXY_FORMAT_Yaml
+	Format string
+	Filter ServerFilter
+
+	// Add country, latency and public IP from the last check. host:port:user:pass
+	// has no room for it, URIs carry it in the fragment.
+	IncludeCheckData bool
+
+	// Protocol advertised for listener endpoints, socks5 when empty
+	EndpointProtocol string
+}
+
+type proxyExportRecord struct {
+	Protocol string `json:"protocol" yaml:"type"`
+	Host     string `json:"host" yaml:"server"`
+	Port     int    `json:"port" yaml:"port"`
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+
+	Country     string    `json:"country,omitempty" yaml:"-"`
+	PublicIp    string    `json:"public_ip,omitempty" yaml:"-"`
+	LatencyMs   int64     `json:"latency_ms,omitempty" yaml:"-"`
+	LastChecked time.Time `json:"last_checked,omitzero" yaml:"-"`
+
+	includeCheckData bool
+}
+
+type clashProxy struct {
+	Name              string `yaml:"name"`
+	proxyExportRecord `yaml:",inline"`
+
+	// Shadowsocks method, the username of other protocols
+	Cipher string `yaml:"cipher,omitempty"`
+}
+
+func newClashProxy(name string, r proxyExportRecord) clashProxy {
+	if r.Protocol != proxyserver.PROTO_Shadowsocks {
+		return clashProxy{name, r, ""}
+	}
+
+	cipher := r.Username
+	r.Protocol = "ss"
+	r.Username = ""
+	return clashProxy{name, r, cipher}
+}
+
+func newProxyExportRecord(s *ManagedProxyServer, opts ProxyExportOptions) proxyExportRecord {
+	r := proxyExportRecord{
+		Protocol:         s.Server.ActiveProtocol(),
+		includeCheckData: opts.IncludeCheckData,
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.Server.RLock()
+	defer s.Server.RUnlock()
+
+	r.Host = s.Server.Host
+	r.Port = s.Server.Port
+	if s.Server.Auth != nil {
+		r.Username = s.Server.Auth.Username
+		r.Password = s.Server.Auth.Password
+	}
+	if r.Protocol == "" {
+		r.Protocol = s.Server.ProtocolHint
+	}
+
+	if opts.IncludeCheckData {
+		r.Country = s.Country
+		r.PublicIp = s.Server.PublicIp
+		r.LatencyMs = s.Server.Latency.Milliseconds()
+		r.LastChecked = s.Server.LastChecked
+	}
+
+	return r
+}
+
+func (r proxyExportRecord) Name() string {
+	name := net.JoinHostPort(r.Host, strconv.Itoa(r.Port))
+	if r.includeCheckData && r.Country != "" {
+		name = r.Country + " " + name
+	}
+	return name
+}
+
+func (r proxyExportRecord) CheckSummary() string {
+	parts := []string{}
+	if r.Country != "" {
+		parts = append(parts, r.Country)
+	}
+	if r.LatencyMs > 0 {
+		parts = append(parts, fmt.Sprintf("%dms", r.LatencyMs))
+	}
+	if r.PublicIp != "" {
+		parts = append(parts, r.PublicIp)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (r proxyExportRecord) Uri() string {
+	uri := url.URL{
+		Scheme: r.Protocol,
+		Host:   net.JoinHostPort(r.Host, strconv.Itoa(r.Port)),
+	}
+	if uri.Scheme == "" {
+		uri.Scheme = proxyserver.PROTO_Socks5
+	}
+	if uri.Scheme == proxyserver.PROTO_Shadowsocks {
+		// SIP002, the method and password are encoded together
+		uri.Scheme = "ss"
+		uri.User = url.User(base64.RawURLEncoding.EncodeToString([]byte(r.Username + ":" + r.Password)))
+	} else if r.Username != "" || r.Password != "" {
+		uri.User = url.UserPassword(r.Username, r.Password)
+	}
+	if r.includeCheckData {
+		uri.Fragment = r.CheckSummary()
+	}
+	return uri.String()
+}
+
+func (r proxyExportRecord) HostPortUserPass() string {
+	line := r.Host + ":" + strconv.Itoa(r.Port)
+	if r.Username != "" || r.Password != "" {
+		line += ":" + r.Username + ":" + r.Password
+	}
+	return line
+}
+
+func encodeProxyExport(records []proxyExportRecord, opts ProxyExportOptions) (string, error) {
+	slices.SortFunc(records, func(a, b proxyExportRecord) int {
+		return cmp.Or(
+			strings.Compare(a.Host, b.Host),
+			cmp.Compare(a.Port, b.Port),
+			strings.Compare(a.Username, b.Username),
+			strings.Compare(a.Password, b.Password),
+		)
+	})
+
+	switch opts.Format {
+	case PROXY_FORMAT_Uri, PROXY_FORMAT_HostPortUserPass:
+		lines := make([]string, 0, len(records))
+		for _, r := range records {
+			if opts.Format == PROXY_FORMAT_Uri {
+				lines = append(lines, r.Uri())
+			} else {
+				lines = append(lines, r.HostPortUserPass())
+			}
+		}
+		return strings.Join(lines, "\n"), nil
+
+	case PROXY_FORMAT_Csv:
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+
+		header := []string{FIELD_Protocol, FIELD_Host, FIELD_Port, FIELD_Username, FIELD_Password}
+		if opts.IncludeCheckData {
+			header = append(header, "country", "public_ip", "latency_ms", "last_checked")
+		}
+		w.Write(header)
+
+		for _, r := range records {
+			row := []string{r.Protocol, r.Host, strconv.Itoa(r.Port), r.Username, r.Password}
+			if opts.IncludeCheckData {
+				lastChecked := ""
+				if !r.LastChecked.IsZero() {
+					lastChecked = r.LastChecked.Format(time.RFC3339)
+				}
+				row = append(row, r.Country, r.PublicIp, strconv.FormatInt(r.LatencyMs, 10), lastChecked)
+			}
+			w.Write(row)
+		}
+
+		w.Flush()
+		return buf.String(), errtrace.Wrap(w.Error())
+
+	case PROXY_FORMAT_Json:
+		content, err := json.MarshalIndent(records, "", "  ")
+		return string(content), errtrace.Wrap(err)
+
+	case PROXY_FORMAT_Yaml:
+		// Clash refers to proxies by name, servers sharing an endpoint with
+		// different credentials are numbered
+		proxies := make([]clashProxy, 0, len(records))
+		taken := map[string]bool{}
+		for _, r := range records {
+			name := r.Name()
+			for n := 2; taken[name]; n++ {
+				name = fmt.Sprintf("%s #%d", r.Name(), n)
+			}
+			taken[name] = true
+			proxies = append(proxies, newClashProxy(name, r))
+		}
+
+		content, err := yaml.Marshal(map[string]any{"proxies": proxies})
+		return string(content), errtrace.Wrap(err)
+	}
+
+	return "", errtrace.Errorf("Unsupported export format %q", opts.Format)
+}
+
+func (m *listenerServerManager) filterServers(filter ServerFilter) []*ManagedProxyServer {
+	if filter.IgnoreAll {
+		return []*ManagedProxyServer{}
+	}
+
+	servers := []*ManagedProxyServer{}
+	for _, s := range m.serverList() {
+		if filter.Matches(s) {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+// Export the upstream servers matching the filter
+func (m *listenerServerManager) ExportServers(opts ProxyExportOptions) (string, error) {
+	servers := m.filterServers(opts.Filter)
+
+	records := make([]proxyExportRecord, 0, len(servers))
+	for _, s := range servers {
+		records = append(records, newProxyExportRecord(s, opts))
+	}
+
+	content, err := encodeProxyExport(records, opts)
+	return content, errtrace.Wrap(err)
+}
+
+// Export the 1:1 listeners of the servers matching the filter, reachable at
+// the given host. Check data comes from the upstream server.
+func (m *listenerServerManager) ExportEndpoints(host string, opts ProxyExportOptions) (string, error) {
+	servers := m.filterServers(opts.Filter)
+
+	protocol := opts.EndpointProtocol
+	if protocol == "" {
+		protocol = proxyserver.PROTO_Socks5
+	}
+	if protocol != proxyserver.PROTO_Socks5 && protocol != proxyserver.PROTO_Http {
+		return "", errtrace.Errorf("Listeners only serve %s and %s", proxyserver.PROTO_Socks5, proxyserver.PROTO_Http)
+	}
+
+	records := make([]proxyExportRecord, 0, len(servers))
+	for _, s := range servers {
+		port, ok := m.dedicatedListenerPort(s.Server.Id)
+		if !ok {
+			continue
+		}
+
+		m.mu.RLock()
+		listener, ok := m.Listeners[port]
+		m.mu.RUnlock()
+		if !ok {
+			continue
+		}
+		listenerHost, ok := listener.Listener.endpointHost(host)
+		if !ok {
+			continue
+		}
+
+		r := newProxyExportRecord(s, opts)
+		r.Protocol = protocol
+		r.Host = listenerHost
+		r.Port = port
+		r.Username, r.Password = listener.Listener.exportCredentials()
+
+		records = append(records, r)
+	}
+
+	content, err := encodeProxyExport(records, opts)
+	return content, errtrace.Wrap(err)
+}
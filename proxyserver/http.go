@@ -29,8 +29,8 @@ func (s *Server) connectHttp(target string) (net.Conn, error) {
 		return nil, errtrace.Wrap(err)
 	}
 
-	if s.Auth != nil {
-		req.Header.Add("proxy-authorization", "Basic "+s.Auth.Base64())
+	if auth := s.CurrentAuth(); auth != nil {
+		req.Header.Add("proxy-authorization", "Basic "+auth.Base64())
 	}
 
 	err = req.Write(conn)
@@ -2,11 +2,16 @@ package proxyserver
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"go-proxy/common"
 	"io"
+	"maps"
 	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -27,11 +32,22 @@ type Server struct {
 
 	Protocols map[string]bool
 
+	// Protocol known up front (e.g. from a socks5:// URI), only this protocol is checked
+	ProtocolHint string
+
 	// Protocol-specific state
 	sshState    *ServerSshState
 	httpState   *ServerHttpState
 	socks5State *ServerSocks5State
 	directState *ServerDirectState
+	ssState     *ServerShadowsocksState
+
+	// Hash of the endpoint and credentials, computed on first use
+	identity string
+
+	// Guards the credentials, identity, check results and protocol-specific
+	// state
+	mu sync.RWMutex
 
 	skipLogging bool
 }
@@ -41,6 +57,8 @@ const (
 	PROTO_Socks5 = "socks5"
 	PROTO_Http   = "http"
 	PROTO_Direct = "direct"
+
+	PROTO_Shadowsocks = "shadowsocks"
 )
 
 // Prepare the connection, pre-authentication, etc. (e.g. connect to SSH server and authenticate)
@@ -70,21 +88,121 @@ func NewServer(host string, port int, auth *common.ProxyAuth) *Server {
 			PROTO_Socks5: false,
 			PROTO_Http:   false,
 			PROTO_Direct: false,
+
+			PROTO_Shadowsocks: false,
 		},
+		"",
 
 		&ServerSshState{},
 		&ServerHttpState{},
 		&ServerSocks5State{},
 		&ServerDirectState{},
+		&ServerShadowsocksState{},
+
+		"",
+
+		sync.RWMutex{},
 
 		false,
 	}
 }
 
+// Lock the credentials and check results (Protocols, PublicIp, Latency,
+// LastChecked) for reading
+func (s *Server) RLock() {
+	s.mu.RLock()
+}
+
+func (s *Server) RUnlock() {
+	s.mu.RUnlock()
+}
+
+func (s *Server) CurrentAuth() *common.ProxyAuth {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Auth
+}
+
+func (s *Server) SetAuth(auth *common.ProxyAuth) {
+	s.mu.Lock()
+	s.Auth = auth
+	s.identity = ""
+	s.mu.Unlock()
+}
+
+// Copy of the exported fields, safe to read without the lock. The credentials
+// are replaced instead of modified and can be shared.
+func (s *Server) Snapshot() *Server {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return &Server{
+		s.Id,
+		s.Host,
+		s.Port,
+		s.Auth,
+		s.Timeout,
+		s.PublicIp,
+		s.Latency,
+		s.LastChecked,
+		maps.Clone(s.Protocols),
+		s.ProtocolHint,
+
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+
+		s.identity,
+
+		sync.RWMutex{},
+
+		s.skipLogging,
+	}
+}
+
+func (s *Server) SetProtocolHint(proto string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ProtocolHint = proto
+	for p := range s.Protocols {
+		s.Protocols[p] = p == proto
+	}
+}
+
 func (s *Server) String() string {
 	return fmt.Sprintf("%s:%d - %s", s.Host, s.Port, s.Id)
 }
 
+func (s *Server) Endpoint() string {
+	return net.JoinHostPort(strings.ToLower(s.Host), strconv.Itoa(s.Port))
+}
+
+// Stable identity of the upstream: same endpoint with the same credentials
+// always yields the same identity, unlike the random Id
+func (s *Server) Identity() string {
+	s.mu.RLock()
+	identity := s.identity
+	s.mu.RUnlock()
+	if identity != "" {
+		return identity
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := s.Endpoint()
+	if s.Auth != nil {
+		key += "|" + s.Auth.String()
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	s.identity = hex.EncodeToString(sum[:16])
+	return s.identity
+}
+
 func (s *Server) Printlnf(f string, a ...any) {
 	if s.skipLogging {
 		return
@@ -120,12 +238,28 @@ func (s *Server) CheckServer() {
 	start := time.Now()
 	isAlive := false
 
-	for proto := range s.Protocols {
+	s.mu.RLock()
+	protos := slices.Collect(maps.Keys(s.Protocols))
+	hint := s.ProtocolHint
+	auth := s.Auth
+	s.mu.RUnlock()
+
+	for _, proto := range protos {
 		if proto == PROTO_Direct {
 			continue
 		}
 
-		copy := NewServer(s.Host, s.Port, s.Auth)
+		if hint != "" && proto != hint {
+			continue
+		}
+
+		// Nothing distinguishes a Shadowsocks server from a closed port, so
+		// it is only checked when known up front
+		if proto == PROTO_Shadowsocks && hint != proto {
+			continue
+		}
+
+		copy := NewServer(s.Host, s.Port, auth)
 		copy.Protocols[proto] = true
 		// copy.skipLogging = true
 
@@ -133,15 +267,15 @@ func (s *Server) CheckServer() {
 		go func(p string, c *Server) {
 			alive := c.CheckAlive()
 
-			common.DataMutex.Lock()
+			s.mu.Lock()
 
 			s.Protocols[p] = alive
 			if alive {
-				s.PublicIp = copy.PublicIp
+				s.PublicIp = c.PublicIp
 				isAlive = true
 			}
 
-			common.DataMutex.Unlock()
+			s.mu.Unlock()
 
 			wg.Done()
 		}(proto, copy)
@@ -149,7 +283,7 @@ func (s *Server) CheckServer() {
 
 	wg.Wait()
 
-	common.DataMutex.Lock()
+	s.mu.Lock()
 
 	if isAlive {
 		s.Latency = time.Since(start)
@@ -158,17 +292,16 @@ func (s *Server) CheckServer() {
 	}
 	s.LastChecked = time.Now()
 
-	common.DataMutex.Unlock()
-
-	common.DataMutex.RLock()
-	protos := ""
-	for proto, supported := range s.Protocols {
-		if supported {
-			protos += "," + proto
+	supported := ""
+	for proto, ok := range s.Protocols {
+		if ok {
+			supported += "," + proto
 		}
 	}
-	common.DataMutex.RUnlock()
-	s.Printlnf("Supported protocols: %s", strings.TrimLeft(protos, ","))
+
+	s.mu.Unlock()
+
+	s.Printlnf("Supported protocols: %s", strings.TrimLeft(supported, ","))
 }
 
 func (s *Server) CheckAlive() bool {
@@ -208,25 +341,38 @@ func (s *Server) CheckAlive() bool {
 		return false
 	}
 
-	common.DataMutex.Lock()
+	s.mu.Lock()
 	s.PublicIp = string(body)
-	common.DataMutex.Unlock()
+	s.mu.Unlock()
 
 	return true
 }
 
-func (s *Server) getHandlers() (PrepareFunc, IsPreparedFunc, ConnectFunc, CleanupFunc) {
-	common.DataMutex.RLock()
-	defer common.DataMutex.RUnlock()
+// Protocol used for connecting, in order of preference among the supported ones
+func (s *Server) ActiveProtocol() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	switch true {
-	case s.Protocols[PROTO_Http]:
+	for _, proto := range []string{PROTO_Http, PROTO_Socks5, PROTO_Ssh, PROTO_Shadowsocks, PROTO_Direct} {
+		if s.Protocols[proto] {
+			return proto
+		}
+	}
+
+	return ""
+}
+
+func (s *Server) getHandlers() (PrepareFunc, IsPreparedFunc, ConnectFunc, CleanupFunc) {
+	switch s.ActiveProtocol() {
+	case PROTO_Http:
 		return s.prepareHttp, s.isPreparedHttp, s.connectHttp, s.cleanupHttp
-	case s.Protocols[PROTO_Socks5]:
+	case PROTO_Socks5:
 		return s.prepareSocks5, s.isPreparedSocks5, s.connectSocks5, s.cleanupSocks5
-	case s.Protocols[PROTO_Ssh]:
+	case PROTO_Ssh:
 		return s.prepareSsh, s.isPreparedSsh, s.connectSsh, s.cleanupSsh
-	case s.Protocols[PROTO_Direct]:
+	case PROTO_Shadowsocks:
+		return s.prepareShadowsocks, s.isPreparedShadowsocks, s.connectShadowsocks, s.cleanupShadowsocks
+	case PROTO_Direct:
 		return s.prepareDirect, s.isPreparedDirect, s.connectDirect, s.cleanupDirect
 	}
 
@@ -0,0 +1,257 @@
+package proxyserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"go-proxy/protocol/socks5"
+	"io"
+	"net"
+	"strconv"
+
+	"braces.dev/errtrace"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+type ServerShadowsocksState struct {
+}
+
+// Largest payload of one AEAD chunk
+const shadowsocksMaxPayload = 0x3FFF
+
+type shadowsocksCipher struct {
+	keySize int
+	new     func(key []byte) (cipher.AEAD, error)
+}
+
+func newAesGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, errtrace.Wrap(err)
+}
+
+// AEAD methods by name, the credentials carry the method as username
+var shadowsocksCiphers = map[string]shadowsocksCipher{
+	"aes-128-gcm":            {16, newAesGcm},
+	"aes-256-gcm":            {32, newAesGcm},
+	"chacha20-ietf-poly1305": {32, chacha20poly1305.New},
+}
+
+func IsShadowsocksMethod(method string) bool {
+	_, ok := shadowsocksCiphers[method]
+	return ok
+}
+
+// Master key from the password, as OpenSSL EVP_BytesToKey with MD5
+func shadowsocksKey(password string, size int) []byte {
+	var key, prev []byte
+	for len(key) < size {
+		h := md5.New()
+		h.Write(prev)
+		h.Write([]byte(password))
+		prev = h.Sum(nil)
+		key = append(key, prev...)
+	}
+	return key[:size]
+}
+
+func (c shadowsocksCipher) session(key, salt []byte) (cipher.AEAD, error) {
+	subkey := make([]byte, c.keySize)
+	_, err := io.ReadFull(hkdf.New(sha1.New, key, salt, []byte("ss-subkey")), subkey)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	aead, err := c.new(subkey)
+	return aead, errtrace.Wrap(err)
+}
+
+// Nonces are little-endian counters, incremented after every seal or open
+func incrementNonce(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+// Connection to a Shadowsocks server, encrypting each direction in chunks
+// under its own salt
+type shadowsocksConn struct {
+	net.Conn
+	cipher shadowsocksCipher
+	key    []byte
+
+	// Sealing side, set up on the first write
+	enc      cipher.AEAD
+	encNonce []byte
+
+	// Opening side, set up once the salt of the server arrived
+	dec      cipher.AEAD
+	decNonce []byte
+	// Opened payload not read yet
+	pending []byte
+}
+
+func (c *shadowsocksConn) Write(b []byte) (int, error) {
+	var out []byte
+	if c.enc == nil {
+		salt := make([]byte, c.cipher.keySize)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return 0, errtrace.Wrap(err)
+		}
+
+		c.enc, err = c.cipher.session(c.key, salt)
+		if err != nil {
+			return 0, errtrace.Wrap(err)
+		}
+		c.encNonce = make([]byte, c.enc.NonceSize())
+		out = salt
+	}
+
+	written := 0
+	for len(b) > 0 {
+		payload := b[:min(len(b), shadowsocksMaxPayload)]
+
+		out = c.enc.Seal(out, c.encNonce, binary.BigEndian.AppendUint16(nil, uint16(len(payload))), nil)
+		incrementNonce(c.encNonce)
+		out = c.enc.Seal(out, c.encNonce, payload, nil)
+		incrementNonce(c.encNonce)
+
+		_, err := c.Conn.Write(out)
+		if err != nil {
+			return written, errtrace.Wrap(err)
+		}
+		written += len(payload)
+		b = b[len(payload):]
+		out = out[:0]
+	}
+	return written, nil
+}
+
+func (c *shadowsocksConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		err := c.readChunk()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *shadowsocksConn) readChunk() error {
+	if c.dec == nil {
+		salt := make([]byte, c.cipher.keySize)
+		_, err := io.ReadFull(c.Conn, salt)
+		if err != nil {
+			return err
+		}
+
+		c.dec, err = c.cipher.session(c.key, salt)
+		if err != nil {
+			return errtrace.Wrap(err)
+		}
+		c.decNonce = make([]byte, c.dec.NonceSize())
+	}
+
+	overhead := c.dec.Overhead()
+	buf := make([]byte, 2+overhead)
+	_, err := io.ReadFull(c.Conn, buf)
+	if err != nil {
+		return err
+	}
+
+	length, err := c.dec.Open(buf[:0], c.decNonce, buf, nil)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	incrementNonce(c.decNonce)
+
+	size := int(binary.BigEndian.Uint16(length)) & shadowsocksMaxPayload
+	buf = make([]byte, size+overhead)
+	_, err = io.ReadFull(c.Conn, buf)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	c.pending, err = c.dec.Open(buf[:0], c.decNonce, buf, nil)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	incrementNonce(c.decNonce)
+	return nil
+}
+
+// Target in the SOCKS5 address format, sent as the first payload
+func shadowsocksAddress(target string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	var addr []byte
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, errtrace.Errorf("Host name %q is too long", host)
+		}
+		addr = append([]byte{socks5.ADDR_DomainName, byte(len(host))}, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		addr = append([]byte{socks5.ADDR_IPv4}, ip4...)
+	} else {
+		addr = append([]byte{socks5.ADDR_IPv6}, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(addr, uint16(port)), nil
+}
+
+func (s *Server) prepareShadowsocks() error {
+	return nil // No preparation needed
+}
+
+func (s *Server) isPreparedShadowsocks() bool { return true }
+
+func (s *Server) connectShadowsocks(target string) (net.Conn, error) {
+	auth := s.CurrentAuth()
+	if auth == nil {
+		return nil, errtrace.Errorf("Shadowsocks requires a method and password")
+	}
+	c, ok := shadowsocksCiphers[auth.Username]
+	if !ok {
+		return nil, errtrace.Errorf("Unsupported Shadowsocks method %q", auth.Username)
+	}
+
+	addr, err := shadowsocksAddress(target)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	netConn, err := net.Dial("tcp", net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	conn := &shadowsocksConn{Conn: netConn, cipher: c, key: shadowsocksKey(auth.Password, c.keySize)}
+	_, err = conn.Write(addr)
+	if err != nil {
+		netConn.Close()
+		return nil, errtrace.Wrap(err)
+	}
+
+	return conn, nil
+}
+
+func (s *Server) cleanupShadowsocks() {}
@@ -89,8 +89,9 @@ func (s *Server) connectAndAuthSocks5() (net.Conn, error) {
 	reader := bufio.NewReader(netConn)
 	writer := bufio.NewWriter(netConn)
 
+	creds := s.CurrentAuth()
 	auth := socks5.AUTH_NoAuth
-	if s.Auth != nil {
+	if creds != nil {
 		auth = socks5.AUTH_UsernamePassword
 	}
 
@@ -111,13 +112,13 @@ func (s *Server) connectAndAuthSocks5() (net.Conn, error) {
 		return nil, errtrace.Errorf("Socks5 returned no acceptable methods")
 	}
 
-	if s.Auth != nil {
+	if creds != nil {
 		err = socks5.Write_AuthUserPass(writer, socks5.MSG_AuthUserPass{
 			Version:  socks5.AUTH_VER_UsernamePassword,
-			UserLen:  byte(len(s.Auth.Username)),
-			Username: s.Auth.Username,
-			PassLen:  byte(len(s.Auth.Password)),
-			Password: s.Auth.Password,
+			UserLen:  byte(len(creds.Username)),
+			Username: creds.Username,
+			PassLen:  byte(len(creds.Password)),
+			Password: creds.Password,
 		})
 		if err != nil {
 			return nil, errtrace.Wrap(err)
@@ -2,7 +2,6 @@ package proxyserver
 
 import (
 	"errors"
-	"go-proxy/common"
 	"io"
 	"net"
 	"strconv"
@@ -17,10 +16,11 @@ type ServerSshState struct {
 
 func (s *Server) prepareSsh() error {
 	s.Printlnf("Connecting to remote server")
+	auth := s.CurrentAuth()
 	c, err := ssh.Dial("tcp", net.JoinHostPort(s.Host, strconv.Itoa(s.Port)), &ssh.ClientConfig{
-		User: s.Auth.Username,
+		User: auth.Username,
 		Auth: []ssh.AuthMethod{
-			ssh.Password(s.Auth.Password),
+			ssh.Password(auth.Password),
 		},
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		Timeout:         s.Timeout,
@@ -32,14 +32,18 @@ func (s *Server) prepareSsh() error {
 	}
 	s.Printlnf("Connection succeeded")
 
-	common.DataMutex.Lock()
+	s.mu.Lock()
 	s.sshState.client = c
-	common.DataMutex.Unlock()
+	s.mu.Unlock()
 
 	return nil
 }
 
-func (s *Server) isPreparedSsh() bool { return s.sshState.client != nil }
+func (s *Server) isPreparedSsh() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sshState.client != nil
+}
 
 func (s *Server) connectSsh(target string) (net.Conn, error) {
 	c, err := s.connectSshRetry(target, 1)
@@ -47,7 +51,11 @@ func (s *Server) connectSsh(target string) (net.Conn, error) {
 }
 
 func (s *Server) connectSshRetry(target string, retries int) (net.Conn, error) {
-	c, err := s.sshState.client.Dial("tcp", target)
+	s.mu.RLock()
+	client := s.sshState.client
+	s.mu.RUnlock()
+
+	c, err := client.Dial("tcp", target)
 
 	if errors.Is(err, io.EOF) && retries > 0 {
 		// EOF means connection closed from remote side
@@ -64,10 +72,10 @@ func (s *Server) connectSshRetry(target string, retries int) (net.Conn, error) {
 }
 
 func (s *Server) cleanupSsh() {
-	common.DataMutex.Lock()
+	s.mu.Lock()
 	if s.sshState.client != nil {
 		s.sshState.client.Close()
 		s.sshState.client = nil
 	}
-	common.DataMutex.Unlock()
+	s.mu.Unlock()
 }
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"container/heap"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	// Longest sleep of the scheduler, also how often eviction is looked at
+	schedulerMaxWait = time.Minute
+)
+
+type RecheckConfig struct {
+	// Multiplier of ServerRecheckInterval for servers with tunnels open or
+	// used within the last interval
+	ActiveFactor float64
+	// Multiplier of ServerRecheckInterval for servers that passed
+	// StableAfterSuccesses checks in a row
+	StableFactor         float64
+	StableAfterSuccesses int
+	// Failing servers back off exponentially up to this delay
+	MaxBackoff time.Duration
+	// Random spread of each delay, as a fraction of it (0.2 = ±20%)
+	Jitter float64
+	// Checks per minute allowed against one host, 0 disables the limit
+	ProviderChecksPerMinute int
+}
+
+func NewRecheckConfig() RecheckConfig {
+	return RecheckConfig{
+		0.5,
+		4,
+		10,
+		time.Hour,
+		0.2,
+		30,
+	}
+}
+
+func (c RecheckConfig) Validate() error {
+	if c.ActiveFactor <= 0 || c.StableFactor <= 0 {
+		return errtrace.Errorf("Recheck factors must be positive")
+	}
+
+	if c.StableAfterSuccesses < 1 {
+		return errtrace.Errorf("Stable threshold must be at least 1 check")
+	}
+
+	if c.MaxBackoff < time.Second {
+		return errtrace.Errorf("Maximum backoff must be at least 1 second")
+	}
+
+	if c.Jitter < 0 || c.Jitter >= 1 {
+		return errtrace.Errorf("Jitter must be between 0 and 1")
+	}
+
+	if c.ProviderChecksPerMinute < 0 {
+		return errtrace.Errorf("Provider rate limit cannot be negative")
+	}
+
+	return nil
+}
+
+type scheduledCheck struct {
+	id string
+	at time.Time
+
+	// The provider slot for this time was already reserved
+	reserved bool
+}
+
+type scheduledChecks []scheduledCheck
+
+func (h scheduledChecks) Len() int           { return len(h) }
+func (h scheduledChecks) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h scheduledChecks) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *scheduledChecks) Push(x any)        { *h = append(*h, x.(scheduledCheck)) }
+func (h *scheduledChecks) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// Time ordered queue of upcoming server checks. Entries are not removed when a
+// server is rescheduled or deleted, stale ones are skipped when they come up.
+type checkSchedule struct {
+	mu        sync.Mutex
+	checks    scheduledChecks
+	wake      chan bool
+	providers map[string]time.Time
+}
+
+func newCheckSchedule() *checkSchedule {
+	return &checkSchedule{
+		sync.Mutex{},
+		scheduledChecks{},
+		make(chan bool, 1),
+		map[string]time.Time{},
+	}
+}
+
+func (q *checkSchedule) push(id string, at time.Time, reserved bool) {
+	q.mu.Lock()
+	heap.Push(&q.checks, scheduledCheck{id, at, reserved})
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- true:
+	default:
+	}
+}
+
+func (q *checkSchedule) popDue(now time.Time) []scheduledCheck {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	due := []scheduledCheck{}
+	for len(q.checks) > 0 && !q.checks[0].at.After(now) {
+		due = append(due, heap.Pop(&q.checks).(scheduledCheck))
+	}
+	return due
+}
+
+func (q *checkSchedule) untilNext(now time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.checks) == 0 {
+		return schedulerMaxWait
+	}
+	return min(q.checks[0].at.Sub(now), schedulerMaxWait)
+}
+
+// Reserve the next check slot against the host, slots are spaced evenly by
+// the rate limit. Returns whether the slot is now, or the later slot time.
+func (q *checkSchedule) reserveProvider(host string, perMinute int, now time.Time) (time.Time, bool) {
+	if perMinute <= 0 {
+		return now, true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	host = strings.ToLower(host)
+	slot := q.providers[host].Add(time.Minute / time.Duration(perMinute))
+	if slot.Before(now) {
+		slot = now
+	}
+
+	q.providers[host] = slot
+	return slot, !slot.After(now)
+}
+
+func withJitter(d time.Duration, jitter float64) time.Duration {
+	if jitter == 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+// Delay until the next check of the server. Must be called with the manager
+// and server locks held.
+func (m *listenerServerManager) nextCheckDelay(s *ManagedProxyServer) time.Duration {
+	cfg := m.Recheck
+	base := m.ServerRecheckInterval
+	delay := base
+
+	switch {
+	case s.isQuarantined():
+		delay = m.Lifecycle.QuarantineRecheckInterval
+	case s.ConsecutiveFailures > 0:
+		delay = cfg.MaxBackoff
+		if s.ConsecutiveFailures < 32 {
+			delay = min(base<<(s.ConsecutiveFailures-1), cfg.MaxBackoff)
+		}
+	case len(s.tunnels) > 0 || time.Since(s.LastUsed) < base:
+		delay = time.Duration(float64(base) * cfg.ActiveFactor)
+	case s.ConsecutiveSuccesses >= cfg.StableAfterSuccesses:
+		delay = time.Duration(float64(base) * cfg.StableFactor)
+	}
+
+	return max(withJitter(delay, cfg.Jitter), time.Second)
+}
+
+func (m *listenerServerManager) scheduleCheck(s *ManagedProxyServer, at time.Time) {
+	m.pushCheck(s, at, false)
+}
+
+func (m *listenerServerManager) pushCheck(s *ManagedProxyServer, at time.Time, reserved bool) {
+	s.mu.Lock()
+	s.NextCheck = at
+	s.mu.Unlock()
+
+	m.schedule.push(s.Server.Id, at, reserved)
+}
+
+func (m *listenerServerManager) scheduleNextCheck(s *ManagedProxyServer) {
+	m.mu.RLock()
+	s.mu.RLock()
+	at := time.Now().Add(m.nextCheckDelay(s))
+	s.mu.RUnlock()
+	m.mu.RUnlock()
+
+	m.scheduleCheck(s, at)
+}
+
+// Schedule the first check of a restored server from its last check, spread
+// by jitter so a restart does not check the whole fleet at once
+func (m *listenerServerManager) scheduleRestoredCheck(s *ManagedProxyServer) {
+	s.Server.RLock()
+	lastChecked := s.Server.LastChecked
+	s.Server.RUnlock()
+
+	m.mu.RLock()
+	s.mu.RLock()
+	at := lastChecked.Add(m.nextCheckDelay(s))
+	spread := withJitter(m.ServerRecheckInterval, m.Recheck.Jitter) - m.ServerRecheckInterval
+	s.mu.RUnlock()
+	m.mu.RUnlock()
+
+	now := time.Now()
+	if at.Before(now) {
+		at = now.Add(max(spread, 0))
+	}
+
+	m.scheduleCheck(s, at)
+}
+
+func (m *listenerServerManager) autoRecheckServers() {
+	for {
+		select {
+		case <-m.schedule.wake:
+		case <-time.After(m.schedule.untilNext(time.Now())):
+		}
+
+		now := time.Now()
+		for _, due := range m.schedule.popDue(now) {
+			m.mu.RLock()
+			s, ok := m.Servers[due.id]
+			perMinute := m.Recheck.ProviderChecksPerMinute
+			m.mu.RUnlock()
+
+			if !ok {
+				// Removed since
+				continue
+			}
+
+			s.mu.RLock()
+			current := s.NextCheck.Equal(due.at)
+			s.mu.RUnlock()
+
+			host := s.Server.Host
+			if !current {
+				// Rescheduled since
+				continue
+			}
+
+			if !due.reserved {
+				if slot, ok := m.schedule.reserveProvider(host, perMinute, now); !ok {
+					m.pushCheck(s, slot, true)
+					continue
+				}
+			}
+
+			s.checkServer()
+		}
+
+		m.evictServers(m.serversToEvict())
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"go-proxy/common"
+	"net"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"braces.dev/errtrace"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	RULE_Domain         = "domain"          // example.com only
+	RULE_DomainSuffix   = "domain-suffix"   // example.com and its subdomains
+	RULE_DomainWildcard = "domain-wildcard" // *.example.com, * matches one label
+	RULE_DomainRegex    = "domain-regex"
+	RULE_Cidr           = "cidr"  // 10.0.0.0/8 or a single IP
+	RULE_Port           = "port"  // 443 or 8000-9000
+	RULE_GeoIp          = "geoip" // destination country code, e.g. US
+
+	ACTION_Direct = "direct"
+	ACTION_Block  = "block"
+	ACTION_Route  = "route"
+
+	ruleResolveTimeout = 5 * time.Second
+)
+
+var ErrRouteBlocked = errtrace.New("Destination blocked by routing rule")
+
+type RoutingRule struct {
+	Match  string
+	Value  string
+	Action string
+	// Servers to route through for ACTION_Route
+	Filter ServerFilter
+
+	regex    *regexp.Regexp
+	prefix   netip.Prefix
+	portFrom int
+	portTo   int
+}
+
+func NewRoutingRule(match, value, action string, filter ServerFilter) (RoutingRule, error) {
+	r := RoutingRule{Match: match, Value: value, Action: action, Filter: filter}
+	err := r.compile()
+	return r, errtrace.Wrap(err)
+}
+
+// Parse a CIDR, or a single IP as a prefix of its full length
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), errtrace.Wrap(err)
+	}
+
+	ip, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, errtrace.Wrap(err)
+	}
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+func (r *RoutingRule) compile() error {
+	switch r.Action {
+	case ACTION_Direct, ACTION_Block, ACTION_Route:
+	default:
+		return errtrace.Errorf("Unknown rule action %q", r.Action)
+	}
+
+	r.Value = strings.TrimSpace(r.Value)
+	if r.Value == "" {
+		return errtrace.Errorf("Empty %s rule", r.Match)
+	}
+
+	var err error
+	switch r.Match {
+	case RULE_Domain, RULE_DomainSuffix:
+		r.Value = normalizeDomain(r.Value)
+	case RULE_DomainWildcard:
+		r.Value = normalizeDomain(r.Value)
+		pattern := strings.ReplaceAll(regexp.QuoteMeta(r.Value), `\*`, `[^.]*`)
+		r.regex, err = regexp.Compile("^" + pattern + "$")
+	case RULE_DomainRegex:
+		r.regex, err = regexp.Compile(r.Value)
+	case RULE_Cidr:
+		r.prefix, err = parsePrefix(r.Value)
+	case RULE_Port:
+		from, to, isRange := strings.Cut(r.Value, "-")
+		r.portFrom, err = strconv.Atoi(strings.TrimSpace(from))
+		r.portTo = r.portFrom
+		if err == nil && isRange {
+			r.portTo, err = strconv.Atoi(strings.TrimSpace(to))
+		}
+		if err == nil && (r.portFrom < 0 || r.portTo > 65535 || r.portFrom > r.portTo) {
+			err = errtrace.Errorf("Invalid port range %q", r.Value)
+		}
+	case RULE_GeoIp:
+		r.Value = strings.ToUpper(r.Value)
+	default:
+		return errtrace.Errorf("Unknown rule match %q", r.Match)
+	}
+
+	return errtrace.Wrap(err)
+}
+
+func normalizeDomain(d string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+}
+
+func (r *RoutingRule) needsIp() bool {
+	return r.Match == RULE_Cidr || r.Match == RULE_GeoIp
+}
+
+func (r *RoutingRule) matchesDomain(domain string) bool {
+	switch r.Match {
+	case RULE_Domain:
+		return domain == r.Value
+	case RULE_DomainSuffix:
+		return domain == r.Value || strings.HasSuffix(domain, "."+r.Value)
+	case RULE_DomainWildcard, RULE_DomainRegex:
+		return r.regex.MatchString(domain)
+	}
+	return false
+}
+
+func (r *RoutingRule) matchesIp(ip netip.Addr) bool {
+	switch r.Match {
+	case RULE_Cidr:
+		return r.prefix.Contains(ip.Unmap())
+	case RULE_GeoIp:
+		country, err := common.GetIpCountry(ip)
+		return err == nil && country == r.Value
+	}
+	return false
+}
+
+// Destination of a tunnel, resolved lazily when an IP based rule needs it
+type routeTarget struct {
+	host string
+	port int
+
+	ips       []netip.Addr
+	resolved  bool
+	literalIp bool
+}
+
+func newRouteTarget(target string) (*routeTarget, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	portInt, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	t := &routeTarget{host: normalizeDomain(host), port: portInt}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		t.ips = []netip.Addr{ip}
+		t.resolved = true
+		t.literalIp = true
+	}
+	return t, nil
+}
+
+func (t *routeTarget) resolve() []netip.Addr {
+	if t.resolved {
+		return t.ips
+	}
+	t.resolved = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), ruleResolveTimeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", t.host)
+	if err == nil {
+		t.ips = ips
+	}
+	return t.ips
+}
+
+func (r *RoutingRule) matches(t *routeTarget) bool {
+	if r.Match == RULE_Port {
+		return t.port >= r.portFrom && t.port <= r.portTo
+	}
+
+	if !r.needsIp() {
+		return !t.literalIp && r.matchesDomain(t.host)
+	}
+
+	for _, ip := range t.resolve() {
+		if r.matchesIp(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Find the first rule matching the target, in order
+func matchRoutingRules(rules []RoutingRule, target string) (*RoutingRule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	t, err := newRouteTarget(target)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	for i := range rules {
+		if rules[i].matches(t) {
+			return &rules[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Pick the server for a tunnel to the target, following the listener rules
+// and falling back to the user or listener filter, narrowed by the username
+// parameters. Returns ErrRouteBlocked for blocked destinations.
+func (l *LocalListener) route(conn *IncomingConnection, target, keepId string) (*ManagedProxyServer, error) {
+	fallback, err := l.checkQuotas(conn.User)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	filter, strategy := l.userSelection(conn.User)
+
+	l.mu.RLock()
+	rules := l.Rules
+	l.mu.RUnlock()
+
+	rule, err := matchRoutingRules(rules, target)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	if rule != nil {
+		switch rule.Action {
+		case ACTION_Direct:
+			return DirectProxy, nil
+		case ACTION_Block:
+			return nil, ErrRouteBlocked
+		case ACTION_Route:
+			filter = rule.Filter
+		}
+	}
+
+	// Exhausted quotas route through the cheaper fallback servers instead
+	if fallback != nil {
+		filter = *fallback
+	}
+
+	filter, err = conn.targeting.apply(filter)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	// Keep-alive forwards stay on the server of their open upstream
+	if keepId != "" && !filter.IgnoreAll {
+		if s, ok := ListenerServerManager.selectableServer(keepId, filter); ok {
+			return s, nil
+		}
+	}
+
+	if conn.targeting == nil || conn.targeting.Session == "" || filter.IgnoreAll {
+		s, err := ListenerServerManager.GetServer(filter, strategy)
+		return s, errtrace.Wrap(err)
+	}
+
+	// Sessions keep their server while it stays selectable
+	key := sessionKey(l.Port, conn.User, conn.targeting.Session)
+	if s, ok := ListenerServerManager.sessionServer(key, filter); ok {
+		return s, nil
+	}
+
+	s, err := ListenerServerManager.GetServer(filter, strategy)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	ListenerServerManager.sessions.set(key, s.Server.Id, conn.targeting.Ttl)
+	return s, nil
+}
+
+func compileRoutingRules(rules []RoutingRule) ([]RoutingRule, error) {
+	compiled := make([]RoutingRule, len(rules))
+	for i, r := range rules {
+		err := r.compile()
+		if err != nil {
+			return nil, errtrace.Errorf("Rule %d: %w", i+1, err)
+		}
+		compiled[i] = r
+	}
+	return compiled, nil
+}
+
+func (l *LocalListener) SetRules(rules []RoutingRule) error {
+	return errtrace.Wrap(l.AddRules(rules, true))
+}
+
+// Append the rules to the current ones, or replace them. Both happen under
+// one lock, so concurrent edits are not lost.
+func (l *LocalListener) AddRules(rules []RoutingRule, replace bool) error {
+	compiled, err := compileRoutingRules(rules)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	l.mu.Lock()
+	if replace {
+		l.Rules = compiled
+	} else {
+		l.Rules = append(slices.Clone(l.Rules), compiled...)
+	}
+	l.mu.Unlock()
+
+	Events.Publish(EVENT_ListenerChanged, strconv.Itoa(l.Port), ListenerEvent{l.Port})
+
+	return nil
+}
+
+func (m *listenerServerManager) SetListenerRules(port int, rules []RoutingRule) error {
+	l, err := m.listener(port)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = l.SetRules(rules)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	m.requestSave()
+	return nil
+}
+
+// Parse a rule list and append the rules to the listener, or replace the
+// current rules. Lines that cannot be parsed are reported and skipped.
+func (m *listenerServerManager) ImportListenerRules(port int, content, action string, filter ServerFilter, replace bool) (RuleImportResult, error) {
+	l, err := m.listener(port)
+	if err != nil {
+		return RuleImportResult{}, errtrace.Wrap(err)
+	}
+
+	result := ParseRuleList(content, action, filter)
+	err = l.AddRules(result.Rules, replace)
+	if err != nil {
+		return result, errtrace.Wrap(err)
+	}
+
+	m.requestSave()
+	return result, nil
+}
+
+type RuleImportResult struct {
+	Rules  []RoutingRule
+	Errors []string
+}
+
+// Clash rule types to rule matches
+var clashRuleMatches = map[string]string{
+	"DOMAIN":          RULE_Domain,
+	"DOMAIN-SUFFIX":   RULE_DomainSuffix,
+	"DOMAIN-KEYWORD":  RULE_DomainRegex,
+	"DOMAIN-REGEX":    RULE_DomainRegex,
+	"DOMAIN-WILDCARD": RULE_DomainWildcard,
+	"IP-CIDR":         RULE_Cidr,
+	"IP-CIDR6":        RULE_Cidr,
+	"GEOIP":           RULE_GeoIp,
+	"DST-PORT":        RULE_Port,
+}
+
+// Parse a rule list into rules with the given action. Supported formats are
+// plain domain/IP/CIDR lists (with +. and *. prefixes), v2ray style
+// domain:/full:/regexp:/keyword: entries and Clash classical rules, either
+// as lines or a rule-provider payload. An action given by a Clash rule
+// (DIRECT, REJECT) takes precedence.
+func ParseRuleList(content, action string, filter ServerFilter) RuleImportResult {
+	result := RuleImportResult{[]RoutingRule{}, []string{}}
+
+	lines := strings.Split(content, "\n")
+
+	var provider struct {
+		Payload []string `yaml:"payload"`
+	}
+	if strings.Contains(content, "payload:") && yaml.Unmarshal([]byte(content), &provider) == nil {
+		lines = provider.Payload
+	}
+
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "!") {
+			continue
+		}
+
+		match, value, lineAction := parseRuleLine(line)
+		if lineAction == "" {
+			lineAction = action
+		}
+
+		r, err := NewRoutingRule(match, value, lineAction, filter)
+		if err != nil {
+			result.Errors = append(result.Errors, "Line "+strconv.Itoa(i+1)+": "+err.Error())
+			continue
+		}
+		result.Rules = append(result.Rules, r)
+	}
+
+	return result
+}
+
+func parseRuleLine(line string) (match, value, action string) {
+	if typ, rest, ok := strings.Cut(line, ","); ok {
+		if m, known := clashRuleMatches[strings.ToUpper(strings.TrimSpace(typ))]; known {
+			parts := strings.Split(rest, ",")
+			value = strings.TrimSpace(parts[0])
+			if strings.EqualFold(typ, "DOMAIN-KEYWORD") {
+				value = regexp.QuoteMeta(strings.ToLower(value))
+			}
+
+			if len(parts) > 1 {
+				switch strings.ToUpper(strings.TrimSpace(parts[1])) {
+				case "DIRECT":
+					action = ACTION_Direct
+				case "REJECT", "REJECT-DROP":
+					action = ACTION_Block
+				}
+			}
+			return m, value, action
+		}
+	}
+
+	if prefix, rest, ok := strings.Cut(line, ":"); ok {
+		switch prefix {
+		case "domain":
+			return RULE_DomainSuffix, rest, ""
+		case "full":
+			return RULE_Domain, rest, ""
+		case "regexp":
+			return RULE_DomainRegex, rest, ""
+		case "keyword":
+			return RULE_DomainRegex, regexp.QuoteMeta(strings.ToLower(rest)), ""
+		}
+	}
+
+	switch {
+	case strings.Contains(line, "/"):
+		return RULE_Cidr, line, ""
+	case strings.HasPrefix(line, "+."):
+		return RULE_DomainSuffix, line[2:], ""
+	case strings.HasPrefix(line, "."):
+		return RULE_DomainSuffix, line[1:], ""
+	case strings.Contains(line, "*"):
+		return RULE_DomainWildcard, line, ""
+	}
+
+	if _, err := netip.ParseAddr(line); err == nil {
+		return RULE_Cidr, line, ""
+	}
+	return RULE_DomainSuffix, line, ""
+}
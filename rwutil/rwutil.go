@@ -3,9 +3,7 @@ package rwutil
 import (
 	"bufio"
 	"io"
-	"net"
 	"net/http"
-	"sync"
 
 	"braces.dev/errtrace"
 )
@@ -65,23 +63,3 @@ func WriteResponseFlush(w *bufio.Writer, r http.Response) error {
 
 	return errtrace.Wrap(w.Flush())
 }
-
-func TunnelConns(a, b net.Conn) {
-	var wg sync.WaitGroup
-
-	wg.Add(2)
-
-	go func() {
-		defer a.Close()
-		defer wg.Done()
-		io.Copy(a, b)
-	}()
-
-	go func() {
-		defer b.Close()
-		defer wg.Done()
-		io.Copy(b, a)
-	}()
-
-	wg.Wait()
-}
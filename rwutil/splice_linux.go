@@ -0,0 +1,148 @@
+package rwutil
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	spliceMove     = 0x1
+	spliceNonblock = 0x2
+
+	// Default capacity of a pipe
+	spliceChunk = 64 * 1024
+)
+
+// Pipe carrying spliced bytes between two sockets, empty while pooled
+type splicePipe struct {
+	r, w int
+}
+
+var pipePool = sync.Pool{
+	New: func() any {
+		var fds [2]int
+		err := syscall.Pipe2(fds[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK)
+		if err != nil {
+			return nil
+		}
+
+		p := &splicePipe{fds[0], fds[1]}
+		// Pooled pipes may be dropped at any GC
+		runtime.AddCleanup(p, func(fds [2]int) {
+			syscall.Close(fds[0])
+			syscall.Close(fds[1])
+		}, fds)
+		return p
+	},
+}
+
+// Move up to max bytes from the socket into the pipe, waiting for data.
+// Returns 0 at EOF.
+func (p *splicePipe) drain(src syscall.RawConn, max int) (int, error) {
+	var n int64
+	var serr error
+	err := src.Read(func(fd uintptr) bool {
+		for {
+			n, serr = syscall.Splice(int(fd), nil, p.w, nil, max, spliceMove|spliceNonblock)
+			if serr != syscall.EINTR {
+				return serr != syscall.EAGAIN
+			}
+		}
+	})
+	if err != nil {
+		return 0, errtrace.Wrap(err)
+	}
+	if serr != nil {
+		return 0, errtrace.Wrap(os.NewSyscallError("splice", serr))
+	}
+	return int(n), nil
+}
+
+// Move n bytes from the pipe into the socket
+func (p *splicePipe) pump(dst syscall.RawConn, n int) (int, error) {
+	written := 0
+	var serr error
+	err := dst.Write(func(fd uintptr) bool {
+		for written < n {
+			m, err := syscall.Splice(p.r, nil, int(fd), nil, n-written, spliceMove|spliceNonblock)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.EAGAIN {
+				return false
+			}
+			if err != nil {
+				serr = err
+				return true
+			}
+			written += int(m)
+		}
+		return true
+	})
+	if err != nil {
+		return written, errtrace.Wrap(err)
+	}
+	if serr != nil {
+		return written, errtrace.Wrap(os.NewSyscallError("splice", serr))
+	}
+	return written, nil
+}
+
+// Copy TCP to TCP inside the kernel, chunk by chunk so the callbacks can
+// count and limit. Returns false without moving anything for other
+// connections.
+func splice(dst, src net.Conn, chunk int, read, wrote func(n int)) (int64, bool, error) {
+	dstTcp, ok := dst.(*net.TCPConn)
+	if !ok {
+		return 0, false, nil
+	}
+	srcTcp, ok := src.(*net.TCPConn)
+	if !ok {
+		return 0, false, nil
+	}
+
+	dstRaw, err := dstTcp.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	srcRaw, err := srcTcp.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+
+	p, ok := pipePool.Get().(*splicePipe)
+	if !ok {
+		return 0, false, nil
+	}
+
+	if chunk <= 0 || chunk > spliceChunk {
+		chunk = spliceChunk
+	}
+
+	var written int64
+	for {
+		n, err := p.drain(srcRaw, chunk)
+		if err != nil {
+			pipePool.Put(p)
+			return written, true, errtrace.Wrap(err)
+		}
+		if n == 0 {
+			pipePool.Put(p)
+			return written, true, nil
+		}
+
+		read(n)
+		m, err := p.pump(dstRaw, n)
+		written += int64(m)
+		wrote(m)
+		if err != nil {
+			// Bytes left in the pipe, it cannot be reused
+			return written, true, errtrace.Wrap(err)
+		}
+	}
+}
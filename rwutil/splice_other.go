@@ -0,0 +1,10 @@
+//go:build !linux
+
+package rwutil
+
+import "net"
+
+// Splicing needs Linux, other systems copy through a buffer
+func splice(dst, src net.Conn, chunk int, read, wrote func(n int)) (int64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,284 @@
+package rwutil
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Connected TCP pair over loopback
+func tcpPair(b testing.TB) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return c, <-accepted
+}
+
+type countingMeter struct {
+	net.Conn
+	read, written atomic.Int64
+}
+
+func (m *countingMeter) Unwrap() net.Conn          { return m.Conn }
+func (m *countingMeter) ChunkSize() int            { return 0 }
+func (m *countingMeter) Reserve(read, written int) {}
+func (m *countingMeter) Count(read, written int) {
+	m.read.Add(int64(read))
+	m.written.Add(int64(written))
+}
+
+// Client -> a, tunnel a <-> b, b -> server
+func benchmarkTunnel(b *testing.B, wrap func(net.Conn) net.Conn, opts TunnelOptions, size int) {
+	payload := make([]byte, size)
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+
+	client, a := tcpPair(b)
+	remote, server := tcpPair(b)
+	a = wrap(a)
+
+	done := make(chan TunnelResult)
+	go func() {
+		done <- TunnelConns(a, remote, opts)
+	}()
+
+	b.ResetTimer()
+	go func() {
+		for range b.N {
+			client.Write(payload)
+		}
+		client.(*net.TCPConn).CloseWrite()
+	}()
+
+	n, err := io.Copy(io.Discard, server)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.StopTimer()
+
+	server.Close()
+	client.Close()
+	result := <-done
+	if n != int64(b.N*size) || result.Forward != n {
+		b.Fatalf("Copied %d bytes, tunnel reported %d, expected %d", n, result.Forward, b.N*size)
+	}
+	if m, ok := a.(*countingMeter); ok && m.read.Load() != n {
+		b.Fatalf("Meter counted %d bytes, expected %d", m.read.Load(), n)
+	}
+}
+
+func BenchmarkTunnelSplice(b *testing.B) {
+	benchmarkTunnel(b, func(c net.Conn) net.Conn { return c }, TunnelOptions{Splice: true}, 64*1024)
+}
+
+func BenchmarkTunnelSpliceMetered(b *testing.B) {
+	benchmarkTunnel(b, func(c net.Conn) net.Conn { return &countingMeter{Conn: c} }, TunnelOptions{Splice: true}, 64*1024)
+}
+
+func BenchmarkTunnelBuffered(b *testing.B) {
+	benchmarkTunnel(b, func(c net.Conn) net.Conn { return c }, TunnelOptions{}, 64*1024)
+}
+
+func BenchmarkTunnelBufferedMetered(b *testing.B) {
+	benchmarkTunnel(b, func(c net.Conn) net.Conn { return &countingMeter{Conn: c} }, TunnelOptions{}, 64*1024)
+}
+
+// Allocations of opening and finishing a tunnel
+func BenchmarkTunnelOpen(b *testing.B) {
+	b.ReportAllocs()
+
+	for range b.N {
+		b.StopTimer()
+		client, a := tcpPair(b)
+		remote, server := tcpPair(b)
+		client.Close()
+		server.Close()
+		b.StartTimer()
+
+		TunnelConns(a, remote, TunnelOptions{})
+	}
+}
+
+// Client -> a, tunnel a <-> b, b -> server, with the tunnel result delivered
+// on the channel
+func startTunnel(t *testing.T, wrap func(net.Conn) net.Conn, opts TunnelOptions) (net.Conn, net.Conn, <-chan TunnelResult) {
+	client, a := tcpPair(t)
+	remote, server := tcpPair(t)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	done := make(chan TunnelResult, 1)
+	go func() {
+		done <- TunnelConns(wrap(a), remote, opts)
+	}()
+	return client, server, done
+}
+
+func tunnelResult(t *testing.T, done <-chan TunnelResult) TunnelResult {
+	t.Helper()
+
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("Tunnel did not end")
+		return TunnelResult{}
+	}
+}
+
+func TestTunnelHalfClose(t *testing.T) {
+	for name, opts := range map[string]TunnelOptions{
+		"splice":   {Splice: true},
+		"buffered": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, server, done := startTunnel(t, func(c net.Conn) net.Conn { return c }, opts)
+
+			client.Write([]byte("request"))
+			client.(*net.TCPConn).CloseWrite()
+
+			// The server sees the end of the request and still answers
+			request, err := io.ReadAll(server)
+			if err != nil || string(request) != "request" {
+				t.Fatalf("Server read %q, %v", request, err)
+			}
+			server.Write([]byte("response"))
+			server.(*net.TCPConn).CloseWrite()
+
+			response, err := io.ReadAll(client)
+			if err != nil || string(response) != "response" {
+				t.Fatalf("Client read %q, %v", response, err)
+			}
+
+			result := tunnelResult(t, done)
+			if result.Reason != TUNNEL_Eof || result.Forward != 7 || result.Backward != 8 {
+				t.Fatalf("Unexpected result %+v", result)
+			}
+		})
+	}
+}
+
+// Connections without a half-close end the tunnel on the first EOF
+func TestTunnelEofWithoutHalfClose(t *testing.T) {
+	client, a := net.Pipe()
+	remote, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan TunnelResult, 1)
+	go func() {
+		done <- TunnelConns(a, remote, TunnelOptions{})
+	}()
+	client.Close()
+
+	_, err := server.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatalf("Expected the server to see the end, got %v", err)
+	}
+	if result := tunnelResult(t, done); result.Reason != TUNNEL_Eof {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+}
+
+func TestTunnelIdleTimeout(t *testing.T) {
+	client, _, done := startTunnel(t, func(c net.Conn) net.Conn { return c }, TunnelOptions{IdleTimeout: 200 * time.Millisecond})
+
+	// Activity pushes the timeout back
+	start := time.Now()
+	for range 3 {
+		time.Sleep(100 * time.Millisecond)
+		client.Write([]byte("x"))
+	}
+
+	result := tunnelResult(t, done)
+	if result.Reason != TUNNEL_IdleTimeout {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("Closed after %s despite activity", elapsed)
+	}
+}
+
+func TestTunnelLifetime(t *testing.T) {
+	client, _, done := startTunnel(t, func(c net.Conn) net.Conn { return c }, TunnelOptions{
+		IdleTimeout: time.Second,
+		MaxLifetime: 300 * time.Millisecond,
+	})
+
+	// Busy tunnels end as well
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(50 * time.Millisecond):
+				client.Write([]byte("x"))
+			}
+		}
+	}()
+
+	start := time.Now()
+	result := tunnelResult(t, done)
+	if result.Reason != TUNNEL_Lifetime {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Closed after %s", elapsed)
+	}
+}
+
+func TestTunnelClosed(t *testing.T) {
+	var a net.Conn
+	ready := make(chan struct{})
+	_, _, done := startTunnel(t, func(c net.Conn) net.Conn {
+		a = c
+		close(ready)
+		return c
+	}, TunnelOptions{})
+
+	<-ready
+	time.Sleep(50 * time.Millisecond)
+	a.Close()
+
+	if result := tunnelResult(t, done); result.Reason != TUNNEL_Closed {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+}
+
+var errBroken = errors.New("broken")
+
+// Fails every read
+type brokenConn struct {
+	net.Conn
+}
+
+func (c brokenConn) Read(b []byte) (int, error) { return 0, errBroken }
+
+func TestTunnelError(t *testing.T) {
+	_, _, done := startTunnel(t, func(c net.Conn) net.Conn { return brokenConn{c} }, TunnelOptions{})
+
+	result := tunnelResult(t, done)
+	if result.Reason != TUNNEL_Error || !errors.Is(result.Err, errBroken) {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	ADMIN_Active   = "active"
+	ADMIN_Disabled = "disabled"
+	// No new connections, existing tunnels run until they finish or the drain
+	// deadline passes, then the server becomes disabled
+	ADMIN_Draining = "draining"
+)
+
+var adminStates = []string{ADMIN_Active, ADMIN_Disabled, ADMIN_Draining}
+
+// Must be called with the server lock held
+func (s *ManagedProxyServer) isSelectable() bool {
+	return s.AdminState == ADMIN_Active && !s.isQuarantined()
+}
+
+// Register a tunnel opened through the server, so draining can wait for it
+// and close it after the deadline
+func (s *ManagedProxyServer) trackTunnel(c io.Closer) {
+	s.mu.Lock()
+	s.tunnels[c] = true
+	s.ActiveTunnels = len(s.tunnels)
+	s.LastUsed = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *ManagedProxyServer) untrackTunnel(c io.Closer) {
+	s.mu.Lock()
+	delete(s.tunnels, c)
+	s.ActiveTunnels = len(s.tunnels)
+	s.mu.Unlock()
+}
+
+// Set the administrative state of every server matching the filter. Draining
+// servers are disabled once their tunnels finish or after drainTimeout.
+func (m *listenerServerManager) SetServersState(filter ServerFilter, state string, drainTimeout time.Duration) (int, error) {
+	if !slices.Contains(adminStates, state) {
+		return 0, errtrace.Errorf("Unknown server state %q", state)
+	}
+
+	if state == ADMIN_Draining && drainTimeout <= 0 {
+		return 0, errtrace.Errorf("Drain timeout must be positive")
+	}
+
+	servers := m.filterServers(filter)
+
+	for _, s := range servers {
+		s.mu.Lock()
+		if state == ADMIN_Draining && s.AdminState != ADMIN_Active {
+			// Nothing to drain for servers already out of rotation
+			s.mu.Unlock()
+			continue
+		}
+
+		s.AdminState = state
+		s.DrainDeadline = time.Time{}
+		if state == ADMIN_Draining {
+			s.DrainDeadline = time.Now().Add(drainTimeout)
+		}
+		s.mu.Unlock()
+	}
+	m.refreshSelection()
+
+	for _, s := range servers {
+		Events.Publish(EVENT_ServerChanged, s.Server.Id, ServerEvent{s.Server.Id})
+	}
+
+	m.requestSave()
+	return len(servers), nil
+}
+
+// Disable draining servers without tunnels left, and force close the tunnels
+// of the ones past their deadline
+func (m *listenerServerManager) finishDrains() {
+	now := time.Now()
+	toClose := []io.Closer{}
+	drained := []string{}
+
+	for _, s := range m.serverList() {
+		s.mu.Lock()
+		if s.AdminState != ADMIN_Draining {
+			s.mu.Unlock()
+			continue
+		}
+
+		if len(s.tunnels) > 0 && now.Before(s.DrainDeadline) {
+			s.mu.Unlock()
+			continue
+		}
+
+		for c := range s.tunnels {
+			toClose = append(toClose, c)
+		}
+
+		s.Server.Printlnf("Drained, %d tunnels force closed", len(s.tunnels))
+		s.AdminState = ADMIN_Disabled
+		s.DrainDeadline = time.Time{}
+		drained = append(drained, s.Server.Id)
+		s.mu.Unlock()
+	}
+
+	for _, c := range toClose {
+		c.Close()
+	}
+
+	for _, id := range drained {
+		Events.Publish(EVENT_ServerChanged, id, ServerEvent{id})
+	}
+
+	if len(drained) > 0 {
+		m.requestSave()
+	}
+}
+
+func (m *listenerServerManager) autoFinishDrains() {
+	for {
+		<-time.After(time.Second)
+		m.finishDrains()
+	}
+}
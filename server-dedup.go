@@ -0,0 +1,290 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	// Drop the new server when its endpoint is already known
+	DUPLICATE_Skip = "skip"
+	// Update the credentials of the known server with the same endpoint
+	DUPLICATE_UpdateCredentials = "update"
+	// Keep servers sharing an endpoint with different credentials as separate
+	// servers (e.g. sessions of a rotating gateway)
+	DUPLICATE_KeepBoth = "keep"
+)
+
+var duplicatePolicies = []string{DUPLICATE_Skip, DUPLICATE_UpdateCredentials, DUPLICATE_KeepBoth}
+
+type AddServersResult struct {
+	Added   int
+	Merged  int
+	Updated int
+}
+
+type MergeDuplicatesResult struct {
+	Groups  int
+	Removed int
+}
+
+// Known servers by identity and endpoint, so a batch of new servers is
+// checked for duplicates without scanning every known server for each
+type duplicateIndex struct {
+	byIdentity map[string]*ManagedProxyServer
+	byEndpoint map[string]*ManagedProxyServer
+}
+
+// Must be called with the manager lock held
+func (m *listenerServerManager) newDuplicateIndex() *duplicateIndex {
+	index := &duplicateIndex{
+		make(map[string]*ManagedProxyServer, len(m.Servers)),
+		make(map[string]*ManagedProxyServer, len(m.Servers)),
+	}
+	for _, s := range m.Servers {
+		index.add(s)
+	}
+	return index
+}
+
+func (index *duplicateIndex) add(s *ManagedProxyServer) {
+	index.byIdentity[s.Server.Identity()] = s
+
+	endpoint := s.Server.Endpoint()
+	if _, ok := index.byEndpoint[endpoint]; !ok {
+		index.byEndpoint[endpoint] = s
+	}
+}
+
+// Find the known server that the new one duplicates under the policy. Servers
+// with the same identity are always duplicates, regardless of the policy.
+func (index *duplicateIndex) find(s *ManagedProxyServer, policy string) *ManagedProxyServer {
+	if existing, ok := index.byIdentity[s.Server.Identity()]; ok {
+		return existing
+	}
+
+	if policy == DUPLICATE_KeepBoth {
+		return nil
+	}
+	return index.byEndpoint[s.Server.Endpoint()]
+}
+
+// Merge the tags and traffic of a duplicate into the kept server, the kept
+// server keeps its own check results
+func (s *ManagedProxyServer) absorb(dup *ManagedProxyServer) {
+	dup.mu.RLock()
+	tags := dup.Tags
+	country := dup.Country
+	dup.mu.RUnlock()
+
+	s.traffic.merge(dup.traffic.load())
+
+	s.mu.Lock()
+	merged := maps.Clone(s.Tags)
+	for t, has := range tags {
+		if has {
+			merged[t] = true
+		}
+	}
+	s.Tags = merged
+
+	if s.Country == "" {
+		s.Country = country
+	}
+	s.mu.Unlock()
+}
+
+func (m *listenerServerManager) SetDuplicatePolicy(policy string) error {
+	if !slices.Contains(duplicatePolicies, policy) {
+		return errtrace.Errorf("Unknown duplicate policy %q, expected one of %s", policy, strings.Join(duplicatePolicies, ", "))
+	}
+
+	m.mu.Lock()
+	m.DuplicatePolicy = policy
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Merge servers already inside the manager that share an identity, and
+// optionally servers that exit through the same public IP. The kept server of
+// each group is the alive one with the oldest Id.
+func (m *listenerServerManager) MergeDuplicates(sameExitIp bool) MergeDuplicatesResult {
+	result := MergeDuplicatesResult{}
+
+	m.mu.Lock()
+
+	// Union-find over server ids, so identity and exit IP groups can overlap
+	parent := map[string]string{}
+	var find func(id string) string
+	find = func(id string) string {
+		if parent[id] != id {
+			parent[id] = find(parent[id])
+		}
+		return parent[id]
+	}
+	union := func(a, b string) {
+		parent[find(a)] = find(b)
+	}
+
+	byIdentity := map[string]string{}
+	byExitIp := map[string]string{}
+	for id, s := range m.Servers {
+		parent[id] = id
+
+		identity := s.Server.Identity()
+		if other, ok := byIdentity[identity]; ok {
+			union(id, other)
+		} else {
+			byIdentity[identity] = id
+		}
+
+		s.Server.RLock()
+		publicIp := s.Server.PublicIp
+		s.Server.RUnlock()
+
+		if !sameExitIp || publicIp == "" {
+			continue
+		}
+		if other, ok := byExitIp[publicIp]; ok {
+			union(id, other)
+		} else {
+			byExitIp[publicIp] = id
+		}
+	}
+
+	groups := map[string][]*ManagedProxyServer{}
+	for id, s := range m.Servers {
+		root := find(id)
+		groups[root] = append(groups[root], s)
+	}
+
+	remap := map[string]string{}
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+
+		slices.SortFunc(group, func(a, b *ManagedProxyServer) int {
+			aliveA, aliveB := a.isAlive(), b.isAlive()
+			if aliveA != aliveB {
+				if aliveA {
+					return -1
+				}
+				return 1
+			}
+			// UUIDv7 ids sort by creation time
+			return strings.Compare(a.Server.Id, b.Server.Id)
+		})
+
+		kept := group[0]
+		for _, dup := range group[1:] {
+			m.sampler.shift(trafficKey(TRAFFIC_Server, kept.Server.Id), dup.traffic.load())
+			kept.absorb(dup)
+			m.usage.mergeServer(dup.Server.Id, kept.Server.Id)
+
+			dup.mu.RLock()
+			subscriptionId := dup.SubscriptionId
+			dup.mu.RUnlock()
+			if subscriptionId != "" {
+				kept.adoptBy(subscriptionId)
+			}
+
+			remap[dup.Server.Id] = kept.Server.Id
+		}
+
+		result.Groups++
+		result.Removed += len(group) - 1
+	}
+
+	// Point the listeners at the kept servers before the duplicates are gone
+	if len(remap) > 0 {
+		for _, l := range m.Listeners {
+			l.Listener.remapServerIds(remap)
+		}
+	}
+
+	m.mu.Unlock()
+
+	for _, keptId := range remap {
+		Events.Publish(EVENT_ServerChanged, keptId, ServerEvent{keptId})
+	}
+
+	m.RemoveServers(slices.Collect(maps.Keys(remap)))
+	return result
+}
+
+// Filter with the ids of merged duplicates replaced by their kept servers
+func (f ServerFilter) remapped(remap map[string]string) ServerFilter {
+	if len(f.ServerIds) == 0 {
+		return f
+	}
+
+	ids := make(map[string]bool, len(f.ServerIds))
+	for id := range f.ServerIds {
+		if keptId, ok := remap[id]; ok {
+			id = keptId
+		}
+		ids[id] = true
+	}
+	f.ServerIds = ids
+	return f
+}
+
+// Remap every stored filter of the listener: its own, those of its users,
+// rules and quota fallbacks. A dedicated listener keeps its filter, it goes
+// away with its server. Collections are replaced since snapshots share them.
+func (l *LocalListener) remapServerIds(remap map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !isDedicatedFilter(l.Filter) {
+		l.Filter = l.Filter.remapped(remap)
+	}
+
+	users := make(map[string]ListenerUser, len(l.Users))
+	for name, u := range l.Users {
+		if u.Filter != nil {
+			filter := u.Filter.remapped(remap)
+			u.Filter = &filter
+		}
+		users[name] = u
+	}
+	l.Users = users
+
+	rules := slices.Clone(l.Rules)
+	for i := range rules {
+		rules[i].Filter = rules[i].Filter.remapped(remap)
+	}
+	l.Rules = rules
+
+	l.Quota.FallbackFilter = l.Quota.FallbackFilter.remapped(remap)
+	if l.quotaCounter != nil {
+		l.quotaCounter.setQuota(l.Quota)
+	}
+
+	userQuotas := make(map[string]DataQuota, len(l.UserQuotas))
+	for name, q := range l.UserQuotas {
+		q.FallbackFilter = q.FallbackFilter.remapped(remap)
+		userQuotas[name] = q
+		if c, ok := l.userQuotaCounters[name]; ok {
+			c.setQuota(q)
+		}
+	}
+	l.UserQuotas = userQuotas
+}
+
+func (s *ManagedProxyServer) isAlive() bool {
+	s.Server.RLock()
+	defer s.Server.RUnlock()
+
+	for _, supported := range s.Server.Protocols {
+		if supported {
+			return true
+		}
+	}
+	return false
+}
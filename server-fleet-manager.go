@@ -5,10 +5,12 @@ import (
 	"go-proxy/common"
 	"go-proxy/proxyserver"
 	"go-proxy/threadpool"
-	"iter"
+	"io"
 	"maps"
 	"net/netip"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"braces.dev/errtrace"
@@ -24,16 +26,74 @@ type ManagedLocalListener struct {
 type ManagedProxyServer struct {
 	Server *proxyserver.Server
 
-	Tags map[string]bool
+	// Replaced on change and never modified in place, so selection snapshots
+	// can share it
+	Tags    map[string]bool
+	Country string
+
+	Lifecycle            string
+	ConsecutiveFailures  int
+	ConsecutiveSuccesses int
+	QuarantinedAt        time.Time
+
+	NextCheck time.Time
+	LastUsed  time.Time
+
+	AdminState    string
+	DrainDeadline time.Time
+	ActiveTunnels int
+
+	RateLimit RateLimit
+
+	tunnels map[io.Closer]bool
+	traffic trafficCounter
+	limiter *bandwidthLimiter
+
+	// Set when the server is owned by a subscription and synced from its source
+	SubscriptionId string
+
+	// Guards the fields above, the proxy server has its own lock. Taken after
+	// the manager lock when both are needed.
+	mu sync.RWMutex
 }
 
 type listenerServerManager struct {
-	Listeners map[int]*ManagedLocalListener
-	Servers   map[string]*ManagedProxyServer
+	Listeners     map[int]*ManagedLocalListener
+	Servers       map[string]*ManagedProxyServer
+	Subscriptions map[string]*Subscription
+
+	// Evicted servers kept out of rotation, restorable by hand
+	ArchivedServers map[string]*ManagedProxyServer
 
 	ServerRecheckInterval time.Duration
+	DuplicatePolicy       string
+	Lifecycle             LifecycleConfig
+	Recheck               RecheckConfig
+	DrainTimeout          time.Duration
 	IsServing             bool
 	Wg                    sync.WaitGroup
+
+	store      *ConfigStore
+	usageStore *UsageStore
+	schedule   *checkSchedule
+	sampler    *trafficSampler
+	// Open tunnels of every listener
+	connections *connectionTable
+	usage       *usageStats
+	sessions    *sessionTable
+
+	// Bytes through every listener, counted since the first start
+	traffic trafficCounter
+
+	// Servers open for selection, rebuilt on change so tunnels pick a server
+	// without taking any lock
+	selection   atomic.Pointer[serverSelection]
+	selectionMu sync.Mutex
+	// Turn counter of the round-robin strategy
+	roundRobin atomic.Uint64
+
+	// Guards the maps and settings above
+	mu sync.RWMutex
 }
 
 type ServerFilter struct {
@@ -42,10 +102,7 @@ type ServerFilter struct {
 	IgnoreAll bool
 }
 
-var DirectProxy = &ManagedProxyServer{
-	proxyserver.NewDirectServer(),
-	map[string]bool{},
-}
+var DirectProxy = NewManagedProxyServer(proxyserver.NewDirectServer())
 
 var ListenerServerManager = NewListenerServerManager()
 
@@ -53,28 +110,167 @@ func NewListenerServerManager() (s *listenerServerManager) {
 	s = &listenerServerManager{
 		map[int]*ManagedLocalListener{},
 		map[string]*ManagedProxyServer{},
+		map[string]*Subscription{},
+		map[string]*ManagedProxyServer{},
 		60 * time.Second,
+		DUPLICATE_UpdateCredentials,
+		NewLifecycleConfig(),
+		NewRecheckConfig(),
+		defaultDrainTimeout,
 		false,
 		sync.WaitGroup{},
+		nil,
+		nil,
+		newCheckSchedule(),
+		newTrafficSampler(),
+		newConnectionTable(),
+		newUsageStats(),
+		newSessionTable(),
+		trafficCounter{},
+		atomic.Pointer[serverSelection]{},
+		sync.Mutex{},
+		atomic.Uint64{},
+		sync.RWMutex{},
 	}
+	s.selection.Store(&serverSelection{})
 	return s
 }
 
+func NewManagedProxyServer(s *proxyserver.Server) *ManagedProxyServer {
+	return &ManagedProxyServer{
+		s,
+		map[string]bool{},
+		"",
+		LIFECYCLE_Active,
+		0,
+		0,
+		time.Time{},
+		time.Time{},
+		time.Time{},
+		ADMIN_Active,
+		time.Time{},
+		0,
+		RateLimit{},
+		map[io.Closer]bool{},
+		trafficCounter{},
+		newBandwidthLimiter(RateLimit{}),
+		"",
+		sync.RWMutex{},
+	}
+}
+
 func (s *ManagedProxyServer) AddTags(tags ...string) {
-	common.DataMutex.Lock()
-	defer common.DataMutex.Unlock()
+	s.replaceTags(nil, tags)
+}
 
-	for _, t := range tags {
-		s.Tags[t] = true
+// Remove some tags and add others in one change, tags in both are kept
+func (s *ManagedProxyServer) replaceTags(remove, add []string) {
+	s.mu.Lock()
+	changed := false
+	for _, t := range remove {
+		changed = changed || (s.Tags[t] && !slices.Contains(add, t))
+	}
+	for _, t := range add {
+		changed = changed || !s.Tags[t]
+	}
+	if changed {
+		next := maps.Clone(s.Tags)
+		for _, t := range remove {
+			delete(next, t)
+		}
+		for _, t := range add {
+			next[t] = true
+		}
+		s.Tags = next
+	}
+	event := TagsChangedEvent{s.Server.Id, s.tagList()}
+	s.mu.Unlock()
+
+	if changed {
+		ListenerServerManager.refreshSelection()
+		Events.Publish(EVENT_TagsChanged, event.ServerId, event)
+	}
+}
+
+// Copy of the exported fields, safe to read without the locks
+func (s *ManagedProxyServer) snapshot() *ManagedProxyServer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return &ManagedProxyServer{
+		Server:               s.Server.Snapshot(),
+		Tags:                 s.Tags,
+		Country:              s.Country,
+		Lifecycle:            s.Lifecycle,
+		ConsecutiveFailures:  s.ConsecutiveFailures,
+		ConsecutiveSuccesses: s.ConsecutiveSuccesses,
+		QuarantinedAt:        s.QuarantinedAt,
+		NextCheck:            s.NextCheck,
+		LastUsed:             s.LastUsed,
+		AdminState:           s.AdminState,
+		DrainDeadline:        s.DrainDeadline,
+		ActiveTunnels:        s.ActiveTunnels,
+		RateLimit:            s.RateLimit,
+		SubscriptionId:       s.SubscriptionId,
 	}
 }
 
+// Copy of the manager state for the frontend, which serializes it after the
+// locks are released
+func (m *listenerServerManager) snapshot() *listenerServerManager {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	view := &listenerServerManager{
+		Listeners:             make(map[int]*ManagedLocalListener, len(m.Listeners)),
+		Servers:               make(map[string]*ManagedProxyServer, len(m.Servers)),
+		Subscriptions:         make(map[string]*Subscription, len(m.Subscriptions)),
+		ArchivedServers:       make(map[string]*ManagedProxyServer, len(m.ArchivedServers)),
+		ServerRecheckInterval: m.ServerRecheckInterval,
+		DuplicatePolicy:       m.DuplicatePolicy,
+		Lifecycle:             m.Lifecycle,
+		Recheck:               m.Recheck,
+		DrainTimeout:          m.DrainTimeout,
+		IsServing:             m.IsServing,
+	}
+	for port, l := range m.Listeners {
+		view.Listeners[port] = &ManagedLocalListener{Listener: l.Listener.snapshot()}
+	}
+	for id, s := range m.Servers {
+		view.Servers[id] = s.snapshot()
+	}
+	for id, s := range m.ArchivedServers {
+		view.ArchivedServers[id] = s.snapshot()
+	}
+	for id, sub := range m.Subscriptions {
+		copied := *sub
+		view.Subscriptions[id] = &copied
+	}
+	return view
+}
+
+// Sorted list of the set tags. Must be called with the server lock held.
+func (s *ManagedProxyServer) tagList() []string {
+	tags := []string{}
+	for t, has := range s.Tags {
+		if has {
+			tags = append(tags, t)
+		}
+	}
+	slices.Sort(tags)
+	return tags
+}
+
 func (s *ManagedProxyServer) HasAllTags(tags []string) bool {
-	common.DataMutex.RLock()
-	defer common.DataMutex.RUnlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return hasAllTags(s.Tags, tags)
+}
 
+func hasAllTags(set map[string]bool, tags []string) bool {
 	for _, t := range tags {
-		hasTag, ok := s.Tags[t]
+		hasTag, ok := set[t]
 		if !ok || !hasTag {
 			return false
 		}
@@ -83,68 +279,192 @@ func (s *ManagedProxyServer) HasAllTags(tags []string) bool {
 	return true
 }
 
-func (m *listenerServerManager) AddServers(servers []*proxyserver.Server) error {
+func (m *listenerServerManager) AddServers(servers []*proxyserver.Server) (AddServersResult, error) {
+	managedServers := make([]*ManagedProxyServer, 0, len(servers))
 	for _, s := range servers {
-		managedServer := &ManagedProxyServer{
-			s,
-			map[string]bool{},
+		managedServers = append(managedServers, NewManagedProxyServer(s))
+	}
+
+	result, err := m.addManagedServers(managedServers)
+	return result, errtrace.Wrap(err)
+}
+
+// Add servers, merging duplicates of known servers according to the
+// duplicate policy instead of adding them again
+func (m *listenerServerManager) addManagedServers(servers []*ManagedProxyServer) (AddServersResult, error) {
+	defer m.requestSave()
+	defer m.refreshSelection()
+
+	result := AddServersResult{}
+
+	m.mu.Lock()
+	policy := m.DuplicatePolicy
+	index := m.newDuplicateIndex()
+	dups := make([]*ManagedProxyServer, len(servers))
+	for i, managedServer := range servers {
+		dups[i] = index.find(managedServer, policy)
+		if dups[i] == nil {
+			m.Servers[managedServer.Server.Id] = managedServer
+			index.add(managedServer)
+		}
+	}
+	m.mu.Unlock()
+
+	var listenerErr error
+	for i, managedServer := range servers {
+		dup := dups[i]
+		if dup != nil {
+			dup.absorb(managedServer)
+
+			// A manual server listed by a subscription is synced from it
+			// from now on
+			managedServer.mu.RLock()
+			subscriptionId := managedServer.SubscriptionId
+			managedServer.mu.RUnlock()
+			if subscriptionId != "" {
+				dup.adoptBy(subscriptionId)
+			}
 		}
 
-		common.DataMutex.Lock()
-		m.Servers[s.Id] = managedServer
-		common.DataMutex.Unlock()
+		auth := managedServer.Server.CurrentAuth()
+		credentialsChanged := dup != nil && policy == DUPLICATE_UpdateCredentials &&
+			!sameAuth(dup.Server.CurrentAuth(), auth)
+		if credentialsChanged {
+			dup.Server.SetAuth(auth)
+		}
+
+		if credentialsChanged {
+			// Drop prepared state (e.g. SSH client) authenticated with the old credentials
+			dup.Server.Cleanup()
+			dup.checkServer()
+			Events.Publish(EVENT_ServerChanged, dup.Server.Id, ServerEvent{dup.Server.Id})
+			result.Updated++
+			continue
+		}
+
+		if dup != nil {
+			Events.Publish(EVENT_ServerChanged, dup.Server.Id, ServerEvent{dup.Server.Id})
+			result.Merged++
+			continue
+		}
+
+		Events.Publish(EVENT_ServerAdded, managedServer.Server.Id, ServerEvent{managedServer.Server.Id})
 
 		managedServer.checkServer()
 
-		listener, err := NewLocalListener(0, nil, ServerFilter{ServerIds: map[string]bool{s.Id: true}})
-		if err != nil {
-			return errtrace.Wrap(err)
+		result.Added++
+
+		err := m.addDedicatedListener(managedServer.Server.Id)
+		if err != nil && listenerErr == nil {
+			// Added all the same, the others still get their listener
+			listenerErr = errtrace.Wrap(err)
 		}
-		m.AddListeners([]*LocalListener{listener})
 	}
 
-	return nil
+	return result, listenerErr
 }
 
-func (m *listenerServerManager) GetServer(filter ServerFilter) (*ManagedProxyServer, error) {
-	common.DataMutex.RLock()
-	defer common.DataMutex.RUnlock()
+// Shutdown and remove the servers together with their 1:1 listeners
+func (m *listenerServerManager) RemoveServers(ids []string) {
+	ports := []int{}
+
+	for _, id := range ids {
+		m.mu.Lock()
+		server, ok := m.Servers[id]
+		_, archived := m.ArchivedServers[id]
+		delete(m.Servers, id)
+		delete(m.ArchivedServers, id)
+		m.mu.Unlock()
+
+		if ok {
+			// Server shutdown
+			server.Server.Cleanup()
+		}
 
-	if filter.IgnoreAll {
-		return DirectProxy, nil
-	}
+		if ok || archived {
+			Events.Publish(EVENT_ServerRemoved, id, ServerEvent{id})
+		}
 
-	if len(m.Servers) == 0 {
-		return nil, errtrace.Errorf("No more servers inside manager")
+		if port, ok := m.dedicatedListenerPort(id); ok {
+			ports = append(ports, port)
+		}
 	}
 
-	next, stop := iter.Pull(maps.Values(m.Servers))
-	defer stop()
+	m.refreshSelection()
+	m.RemoveListeners(ports)
+}
 
-	for {
-		s, valid := next()
-		if !valid {
-			break
-		}
+func (m *listenerServerManager) RemoveListeners(ports []int) {
+	for _, port := range ports {
+		m.mu.Lock()
+		listener, ok := m.Listeners[port]
+		delete(m.Listeners, port)
+		m.mu.Unlock()
 
-		if !s.HasAllTags(filter.Tags) {
-			continue
+		if ok {
+			// Stopped once its connections drained
+			m.stopListener(listener)
 		}
+	}
 
-		if len(filter.ServerIds) > 0 {
-			if _, idAllowed := filter.ServerIds[s.Server.Id]; !idAllowed {
-				continue
-			}
+	m.requestSave()
+}
+
+// Open the 1:1 listener that only routes through the given server
+func (m *listenerServerManager) addDedicatedListener(id string) error {
+	listener, err := NewLocalListener(newDedicatedListenerBind(), 0, ServerFilter{ServerIds: map[string]bool{id: true}})
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	m.AddListeners([]*LocalListener{listener})
+	return nil
+}
+
+func (m *listenerServerManager) dedicatedListenerPort(id string) (int, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for port, l := range m.Listeners {
+		f := l.Listener.currentFilter()
+		if isDedicatedFilter(f) && f.ServerIds[id] {
+			return port, true
 		}
+	}
+
+	return 0, false
+}
+
+func isDedicatedFilter(f ServerFilter) bool {
+	return len(f.ServerIds) == 1 && len(f.Tags) == 0 && !f.IgnoreAll
+}
 
-		return s, nil
+func (m *listenerServerManager) GetServer(filter ServerFilter, strategy string) (*ManagedProxyServer, error) {
+	if filter.IgnoreAll {
+		return DirectProxy, nil
 	}
 
-	return nil, errtrace.Errorf("Cannot get server")
+	s, err := m.selection.Load().pick(filter, strategy, &m.roundRobin)
+	return s, errtrace.Wrap(err)
+}
+
+// Check whether the server passes the tags and IDs of the filter. IgnoreAll
+// is not considered here since it selects the direct connection instead.
+func (f ServerFilter) Matches(s *ManagedProxyServer) bool {
+	if !s.HasAllTags(f.Tags) {
+		return false
+	}
+
+	if len(f.ServerIds) > 0 {
+		if _, idAllowed := f.ServerIds[s.Server.Id]; !idAllowed {
+			return false
+		}
+	}
+
+	return true
 }
 
 func (m *listenerServerManager) AddListeners(listeners []*LocalListener) {
-	common.DataMutex.Lock()
+	m.mu.Lock()
 
 	for _, l := range listeners {
 		ctx, cancel := context.WithCancel(context.Background())
@@ -156,19 +476,29 @@ func (m *listenerServerManager) AddListeners(listeners []*LocalListener) {
 		}
 	}
 
-	common.DataMutex.Unlock()
+	isServing := m.IsServing
+	m.mu.Unlock()
 
-	if m.IsServing {
+	if isServing {
 		m.serveInactiveListeners()
 	}
+
+	m.requestSave()
+}
+
+func (m *listenerServerManager) serverList() []*ManagedProxyServer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return slices.Collect(maps.Values(m.Servers))
 }
 
 func (m *listenerServerManager) serveInactiveListeners() {
-	common.DataMutex.RLock()
-	defer common.DataMutex.RUnlock()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
 	for _, l := range m.Listeners {
-		if l.Listener.IsServing {
+		if l.Listener.state() != LISTENER_Idle {
 			continue
 		}
 
@@ -182,25 +512,6 @@ func (m *listenerServerManager) serveInactiveListeners() {
 	}
 }
 
-func (m *listenerServerManager) autoRecheckServers() {
-	for {
-		<-time.After(time.Second)
-		since := time.Now().Add(-m.ServerRecheckInterval)
-
-		common.DataMutex.RLock()
-
-		for _, s := range m.Servers {
-			if s.Server.LastChecked.After(since) {
-				continue
-			}
-
-			s.checkServer()
-		}
-
-		common.DataMutex.RUnlock()
-	}
-}
-
 type CheckServerThread struct {
 	server *ManagedProxyServer
 }
@@ -211,19 +522,39 @@ func (t *CheckServerThread) Id() string {
 
 func (t *CheckServerThread) Run() {
 	s := t.server
+	id := s.Server.Id
+
+	s.Server.RLock()
+	oldProtos := maps.Clone(s.Server.Protocols)
+	s.Server.RUnlock()
 
 	s.Server.CheckServer()
+	ListenerServerManager.recordCheckResult(s)
+	ListenerServerManager.scheduleNextCheck(s)
+	defer ListenerServerManager.requestSave()
 
-	common.DataMutex.RLock()
-	protos := s.Server.Protocols
+	s.Server.RLock()
+	protos := maps.Clone(s.Server.Protocols)
 	publicIp := s.Server.PublicIp
-	common.DataMutex.RUnlock()
+	latency := s.Server.Latency
+	s.Server.RUnlock()
 
-	for proto, supported := range protos {
-		if supported {
-			s.AddTags(proto)
+	s.mu.RLock()
+	checked := CheckFinishedEvent{id, s.isAlive(), latency, s.Lifecycle, s.NextCheck}
+	s.mu.RUnlock()
+
+	Events.Publish(EVENT_CheckFinished, id, checked)
+	if !maps.Equal(protos, oldProtos) {
+		Events.Publish(EVENT_ProtocolsChanged, id, ProtocolsChangedEvent{id, protos})
+	}
+
+	supported := []string{}
+	for proto, ok := range protos {
+		if ok {
+			supported = append(supported, proto)
 		}
 	}
+	s.AddTags(supported...)
 
 	if publicIp != "" {
 		ip, err := netip.ParseAddr(publicIp)
@@ -233,10 +564,19 @@ func (t *CheckServerThread) Run() {
 
 		countryCode, err := common.GetIpCountry(ip)
 		if err != nil {
-			s.Server.Printlnf("Error getting IP country: IP %s, error: %+v", s.Server.PublicIp, err)
+			s.Server.Printlnf("Error getting IP country: IP %s, error: %+v", publicIp, err)
 			return
 		}
 
+		s.mu.Lock()
+		changed := s.Country != countryCode
+		s.Country = countryCode
+		s.mu.Unlock()
+
+		if changed {
+			Events.Publish(EVENT_ServerChanged, id, ServerEvent{id})
+		}
+
 		s.AddTags(countryCode)
 	}
 }
@@ -248,11 +588,16 @@ func (s *ManagedProxyServer) checkServer() {
 }
 
 func (m *listenerServerManager) Serve() {
-	common.DataMutex.Lock()
+	m.mu.Lock()
 	m.IsServing = true
-	common.DataMutex.Unlock()
+	m.mu.Unlock()
 
 	m.serveInactiveListeners()
 	m.Wg.Go(m.autoRecheckServers)
+	m.Wg.Go(m.autoSyncSubscriptions)
+	m.Wg.Go(m.autoFinishDrains)
+	m.Wg.Go(m.autoSampleTraffic)
+	m.Wg.Go(m.autoAccountUsage)
+	m.Wg.Go(m.autoSaveUsage)
 	m.Wg.Wait()
 }
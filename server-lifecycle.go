@@ -0,0 +1,234 @@
+package main
+
+import (
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	LIFECYCLE_Active      = "active"
+	LIFECYCLE_Quarantined = "quarantined"
+	LIFECYCLE_Archived    = "archived"
+
+	EVICT_Archive = "archive"
+	EVICT_Delete  = "delete"
+)
+
+type LifecycleConfig struct {
+	// Consecutive failed checks before quarantine, 0 disables quarantine
+	QuarantineAfterFailures int
+	// Recheck interval of quarantined servers
+	QuarantineRecheckInterval time.Duration
+	// Time in quarantine before eviction, 0 disables eviction
+	EvictAfter  time.Duration
+	EvictAction string
+}
+
+func NewLifecycleConfig() LifecycleConfig {
+	return LifecycleConfig{
+		5,
+		30 * time.Minute,
+		7 * 24 * time.Hour,
+		EVICT_Archive,
+	}
+}
+
+func (c LifecycleConfig) Validate() error {
+	if c.QuarantineAfterFailures < 0 {
+		return errtrace.Errorf("Quarantine threshold cannot be negative")
+	}
+
+	if c.QuarantineAfterFailures > 0 && c.QuarantineRecheckInterval < time.Second {
+		return errtrace.Errorf("Quarantine recheck interval must be at least 1 second")
+	}
+
+	if c.EvictAfter < 0 {
+		return errtrace.Errorf("Eviction delay cannot be negative")
+	}
+
+	if c.EvictAction != EVICT_Archive && c.EvictAction != EVICT_Delete {
+		return errtrace.Errorf("Unknown eviction action %q", c.EvictAction)
+	}
+
+	return nil
+}
+
+// Must be called with the server lock held
+func (s *ManagedProxyServer) isQuarantined() bool {
+	return s.Lifecycle == LIFECYCLE_Quarantined
+}
+
+// Update the failure count after a check, quarantining the server once it
+// failed too many times in a row. A successful check brings it back.
+func (m *listenerServerManager) recordCheckResult(s *ManagedProxyServer) {
+	m.mu.RLock()
+	threshold := m.Lifecycle.QuarantineAfterFailures
+	m.mu.RUnlock()
+
+	s.mu.Lock()
+	wasQuarantined := s.isQuarantined()
+	s.updateLifecycle(threshold)
+	changed := s.isQuarantined() != wasQuarantined
+	s.mu.Unlock()
+
+	if changed {
+		m.refreshSelection()
+	}
+}
+
+// Must be called with the server lock held
+func (s *ManagedProxyServer) updateLifecycle(threshold int) {
+	if s.Lifecycle == LIFECYCLE_Archived {
+		return
+	}
+
+	if s.isAlive() {
+		if s.isQuarantined() {
+			s.Server.Printlnf("Recovered, leaving quarantine")
+		}
+
+		s.ConsecutiveFailures = 0
+		s.ConsecutiveSuccesses++
+		s.Lifecycle = LIFECYCLE_Active
+		s.QuarantinedAt = time.Time{}
+		return
+	}
+
+	s.ConsecutiveFailures++
+	s.ConsecutiveSuccesses = 0
+
+	if threshold > 0 && s.ConsecutiveFailures >= threshold && !s.isQuarantined() {
+		s.Server.Printlnf("Quarantined after %d failed checks", s.ConsecutiveFailures)
+		s.Lifecycle = LIFECYCLE_Quarantined
+		s.QuarantinedAt = time.Now()
+	}
+}
+
+// Servers that stayed in quarantine for longer than allowed
+func (m *listenerServerManager) serversToEvict() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := []string{}
+	if m.Lifecycle.EvictAfter == 0 {
+		return ids
+	}
+
+	deadline := time.Now().Add(-m.Lifecycle.EvictAfter)
+	for id, s := range m.Servers {
+		s.mu.RLock()
+		if s.isQuarantined() && s.QuarantinedAt.Before(deadline) {
+			ids = append(ids, id)
+		}
+		s.mu.RUnlock()
+	}
+
+	return ids
+}
+
+func (m *listenerServerManager) evictServers(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+
+	m.mu.RLock()
+	action := m.Lifecycle.EvictAction
+	m.mu.RUnlock()
+
+	if action == EVICT_Delete {
+		m.RemoveServers(ids)
+		return
+	}
+
+	m.ArchiveServers(ids)
+}
+
+// Move servers out of rotation into the archive, closing their listeners.
+// Archived servers are kept with their data but no longer checked.
+func (m *listenerServerManager) ArchiveServers(ids []string) {
+	archived := []*ManagedProxyServer{}
+
+	// Moved in one step, so the server is never missing from both
+	m.mu.Lock()
+	for _, id := range ids {
+		s, ok := m.Servers[id]
+		if !ok {
+			continue
+		}
+
+		s.mu.Lock()
+		s.Lifecycle = LIFECYCLE_Archived
+		s.mu.Unlock()
+		delete(m.Servers, id)
+		m.ArchivedServers[id] = s
+		archived = append(archived, s)
+	}
+	m.mu.Unlock()
+
+	m.refreshSelection()
+
+	ports := []int{}
+	for _, s := range archived {
+		s.Server.Printlnf("Archived")
+		s.Server.Cleanup()
+
+		if port, ok := m.dedicatedListenerPort(s.Server.Id); ok {
+			ports = append(ports, port)
+		}
+	}
+	// Saves as well
+	m.RemoveListeners(ports)
+
+	// Still known, only changed
+	for _, s := range archived {
+		Events.Publish(EVENT_ServerChanged, s.Server.Id, ServerEvent{s.Server.Id})
+	}
+}
+
+// Bring quarantined or archived servers back into rotation and recheck them
+func (m *listenerServerManager) RestoreServers(ids []string) error {
+	restored := []*ManagedProxyServer{}
+	var listenerErr error
+
+	m.mu.Lock()
+	for _, id := range ids {
+		s, ok := m.Servers[id]
+		if !ok {
+			s, ok = m.ArchivedServers[id]
+			if ok {
+				delete(m.ArchivedServers, id)
+				m.Servers[id] = s
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		s.mu.Lock()
+		s.Lifecycle = LIFECYCLE_Active
+		s.ConsecutiveFailures = 0
+		s.QuarantinedAt = time.Time{}
+		s.mu.Unlock()
+		restored = append(restored, s)
+	}
+	m.mu.Unlock()
+
+	m.refreshSelection()
+
+	for _, s := range restored {
+		s.checkServer()
+		Events.Publish(EVENT_ServerChanged, s.Server.Id, ServerEvent{s.Server.Id})
+
+		if _, ok := m.dedicatedListenerPort(s.Server.Id); !ok {
+			err := m.addDedicatedListener(s.Server.Id)
+			if err != nil && listenerErr == nil {
+				// Restored all the same, the others still get their listener
+				listenerErr = errtrace.Wrap(err)
+			}
+		}
+	}
+
+	m.requestSave()
+	return listenerErr
+}
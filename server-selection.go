@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+
+	"braces.dev/errtrace"
+)
+
+// Immutable view of a selectable server. Entries share the server tags map,
+// which is replaced instead of modified.
+type selectionEntry struct {
+	server *ManagedProxyServer
+	id     string
+	tags   map[string]bool
+}
+
+type serverSelection []selectionEntry
+
+func (e selectionEntry) matches(filter ServerFilter) bool {
+	if !hasAllTags(e.tags, filter.Tags) {
+		return false
+	}
+
+	if len(filter.ServerIds) > 0 && !filter.ServerIds[e.id] {
+		return false
+	}
+
+	return true
+}
+
+// Server matching the filter by the strategy. Random takes the first match
+// from a random entry, round-robin advances the turn over the matches only,
+// the others scan every match and break ties by the random start.
+func (sel serverSelection) pick(filter ServerFilter, strategy string, turn *atomic.Uint64) (*ManagedProxyServer, error) {
+	if len(sel) == 0 {
+		return nil, errtrace.Errorf("No more servers inside manager")
+	}
+
+	if strategy == STRATEGY_RoundRobin {
+		return sel.pickRoundRobin(filter, turn)
+	}
+
+	start := rand.IntN(len(sel))
+
+	var score func(s *ManagedProxyServer) int64
+	switch strategy {
+	case STRATEGY_LeastConnections:
+		score = func(s *ManagedProxyServer) int64 {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return int64(s.ActiveTunnels)
+		}
+	case STRATEGY_LowestLatency:
+		score = func(s *ManagedProxyServer) int64 {
+			s.Server.RLock()
+			defer s.Server.RUnlock()
+			if s.Server.Latency == 0 {
+				// Not measured yet
+				return math.MaxInt64
+			}
+			return int64(s.Server.Latency)
+		}
+	}
+
+	var best *ManagedProxyServer
+	var bestScore int64
+	for i := range sel {
+		e := sel[(start+i)%len(sel)]
+		if !e.matches(filter) {
+			continue
+		}
+		if score == nil {
+			return e.server, nil
+		}
+
+		if sc := score(e.server); best == nil || sc < bestScore {
+			best, bestScore = e.server, sc
+		}
+	}
+
+	if best == nil {
+		return nil, errtrace.Errorf("Cannot get server")
+	}
+	return best, nil
+}
+
+// Next of the entries matching the filter in turn. A turn over the whole
+// selection would favor the match after a run of skipped entries.
+func (sel serverSelection) pickRoundRobin(filter ServerFilter, turn *atomic.Uint64) (*ManagedProxyServer, error) {
+	matches := 0
+	for _, e := range sel {
+		if e.matches(filter) {
+			matches++
+		}
+	}
+	if matches == 0 {
+		return nil, errtrace.Errorf("Cannot get server")
+	}
+
+	n := int(turn.Add(1) % uint64(matches))
+	for _, e := range sel {
+		if !e.matches(filter) {
+			continue
+		}
+		if n == 0 {
+			return e.server, nil
+		}
+		n--
+	}
+	return nil, errtrace.Errorf("Cannot get server")
+}
+
+// Rebuild the selection snapshot. Must be called after any change to the
+// server set, their tags or whether they are selectable.
+func (m *listenerServerManager) refreshSelection() {
+	m.selectionMu.Lock()
+	defer m.selectionMu.Unlock()
+
+	servers := m.serverList()
+	sel := make(serverSelection, 0, len(servers))
+	for _, s := range servers {
+		s.mu.RLock()
+		if s.isSelectable() {
+			sel = append(sel, selectionEntry{s, s.Server.Id, s.Tags})
+		}
+		s.mu.RUnlock()
+	}
+
+	m.selection.Store(&sel)
+}
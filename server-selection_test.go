@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"go-proxy/proxyserver"
+	"io"
+	"maps"
+	"sync"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+// Stands in for the process-wide lock guarding all manager state before the
+// per-object locks, to compare both under the same load
+var legacyDataMutex sync.RWMutex
+
+// Server selection as done under the global lock: scan the server map for
+// the first match. The old tag check took the read lock again, which
+// deadlocks once a writer queues in between, so it is inlined here.
+func legacyGetServer(m *listenerServerManager, filter ServerFilter) (*ManagedProxyServer, error) {
+	legacyDataMutex.RLock()
+	defer legacyDataMutex.RUnlock()
+
+	for s := range maps.Values(m.Servers) {
+		if !s.isSelectable() || !hasAllTags(s.Tags, filter.Tags) {
+			continue
+		}
+		if len(filter.ServerIds) > 0 && !filter.ServerIds[s.Server.Id] {
+			continue
+		}
+		return s, nil
+	}
+	return nil, errtrace.Errorf("Cannot get server")
+}
+
+func legacyTrackTunnel(s *ManagedProxyServer, c io.Closer) {
+	legacyDataMutex.Lock()
+	s.tunnels[c] = true
+	s.ActiveTunnels = len(s.tunnels)
+	legacyDataMutex.Unlock()
+}
+
+func legacyUntrackTunnel(s *ManagedProxyServer, c io.Closer) {
+	legacyDataMutex.Lock()
+	delete(s.tunnels, c)
+	s.ActiveTunnels = len(s.tunnels)
+	legacyDataMutex.Unlock()
+}
+
+type nopCloser struct{}
+
+func (*nopCloser) Close() error { return nil }
+
+// Manager with n selectable servers, every other one tagged "even"
+func benchmarkManager(n int) *listenerServerManager {
+	m := NewListenerServerManager()
+	for i := range n {
+		s := NewManagedProxyServer(proxyserver.NewServer(fmt.Sprintf("10.0.%d.%d", i/256, i%256), 1080, nil))
+		if i%2 == 0 {
+			s.Tags = map[string]bool{"even": true}
+		}
+		m.Servers[s.Server.Id] = s
+	}
+	m.refreshSelection()
+	return m
+}
+
+var benchmarkFilter = ServerFilter{[]string{"even"}, nil, false}
+
+func BenchmarkGetServer(b *testing.B) {
+	m := benchmarkManager(1000)
+
+	b.Run("DataMutex", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := legacyGetServer(m, benchmarkFilter); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+
+	b.Run("Snapshot", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := m.GetServer(benchmarkFilter, STRATEGY_Random); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
+// Shared state touched by opening and closing a tunnel: picking the server
+// and registering the tunnel with it
+func BenchmarkTunnelOpen(b *testing.B) {
+	m := benchmarkManager(1000)
+
+	b.Run("DataMutex", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			c := &nopCloser{}
+			for pb.Next() {
+				s, err := legacyGetServer(m, benchmarkFilter)
+				if err != nil {
+					b.Fatal(err)
+				}
+				legacyTrackTunnel(s, c)
+				legacyUntrackTunnel(s, c)
+			}
+		})
+	})
+
+	b.Run("PerServerLock", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			c := &nopCloser{}
+			for pb.Next() {
+				s, err := m.GetServer(benchmarkFilter, STRATEGY_Random)
+				if err != nil {
+					b.Fatal(err)
+				}
+				s.trackTunnel(c)
+				s.untrackTunnel(c)
+			}
+		})
+	})
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// What dialing a Unix socket nobody listens on fails with
+const errSocketRefused = syscall.ECONNREFUSED
@@ -0,0 +1,6 @@
+package main
+
+import "syscall"
+
+// WSAECONNREFUSED, what dialing a Unix socket nobody listens on fails with
+const errSocketRefused = syscall.Errno(10061)
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"go-proxy/common"
+	"go-proxy/proxyserver"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"braces.dev/errtrace"
+	"github.com/google/uuid"
+)
+
+const (
+	SUBSCRIPTION_HISTORY_SIZE = 20
+	SUBSCRIPTION_MIN_INTERVAL = time.Minute
+
+	subscriptionFetchTimeout = 30 * time.Second
+)
+
+type Subscription struct {
+	Id   string
+	Name string
+
+	// HTTP(S) URL or local file path
+	Source          string
+	Format          ProxyFileFormat
+	RefreshInterval time.Duration
+	Tags            []string
+
+	// Tags the last sync gave the servers, replaced by the current ones on
+	// the next sync
+	SyncedTags []string
+
+	LastSynced time.Time
+	History    []SubscriptionSyncResult
+
+	syncing bool
+}
+
+type SubscriptionSyncResult struct {
+	Time      time.Time
+	Duration  time.Duration
+	Added     int
+	Updated   int
+	Removed   int
+	Unchanged int
+	Invalid   int
+	Error     string
+}
+
+func NewSubscription(name, source string, format ProxyFileFormat, interval time.Duration, tags []string) *Subscription {
+	return &Subscription{
+		uuid.Must(uuid.NewV7()).String(),
+		name,
+		source,
+		format,
+		interval,
+		tags,
+		[]string{},
+		time.Time{},
+		[]SubscriptionSyncResult{},
+		false,
+	}
+}
+
+func (s *Subscription) Printlnf(f string, a ...any) {
+	f = fmt.Sprintf("[Subscription %s] ", s.Name) + f + "\n"
+	fmt.Printf(f, a...)
+}
+
+func (s *Subscription) Validate() error {
+	if strings.TrimSpace(s.Source) == "" {
+		return errtrace.Errorf("Subscription source is empty")
+	}
+
+	if s.RefreshInterval < SUBSCRIPTION_MIN_INTERVAL {
+		return errtrace.Errorf("Subscription refresh interval must be at least %s", SUBSCRIPTION_MIN_INTERVAL)
+	}
+
+	return nil
+}
+
+func (s *Subscription) Fetch() (string, error) {
+	uri, err := url.Parse(s.Source)
+	if err == nil && (uri.Scheme == "http" || uri.Scheme == "https") {
+		client := http.Client{Timeout: subscriptionFetchTimeout}
+
+		res, err := client.Get(s.Source)
+		if err != nil {
+			return "", errtrace.Wrap(err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return "", errtrace.Errorf("Fetching %s failed. Status: %d - %s", s.Source, res.StatusCode, res.Status)
+		}
+
+		body, err := io.ReadAll(res.Body)
+		return string(body), errtrace.Wrap(err)
+	}
+
+	path := s.Source
+	if err == nil && uri.Scheme == "file" {
+		path = uri.Path
+	}
+
+	content, err := os.ReadFile(path)
+	return string(content), errtrace.Wrap(err)
+}
+
+func sameAuth(a, b *common.ProxyAuth) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Hand a manual server over to the subscription listing it, so syncs update
+// and retire it. Servers of another subscription keep their owner.
+func (s *ManagedProxyServer) adoptBy(subscriptionId string) {
+	s.mu.Lock()
+	if s.SubscriptionId == "" {
+		s.SubscriptionId = subscriptionId
+	}
+	s.mu.Unlock()
+}
+
+func (m *listenerServerManager) AddSubscription(sub *Subscription) error {
+	err := sub.Validate()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	m.mu.Lock()
+	m.Subscriptions[sub.Id] = sub
+	m.mu.Unlock()
+
+	Events.Publish(EVENT_SubscriptionChanged, sub.Id, SubscriptionEvent{sub.Id})
+	m.requestSave()
+	return nil
+}
+
+// Remove subscriptions, their servers are either removed too or kept as
+// manually managed servers
+func (m *listenerServerManager) RemoveSubscriptions(ids []string, removeServers bool) {
+	toRemove := []string{}
+
+	m.mu.Lock()
+	for _, id := range ids {
+		delete(m.Subscriptions, id)
+
+		for _, s := range m.Servers {
+			s.mu.Lock()
+			if s.SubscriptionId == id {
+				if removeServers {
+					toRemove = append(toRemove, s.Server.Id)
+				} else {
+					s.SubscriptionId = ""
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+	m.mu.Unlock()
+
+	for _, id := range ids {
+		Events.Publish(EVENT_SubscriptionChanged, id, SubscriptionEvent{id})
+	}
+
+	m.RemoveServers(toRemove)
+	m.requestSave()
+}
+
+// Fetch the subscription source and reconcile its servers: new servers are
+// added, changed credentials of a listed endpoint are updated in place and
+// servers missing from the source are retired. Unchanged servers are left
+// untouched.
+func (m *listenerServerManager) SyncSubscription(id string) (SubscriptionSyncResult, error) {
+	result := SubscriptionSyncResult{Time: time.Now()}
+
+	m.mu.Lock()
+	sub, ok := m.Subscriptions[id]
+	if !ok {
+		m.mu.Unlock()
+		return result, errtrace.Errorf("Subscription %s not found", id)
+	}
+	if sub.syncing {
+		m.mu.Unlock()
+		return result, errtrace.Errorf("Subscription %s is already syncing", sub.Name)
+	}
+	sub.syncing = true
+	// Copy taken under the lock, also for logging as the name may be edited
+	// meanwhile
+	source := *sub
+	m.mu.Unlock()
+
+	err := m.syncSubscription(&source, &result)
+	result.Duration = time.Since(result.Time)
+	if err != nil {
+		result.Error = err.Error()
+		source.Printlnf("Sync failed: %+v", err)
+	} else {
+		source.Printlnf("Synced: %d added, %d updated, %d removed, %d unchanged, %d invalid",
+			result.Added, result.Updated, result.Removed, result.Unchanged, result.Invalid)
+	}
+
+	m.mu.Lock()
+	sub.syncing = false
+	sub.SyncedTags = source.SyncedTags
+	sub.LastSynced = result.Time
+	sub.History = append(sub.History, result)
+	if len(sub.History) > SUBSCRIPTION_HISTORY_SIZE {
+		sub.History = sub.History[len(sub.History)-SUBSCRIPTION_HISTORY_SIZE:]
+	}
+	m.mu.Unlock()
+
+	Events.Publish(EVENT_SubscriptionChanged, id, SubscriptionEvent{id})
+
+	m.requestSave()
+	return result, errtrace.Wrap(err)
+}
+
+func (m *listenerServerManager) syncSubscription(sub *Subscription, result *SubscriptionSyncResult) error {
+	content, err := sub.Fetch()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	report := ParseProxyFile(content, sub.Format)
+	result.Invalid = report.Invalid
+
+	// Keyed by identity, servers sharing an endpoint may be kept apart
+	fetched := map[string]*proxyserver.Server{}
+	for _, s := range report.Servers() {
+		fetched[s.Identity()] = s
+	}
+
+	if len(fetched) == 0 {
+		// Most likely a broken source, keep the current servers instead of
+		// retiring all of them
+		return errtrace.Errorf("No servers found in %s", sub.Source)
+	}
+
+	m.mu.RLock()
+	keepBoth := m.DuplicatePolicy == DUPLICATE_KeepBoth
+	m.mu.RUnlock()
+
+	existing := map[string]*ManagedProxyServer{}
+	for _, s := range m.serverList() {
+		s.mu.RLock()
+		if s.SubscriptionId == sub.Id {
+			existing[s.Server.Identity()] = s
+		}
+		s.mu.RUnlock()
+	}
+
+	// Servers gone from the source, unless their endpoint is still listed
+	// with new credentials
+	stale := map[string][]*ManagedProxyServer{}
+	for identity, s := range existing {
+		if _, ok := fetched[identity]; !ok {
+			endpoint := s.Server.Endpoint()
+			stale[endpoint] = append(stale[endpoint], s)
+		}
+	}
+
+	// Tags dropped from the subscription since the last sync
+	removedTags := []string{}
+	for _, t := range sub.SyncedTags {
+		if !slices.Contains(sub.Tags, t) {
+			removedTags = append(removedTags, t)
+		}
+	}
+
+	added := []*ManagedProxyServer{}
+	for identity, s := range fetched {
+		if current, ok := existing[identity]; ok {
+			current.replaceTags(removedTags, sub.Tags)
+			result.Unchanged++
+			continue
+		}
+
+		candidates := stale[s.Endpoint()]
+		if len(candidates) == 0 || keepBoth {
+			managedServer := NewManagedProxyServer(s)
+			managedServer.SubscriptionId = sub.Id
+			managedServer.AddTags(sub.Tags...)
+			added = append(added, managedServer)
+			continue
+		}
+		current := candidates[0]
+		stale[s.Endpoint()] = candidates[1:]
+
+		current.replaceTags(removedTags, sub.Tags)
+		current.Server.SetAuth(s.Auth)
+
+		// Drop prepared state (e.g. SSH client) authenticated with the old credentials
+		current.Server.Cleanup()
+		current.checkServer()
+		result.Updated++
+	}
+
+	removed := []string{}
+	for _, servers := range stale {
+		for _, s := range servers {
+			removed = append(removed, s.Server.Id)
+		}
+	}
+
+	m.RemoveServers(removed)
+	result.Removed = len(removed)
+	sub.SyncedTags = slices.Clone(sub.Tags)
+
+	addResult, err := m.addManagedServers(added)
+	result.Added += addResult.Added
+	result.Updated += addResult.Updated
+	result.Unchanged += addResult.Merged
+	return errtrace.Wrap(err)
+}
+
+func (m *listenerServerManager) autoSyncSubscriptions() {
+	for {
+		<-time.After(time.Second)
+		now := time.Now()
+
+		due := []string{}
+		m.mu.RLock()
+		for _, sub := range m.Subscriptions {
+			if !sub.syncing && sub.LastSynced.Add(sub.RefreshInterval).Before(now) {
+				due = append(due, sub.Id)
+			}
+		}
+		m.mu.RUnlock()
+
+		for _, id := range due {
+			go m.SyncSubscription(id)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"go-proxy/common"
+	"go-proxy/proxyserver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Subscription source serving whatever content is set last
+type subscriptionSource struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	content string
+	status  int
+}
+
+func newSubscriptionSource(t *testing.T, content string) *subscriptionSource {
+	src := &subscriptionSource{content: content, status: http.StatusOK}
+	src.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		src.mu.Lock()
+		defer src.mu.Unlock()
+
+		w.WriteHeader(src.status)
+		w.Write([]byte(src.content))
+	}))
+	t.Cleanup(src.Close)
+	return src
+}
+
+func (src *subscriptionSource) set(status int, content string) {
+	src.mu.Lock()
+	src.content, src.status = content, status
+	src.mu.Unlock()
+}
+
+// Manager holding one subscription of the source
+func newSubscribedManager(t *testing.T, src *subscriptionSource) (*listenerServerManager, *Subscription) {
+	m := NewListenerServerManager()
+	sub := NewSubscription("test", src.URL, ProxyFileFormat{}, time.Minute, []string{"subscribed"})
+
+	err := m.AddSubscription(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, sub
+}
+
+func syncSubscription(t *testing.T, m *listenerServerManager, id string) SubscriptionSyncResult {
+	result, err := m.SyncSubscription(id)
+	if err != nil {
+		t.Fatalf("Sync failed: %+v", err)
+	}
+	return result
+}
+
+// Servers owned by the subscription, by host:port:user:pass
+func subscribedServers(m *listenerServerManager, id string) map[string]*ManagedProxyServer {
+	servers := map[string]*ManagedProxyServer{}
+	for _, s := range m.serverList() {
+		s.mu.RLock()
+		owner := s.SubscriptionId
+		s.mu.RUnlock()
+		if owner != id {
+			continue
+		}
+
+		key := s.Server.Endpoint()
+		if auth := s.Server.CurrentAuth(); auth != nil {
+			key += ":" + auth.String()
+		}
+		servers[key] = s
+	}
+	return servers
+}
+
+func expectServers(t *testing.T, servers map[string]*ManagedProxyServer, keys ...string) {
+	t.Helper()
+
+	if len(servers) != len(keys) {
+		t.Fatalf("Expected %d servers, got %d", len(keys), len(servers))
+	}
+	for _, key := range keys {
+		if _, ok := servers[key]; !ok {
+			t.Fatalf("Expected server %s", key)
+		}
+	}
+}
+
+func TestSyncSubscriptionAddsServers(t *testing.T) {
+	src := newSubscriptionSource(t, "127.0.0.1:1:alice:one\n127.0.0.1:2:bob:two\n")
+	m, sub := newSubscribedManager(t, src)
+
+	result := syncSubscription(t, m, sub.Id)
+	if result.Added != 2 || result.Updated != 0 || result.Removed != 0 {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+
+	servers := subscribedServers(m, sub.Id)
+	expectServers(t, servers, "127.0.0.1:1:alice:one", "127.0.0.1:2:bob:two")
+	if !servers["127.0.0.1:1:alice:one"].HasAllTags([]string{"subscribed"}) {
+		t.Fatal("Expected the subscription tags on its servers")
+	}
+
+	result = syncSubscription(t, m, sub.Id)
+	if result.Added != 0 || result.Unchanged != 2 {
+		t.Fatalf("Expected an unchanged source to change nothing, got %+v", result)
+	}
+}
+
+func TestSyncSubscriptionUpdatesCredentials(t *testing.T) {
+	src := newSubscriptionSource(t, "127.0.0.1:1:alice:one\n127.0.0.1:2:bob:two\n")
+	m, sub := newSubscribedManager(t, src)
+	syncSubscription(t, m, sub.Id)
+	id := subscribedServers(m, sub.Id)["127.0.0.1:1:alice:one"].Server.Id
+
+	src.set(http.StatusOK, "127.0.0.1:1:alice:changed\n127.0.0.1:2:bob:two\n")
+	result := syncSubscription(t, m, sub.Id)
+	if result.Updated != 1 || result.Unchanged != 1 || result.Added != 0 || result.Removed != 0 {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+
+	servers := subscribedServers(m, sub.Id)
+	expectServers(t, servers, "127.0.0.1:1:alice:changed", "127.0.0.1:2:bob:two")
+	if servers["127.0.0.1:1:alice:changed"].Server.Id != id {
+		t.Fatal("Expected the credentials to be updated in place")
+	}
+}
+
+func TestSyncSubscriptionReplacesTags(t *testing.T) {
+	src := newSubscriptionSource(t, "127.0.0.1:1:alice:one\n127.0.0.1:2:bob:two\n")
+	m, sub := newSubscribedManager(t, src)
+	syncSubscription(t, m, sub.Id)
+	subscribedServers(m, sub.Id)["127.0.0.1:1:alice:one"].AddTags("manual")
+
+	m.mu.Lock()
+	sub.Tags = []string{"renamed"}
+	m.mu.Unlock()
+
+	src.set(http.StatusOK, "127.0.0.1:1:alice:one\n127.0.0.1:2:bob:changed\n")
+	syncSubscription(t, m, sub.Id)
+
+	for key, s := range subscribedServers(m, sub.Id) {
+		if s.HasAllTags([]string{"subscribed"}) || !s.HasAllTags([]string{"renamed"}) {
+			t.Fatalf("Expected the tags of %s to be replaced, got %v", key, s.tagList())
+		}
+	}
+	if !subscribedServers(m, sub.Id)["127.0.0.1:1:alice:one"].HasAllTags([]string{"manual"}) {
+		t.Fatal("Expected tags not set by the subscription to be kept")
+	}
+}
+
+func TestSyncSubscriptionRetiresServers(t *testing.T) {
+	src := newSubscriptionSource(t, "127.0.0.1:1:alice:one\n127.0.0.1:2:bob:two\n")
+	m, sub := newSubscribedManager(t, src)
+	syncSubscription(t, m, sub.Id)
+
+	src.set(http.StatusOK, "127.0.0.1:2:bob:two\n")
+	result := syncSubscription(t, m, sub.Id)
+	if result.Removed != 1 || result.Unchanged != 1 {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	expectServers(t, subscribedServers(m, sub.Id), "127.0.0.1:2:bob:two")
+}
+
+func TestSyncSubscriptionKeepsSessionsApart(t *testing.T) {
+	src := newSubscriptionSource(t, "127.0.0.1:1:session-a:pass\n127.0.0.1:1:session-b:pass\n")
+	m, sub := newSubscribedManager(t, src)
+	m.SetDuplicatePolicy(DUPLICATE_KeepBoth)
+
+	result := syncSubscription(t, m, sub.Id)
+	if result.Added != 2 {
+		t.Fatalf("Expected both sessions to be added, got %+v", result)
+	}
+
+	src.set(http.StatusOK, "127.0.0.1:1:session-b:pass\n")
+	result = syncSubscription(t, m, sub.Id)
+	if result.Removed != 1 || result.Unchanged != 1 {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+	expectServers(t, subscribedServers(m, sub.Id), "127.0.0.1:1:session-b:pass")
+}
+
+func TestSyncSubscriptionAdoptsManualServer(t *testing.T) {
+	src := newSubscriptionSource(t, "127.0.0.1:1:alice:one\n")
+	m, sub := newSubscribedManager(t, src)
+
+	manual := NewManagedProxyServer(proxyserver.NewServer("127.0.0.1", 1, &common.ProxyAuth{Username: "alice", Password: "one"}))
+	_, err := m.addManagedServers([]*ManagedProxyServer{manual})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := syncSubscription(t, m, sub.Id)
+	if result.Added != 0 || result.Unchanged != 1 {
+		t.Fatalf("Expected the manual server to be merged, got %+v", result)
+	}
+
+	servers := subscribedServers(m, sub.Id)
+	expectServers(t, servers, "127.0.0.1:1:alice:one")
+	if servers["127.0.0.1:1:alice:one"] != manual {
+		t.Fatal("Expected the subscription to own the manual server")
+	}
+}
+
+func TestSyncSubscriptionKeepsServersOnErrors(t *testing.T) {
+	src := newSubscriptionSource(t, "127.0.0.1:1:alice:one\n")
+	m, sub := newSubscribedManager(t, src)
+	syncSubscription(t, m, sub.Id)
+
+	for _, c := range []struct {
+		status  int
+		content string
+		err     string
+	}{
+		{http.StatusOK, "not a proxy\n", "No servers found"},
+		{http.StatusOK, "proxies:\n  - type: vmess\n    server: 127.0.0.1\n    port: 1\n", "No servers found"},
+		{http.StatusInternalServerError, "", "Status: 500"},
+	} {
+		src.set(c.status, c.content)
+
+		result, err := m.SyncSubscription(sub.Id)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("Expected error %q for %q, got %v", c.err, c.content, err)
+		}
+		if result.Removed != 0 {
+			t.Fatalf("Expected no server to be retired, got %+v", result)
+		}
+		expectServers(t, subscribedServers(m, sub.Id), "127.0.0.1:1:alice:one")
+	}
+
+	m.mu.RLock()
+	history := sub.History
+	m.mu.RUnlock()
+	if len(history) != 4 || history[3].Error == "" {
+		t.Fatalf("Expected the failed syncs in the history, got %+v", history)
+	}
+}
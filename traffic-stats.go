@@ -0,0 +1,341 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	TRAFFIC_Total    = "total"
+	TRAFFIC_Listener = "listener"
+	TRAFFIC_Server   = "server"
+	// Listener user, the id is the port and username as "port/username"
+	TRAFFIC_User = "user"
+
+	trafficSampleInterval = time.Second
+	// Per-sample history, 5 minutes at the sample interval
+	trafficRecentSize = 300
+	// Per-minute history, one day
+	trafficMinutesSize = 24 * 60
+	// Totals are saved with the config at most this often
+	trafficPersistInterval = time.Minute
+)
+
+type TrafficStat struct {
+	Sent     uint64
+	Received uint64
+}
+
+// Byte counters updated on every read and write without locking
+type trafficCounter struct {
+	sent     atomic.Uint64
+	received atomic.Uint64
+}
+
+func (c *trafficCounter) add(sent, received int) {
+	if sent > 0 {
+		c.sent.Add(uint64(sent))
+	}
+	if received > 0 {
+		c.received.Add(uint64(received))
+	}
+}
+
+func (c *trafficCounter) load() TrafficStat {
+	return TrafficStat{c.sent.Load(), c.received.Load()}
+}
+
+// Add the totals of another counter, e.g. of a merged server
+func (c *trafficCounter) merge(t TrafficStat) {
+	c.sent.Add(t.Sent)
+	c.received.Add(t.Received)
+}
+
+// Continue counting from persisted totals
+func (c *trafficCounter) restore(t TrafficStat) {
+	c.sent.Store(t.Sent)
+	c.received.Store(t.Received)
+}
+
+type TrafficSample struct {
+	Time time.Time
+	TrafficStat
+	// Bytes per second since the previous sample
+	SentRate     float64
+	ReceivedRate float64
+}
+
+func (s TrafficSample) isIdle() bool {
+	return s.SentRate == 0 && s.ReceivedRate == 0
+}
+
+// Rates and history of one counter. Runs of idle samples only keep their first
+// and last sample, so idle servers do not take memory.
+type trafficSeries struct {
+	last    TrafficSample
+	recent  []TrafficSample
+	minutes []TrafficSample
+	minute  TrafficSample
+}
+
+func newTrafficSample(now time.Time, total TrafficStat, prev TrafficSample) TrafficSample {
+	sample := TrafficSample{Time: now, TrafficStat: total}
+
+	elapsed := now.Sub(prev.Time).Seconds()
+	if prev.Time.IsZero() || elapsed <= 0 {
+		return sample
+	}
+
+	// Counters restored from a lower total count as a fresh start
+	if total.Sent >= prev.Sent {
+		sample.SentRate = float64(total.Sent-prev.Sent) / elapsed
+	}
+	if total.Received >= prev.Received {
+		sample.ReceivedRate = float64(total.Received-prev.Received) / elapsed
+	}
+	return sample
+}
+
+func appendTrafficSample(history []TrafficSample, sample TrafficSample, size int) []TrafficSample {
+	n := len(history)
+	if n >= 2 && sample.isIdle() && history[n-1].isIdle() && history[n-2].isIdle() {
+		history[n-1] = sample
+		return history
+	}
+
+	history = append(history, sample)
+	if len(history) > size {
+		history = history[len(history)-size:]
+	}
+	return history
+}
+
+func (s *trafficSeries) sample(now time.Time, total TrafficStat) TrafficSample {
+	sample := newTrafficSample(now, total, s.last)
+	s.last = sample
+	s.recent = appendTrafficSample(s.recent, sample, trafficRecentSize)
+
+	if s.minute.Time.IsZero() {
+		s.minute = sample
+	} else if now.Sub(s.minute.Time) >= time.Minute {
+		s.minute = newTrafficSample(now, total, s.minute)
+		s.minutes = appendTrafficSample(s.minutes, s.minute, trafficMinutesSize)
+	}
+
+	return sample
+}
+
+// Samples since the given time, per minute before the recent samples begin
+func (s *trafficSeries) history(since time.Time) []TrafficSample {
+	samples := []TrafficSample{}
+
+	recentStart := time.Now()
+	if len(s.recent) > 0 {
+		recentStart = s.recent[0].Time
+	}
+
+	for _, sample := range s.minutes {
+		if !sample.Time.Before(since) && sample.Time.Before(recentStart) {
+			samples = append(samples, sample)
+		}
+	}
+	for _, sample := range s.recent {
+		if !sample.Time.Before(since) {
+			samples = append(samples, sample)
+		}
+	}
+
+	return samples
+}
+
+// Samples the traffic counters of the manager, listeners and servers at a
+// fixed interval
+type trafficSampler struct {
+	mu     sync.RWMutex
+	series map[string]*trafficSeries
+
+	// Whether the previous tick had traffic, so one idle tick is published to
+	// reset the rates
+	active bool
+
+	lastPersisted TrafficStat
+	persistedAt   time.Time
+}
+
+func newTrafficSampler() *trafficSampler {
+	return &trafficSampler{
+		sync.RWMutex{},
+		map[string]*trafficSeries{},
+		false,
+		TrafficStat{},
+		time.Time{},
+	}
+}
+
+func trafficKey(scope, id string) string {
+	return scope + ":" + id
+}
+
+// Sample the counters, dropping series of counters that are gone. Returns the
+// samples keyed by series.
+func (t *trafficSampler) sample(now time.Time, counters map[string]TrafficStat) map[string]TrafficSample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key := range t.series {
+		if _, ok := counters[key]; !ok {
+			delete(t.series, key)
+		}
+	}
+
+	samples := map[string]TrafficSample{}
+	for key, total := range counters {
+		series, ok := t.series[key]
+		if !ok {
+			series = &trafficSeries{}
+			t.series[key] = series
+		}
+		samples[key] = series.sample(now, total)
+	}
+
+	return samples
+}
+
+// Add totals merged into a counter to its last samples, so the merge does
+// not show up as a burst of traffic
+func (t *trafficSampler) shift(key string, by TrafficStat) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	series, ok := t.series[key]
+	if !ok {
+		return
+	}
+
+	series.last.Sent += by.Sent
+	series.last.Received += by.Received
+	if !series.minute.Time.IsZero() {
+		series.minute.Sent += by.Sent
+		series.minute.Received += by.Received
+	}
+}
+
+func (t *trafficSampler) history(key string, since time.Time) ([]TrafficSample, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	series, ok := t.series[key]
+	if !ok {
+		return nil, false
+	}
+	return series.history(since), true
+}
+
+func (m *listenerServerManager) trafficCounters() (map[string]TrafficStat, map[string]int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counters := map[string]TrafficStat{trafficKey(TRAFFIC_Total, ""): m.traffic.load()}
+	ports := map[string]int{}
+	for port, l := range m.Listeners {
+		key := trafficKey(TRAFFIC_Listener, strconv.Itoa(port))
+		counters[key] = l.Listener.traffic.load()
+		ports[key] = port
+
+		for name, total := range l.Listener.userTrafficTotals() {
+			counters[trafficKey(TRAFFIC_User, strconv.Itoa(port)+"/"+name)] = total
+		}
+	}
+	for id, s := range m.Servers {
+		counters[trafficKey(TRAFFIC_Server, id)] = s.traffic.load()
+	}
+
+	return counters, ports
+}
+
+func (m *listenerServerManager) sampleTraffic(now time.Time) {
+	counters, ports := m.trafficCounters()
+	samples := m.sampler.sample(now, counters)
+
+	total := samples[trafficKey(TRAFFIC_Total, "")]
+	event := StatsTickEvent{total, map[int]TrafficSample{}, map[string]TrafficSample{}}
+	for key, sample := range samples {
+		if sample.isIdle() {
+			continue
+		}
+
+		if port, ok := ports[key]; ok {
+			event.Listeners[port] = sample
+		} else if id, ok := strings.CutPrefix(key, trafficKey(TRAFFIC_Server, "")); ok {
+			event.Servers[id] = sample
+		}
+	}
+
+	m.sampler.mu.Lock()
+	publish := m.sampler.active || !total.isIdle()
+	m.sampler.active = !total.isIdle()
+	persist := total.TrafficStat != m.sampler.lastPersisted && now.Sub(m.sampler.persistedAt) >= trafficPersistInterval
+	if persist {
+		m.sampler.lastPersisted = total.TrafficStat
+		m.sampler.persistedAt = now
+	}
+	m.sampler.mu.Unlock()
+
+	if publish {
+		Events.Publish(EVENT_StatsTick, "", event)
+	}
+	if persist {
+		m.requestSave()
+	}
+}
+
+func (m *listenerServerManager) autoSampleTraffic() {
+	for {
+		<-time.After(trafficSampleInterval)
+		m.sampleTraffic(time.Now())
+	}
+}
+
+// Current totals of the manager and of every listener and server, including
+// idle ones the ticks leave out. Rates are zero, ticks fill them in.
+func (m *listenerServerManager) TrafficTotals() StatsTickEvent {
+	counters, ports := m.trafficCounters()
+
+	now := time.Now()
+	event := StatsTickEvent{TrafficSample{Time: now}, map[int]TrafficSample{}, map[string]TrafficSample{}}
+	for key, total := range counters {
+		sample := TrafficSample{Time: now, TrafficStat: total}
+
+		if key == trafficKey(TRAFFIC_Total, "") {
+			event.Total = sample
+		} else if port, ok := ports[key]; ok {
+			event.Listeners[port] = sample
+		} else if id, ok := strings.CutPrefix(key, trafficKey(TRAFFIC_Server, "")); ok {
+			event.Servers[id] = sample
+		}
+	}
+	return event
+}
+
+// Traffic samples of the whole manager, a listener (id is the port), a server
+// or a listener user since the given time
+func (m *listenerServerManager) TrafficHistory(scope, id string, since time.Time) ([]TrafficSample, error) {
+	switch scope {
+	case TRAFFIC_Total:
+		id = ""
+	case TRAFFIC_Listener, TRAFFIC_Server, TRAFFIC_User:
+	default:
+		return nil, errtrace.Errorf("Unknown traffic scope %q", scope)
+	}
+
+	samples, ok := m.sampler.history(trafficKey(scope, id), since)
+	if !ok {
+		return nil, errtrace.Errorf("No traffic recorded for %s %s", scope, id)
+	}
+	return samples, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"go-proxy/rwutil"
+	"net"
+	"strconv"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+func NewTunnelTimeouts() rwutil.TunnelOptions {
+	return rwutil.TunnelOptions{IdleTimeout: 10 * time.Minute, MaxLifetime: 0}
+}
+
+func validateTunnelTimeouts(t rwutil.TunnelOptions) error {
+	if t.IdleTimeout < 0 || t.MaxLifetime < 0 {
+		return errtrace.Errorf("Tunnel timeouts cannot be negative")
+	}
+	return nil
+}
+
+// Copy between the client and the upstream until both finished or a timeout
+// of the listener closes the tunnel
+func (l *LocalListener) tunnel(conn *IncomingConnection, remote net.Conn) {
+	l.mu.RLock()
+	timeouts := l.Timeouts
+	l.mu.RUnlock()
+
+	result := rwutil.TunnelConns(conn, remote, timeouts)
+	conn.endReason = result.Reason
+	if conn.quotaExhausted.Load() {
+		conn.endReason = TUNNEL_QuotaExhausted
+	}
+	if result.Err != nil {
+		l.Printlnf("Tunnel of %s failed after %d bytes sent and %d received: %+v", conn.RemoteAddr(), result.Forward, result.Backward, result.Err)
+	}
+}
+
+func (l *LocalListener) SetTimeouts(timeouts rwutil.TunnelOptions) error {
+	err := validateTunnelTimeouts(timeouts)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	// Open tunnels keep the timeouts they started with
+	l.mu.Lock()
+	l.Timeouts = timeouts
+	l.mu.Unlock()
+
+	Events.Publish(EVENT_ListenerChanged, strconv.Itoa(l.Port), ListenerEvent{l.Port})
+	return nil
+}
+
+func (m *listenerServerManager) SetListenerTimeouts(port int, timeouts rwutil.TunnelOptions) error {
+	l, err := m.listener(port)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = l.SetTimeouts(timeouts)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	m.requestSave()
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	USAGE_Server      = "server"
+	USAGE_Destination = "destination"
+
+	WINDOW_Hour  = "hour"
+	WINDOW_Day   = "day"
+	WINDOW_Month = "month"
+
+	usageRecentBucket = 10 * time.Minute
+	usageRecentKeep   = 24 * time.Hour
+	usageDailyKeep    = 31 * 24 * time.Hour
+	// Traffic of open tunnels is added to the buckets this often, so long
+	// tunnels are counted in the time they transfer
+	usageFlushInterval = time.Minute
+)
+
+var usageWindows = map[string]time.Duration{
+	WINDOW_Hour:  time.Hour,
+	WINDOW_Day:   24 * time.Hour,
+	WINDOW_Month: 30 * 24 * time.Hour,
+}
+
+type UsageStat struct {
+	TrafficStat
+	Connections int
+	Errors      int
+}
+
+func (u *UsageStat) add(other UsageStat) {
+	u.Sent += other.Sent
+	u.Received += other.Received
+	u.Connections += other.Connections
+	u.Errors += other.Errors
+}
+
+// Usage by server id and by destination host within a time bucket
+type UsageBucket struct {
+	Start        time.Time
+	Servers      map[string]UsageStat
+	Destinations map[string]UsageStat
+}
+
+// Usage of the last day in 10 minute buckets, and of the last month in daily
+// buckets
+type UsageHistory struct {
+	Recent []UsageBucket
+	Daily  []UsageBucket
+}
+
+type UsageEntry struct {
+	Key string
+	UsageStat
+}
+
+func NewUsageHistory() UsageHistory {
+	return UsageHistory{[]UsageBucket{}, []UsageBucket{}}
+}
+
+func (b UsageBucket) clone() UsageBucket {
+	return UsageBucket{b.Start, maps.Clone(b.Servers), maps.Clone(b.Destinations)}
+}
+
+func (b *UsageBucket) record(serverId, host string, u UsageStat) {
+	server := b.Servers[serverId]
+	server.add(u)
+	b.Servers[serverId] = server
+
+	dest := b.Destinations[host]
+	dest.add(u)
+	b.Destinations[host] = dest
+}
+
+// Bucket starting at the given time, appended if it is newer than the last
+// one. Buckets older than keep are dropped.
+func bucketAt(buckets []UsageBucket, start time.Time, keep time.Duration) ([]UsageBucket, *UsageBucket) {
+	if n := len(buckets); n > 0 && !buckets[n-1].Start.Before(start) {
+		return buckets, &buckets[n-1]
+	}
+
+	buckets = append(buckets, UsageBucket{start, map[string]UsageStat{}, map[string]UsageStat{}})
+
+	cutoff := start.Add(-keep)
+	drop := 0
+	for drop < len(buckets) && buckets[drop].Start.Before(cutoff) {
+		drop++
+	}
+	buckets = buckets[drop:]
+
+	return buckets, &buckets[len(buckets)-1]
+}
+
+type usageStats struct {
+	mu      sync.Mutex
+	history UsageHistory
+}
+
+func newUsageStats() *usageStats {
+	return &usageStats{sync.Mutex{}, NewUsageHistory()}
+}
+
+// Destinations are counted by host, so every port of a site adds up
+func destinationHost(target string) string {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return target
+	}
+	return host
+}
+
+func (s *usageStats) record(now time.Time, serverId, target string, u UsageStat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordLocked(now, serverId, target, u)
+}
+
+func (s *usageStats) recordLocked(now time.Time, serverId, target string, u UsageStat) {
+	host := destinationHost(target)
+
+	var bucket *UsageBucket
+	s.history.Recent, bucket = bucketAt(s.history.Recent, now.Truncate(usageRecentBucket), usageRecentKeep)
+	bucket.record(serverId, host, u)
+
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	s.history.Daily, bucket = bucketAt(s.history.Daily, day, usageDailyKeep)
+	bucket.record(serverId, host, u)
+}
+
+// Add the traffic of a tunnel since it was last accounted
+func (s *usageStats) account(now time.Time, c *trackedConnection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := c.conn.traffic.load()
+	delta := TrafficStat{total.Sent - c.accounted.Sent, total.Received - c.accounted.Received}
+	if delta == (TrafficStat{}) {
+		return
+	}
+
+	c.accounted = total
+	s.recordLocked(now, c.info.ServerId, c.info.Target, UsageStat{delta, 0, 0})
+}
+
+// Count the usage of a merged server for the kept one
+func (s *usageStats) mergeServer(from, into string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, buckets := range [][]UsageBucket{s.history.Recent, s.history.Daily} {
+		for i := range buckets {
+			b := &buckets[i]
+			usage, ok := b.Servers[from]
+			if !ok {
+				continue
+			}
+
+			kept := b.Servers[into]
+			kept.add(usage)
+			b.Servers[into] = kept
+			delete(b.Servers, from)
+		}
+	}
+}
+
+func (s *usageStats) snapshot() UsageHistory {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history := NewUsageHistory()
+	for _, b := range s.history.Recent {
+		history.Recent = append(history.Recent, b.clone())
+	}
+	for _, b := range s.history.Daily {
+		history.Daily = append(history.Daily, b.clone())
+	}
+	return history
+}
+
+func (s *usageStats) restore(history UsageHistory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.history = NewUsageHistory()
+	for _, b := range history.Recent {
+		if b.Servers != nil && b.Destinations != nil {
+			s.history.Recent = append(s.history.Recent, b)
+		}
+	}
+	for _, b := range history.Daily {
+		if b.Servers != nil && b.Destinations != nil {
+			s.history.Daily = append(s.history.Daily, b)
+		}
+	}
+}
+
+func (s *usageStats) sum(kind string, since time.Time, buckets []UsageBucket) map[string]UsageStat {
+	sum := map[string]UsageStat{}
+	for _, b := range buckets {
+		if b.Start.Before(since) {
+			continue
+		}
+
+		stats := b.Servers
+		if kind == USAGE_Destination {
+			stats = b.Destinations
+		}
+		for key, u := range stats {
+			total := sum[key]
+			total.add(u)
+			sum[key] = total
+		}
+	}
+	return sum
+}
+
+func (m *listenerServerManager) recordConnectError(s *ManagedProxyServer, target string) {
+	m.usage.record(time.Now(), s.Server.Id, target, UsageStat{TrafficStat{}, 0, 1})
+}
+
+func (m *listenerServerManager) accountOpenConnections() {
+	now := time.Now()
+	for _, c := range m.connections.matching(ConnectionFilter{}) {
+		m.usage.account(now, c)
+	}
+}
+
+func (m *listenerServerManager) autoAccountUsage() {
+	for {
+		<-time.After(usageFlushInterval)
+		m.accountOpenConnections()
+	}
+}
+
+// Servers or destination hosts using the most traffic within the window, at
+// most n of them (all when n is not positive)
+func (m *listenerServerManager) TopUsage(kind, window string, n int) ([]UsageEntry, error) {
+	if kind != USAGE_Server && kind != USAGE_Destination {
+		return nil, errtrace.Errorf("Unknown usage kind %q", kind)
+	}
+
+	length, ok := usageWindows[window]
+	if !ok {
+		return nil, errtrace.Errorf("Unknown usage window %q", window)
+	}
+
+	m.accountOpenConnections()
+
+	now := time.Now()
+	since := now.Add(-length)
+
+	m.usage.mu.Lock()
+	var sum map[string]UsageStat
+	if length <= usageRecentKeep {
+		sum = m.usage.sum(kind, since.Truncate(usageRecentBucket), m.usage.history.Recent)
+	} else {
+		day := time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, since.Location())
+		sum = m.usage.sum(kind, day, m.usage.history.Daily)
+	}
+	m.usage.mu.Unlock()
+
+	entries := make([]UsageEntry, 0, len(sum))
+	for key, u := range sum {
+		entries = append(entries, UsageEntry{key, u})
+	}
+
+	slices.SortFunc(entries, func(a, b UsageEntry) int {
+		return cmp.Or(
+			cmp.Compare(b.Sent+b.Received, a.Sent+a.Received),
+			cmp.Compare(b.Connections, a.Connections),
+			cmp.Compare(a.Key, b.Key),
+		)
+	})
+
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	USAGE_FILE_NAME = "usage.json"
+
+	// The history changes on every tunnel, so it is written on its own
+	// schedule instead of with every config change
+	usageSaveInterval = 10 * time.Minute
+)
+
+// Usage history kept next to the config, so the config only holds settings
+type UsageStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func NewUsageStore(path string) *UsageStore {
+	return &UsageStore{Path: path}
+}
+
+func DefaultUsagePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+
+	return filepath.Join(dir, "go-proxy", USAGE_FILE_NAME), nil
+}
+
+// Load the history from disk. A missing file results in an empty history.
+func (u *UsageStore) Load() (UsageHistory, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	history := NewUsageHistory()
+
+	content, err := os.ReadFile(u.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return history, nil
+	}
+	if err != nil {
+		return history, errtrace.Wrap(err)
+	}
+
+	err = json.Unmarshal(content, &history)
+	if err != nil {
+		return NewUsageHistory(), errtrace.Wrap(err)
+	}
+
+	return history, nil
+}
+
+func (u *UsageStore) Save(history UsageHistory) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	content, err := json.Marshal(history)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap(writeFileAtomic(u.Path, content))
+}
+
+// Restore the history from the store and save it there from now on
+func (m *listenerServerManager) SetUsageStore(store *UsageStore) error {
+	history, err := store.Load()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	m.usage.restore(history)
+
+	m.mu.Lock()
+	m.usageStore = store
+	m.mu.Unlock()
+	return nil
+}
+
+// Write the history including the traffic of open tunnels, if a store is set
+func (m *listenerServerManager) saveUsage() error {
+	m.mu.RLock()
+	store := m.usageStore
+	m.mu.RUnlock()
+
+	if store == nil {
+		return nil
+	}
+
+	m.accountOpenConnections()
+	return errtrace.Wrap(store.Save(m.usage.snapshot()))
+}
+
+func (m *listenerServerManager) autoSaveUsage() {
+	for {
+		<-time.After(usageSaveInterval)
+
+		err := m.saveUsage()
+		if err != nil {
+			fmt.Printf("Error saving usage history: %+v\n", err)
+		}
+	}
+}
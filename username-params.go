@@ -0,0 +1,245 @@
+package main
+
+import (
+	"go-proxy/proxyserver"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+const (
+	PARAM_Country  = "country"  // country tag, e.g. country-us
+	PARAM_Protocol = "protocol" // protocol tag, e.g. protocol-socks5
+	PARAM_Tag      = "tag"      // any tag, may be repeated
+	PARAM_Server   = "server"   // explicit server id
+	PARAM_Session  = "session"  // keep the same server for the session
+	PARAM_Ttl      = "ttl"      // session lifetime, e.g. ttl-10m
+
+	defaultSessionTtl = 10 * time.Minute
+	maxSessionTtl     = 24 * time.Hour
+	sessionSweepDelay = time.Minute
+)
+
+var usernameParams = []string{PARAM_Country, PARAM_Protocol, PARAM_Tag, PARAM_Server, PARAM_Session, PARAM_Ttl}
+
+var usernameProtocols = []string{proxyserver.PROTO_Http, proxyserver.PROTO_Socks5, proxyserver.PROTO_Ssh}
+
+// Parameters clients may append to their username, provider style:
+// alice-country-us-session-x1-ttl-10m
+type UsernameParamsConfig struct {
+	// No parameters disables parsing, the username must then match exactly
+	Allowed   []string
+	Separator string
+}
+
+func (c UsernameParamsConfig) Validate() error {
+	for _, p := range c.Allowed {
+		if !slices.Contains(usernameParams, p) {
+			return errtrace.Errorf("Unknown username parameter %q, expected one of %s", p, strings.Join(usernameParams, ", "))
+		}
+	}
+
+	if len(c.Allowed) > 0 && c.Separator == "" {
+		return errtrace.Errorf("Username parameter separator is required")
+	}
+
+	return nil
+}
+
+// Routing requested through the username
+type usernameTargeting struct {
+	Tags     []string
+	ServerId string
+	Session  string
+	Ttl      time.Duration
+}
+
+// Split the username into the user and the parameters after it. The user part
+// ends at the first allowed parameter name.
+func (c UsernameParamsConfig) parse(username string) (string, *usernameTargeting, error) {
+	if len(c.Allowed) == 0 {
+		return username, nil, nil
+	}
+
+	parts := strings.Split(username, c.Separator)
+	start := slices.IndexFunc(parts, func(p string) bool {
+		return slices.Contains(c.Allowed, strings.ToLower(p))
+	})
+	if start <= 0 {
+		return username, nil, nil
+	}
+
+	user := strings.Join(parts[:start], c.Separator)
+	params := parts[start:]
+	if len(params)%2 != 0 {
+		return "", nil, errtrace.Errorf("Username parameter %q has no value", params[len(params)-1])
+	}
+
+	t := &usernameTargeting{[]string{}, "", "", 0}
+	for i := 0; i < len(params); i += 2 {
+		name, value := strings.ToLower(params[i]), params[i+1]
+		if !slices.Contains(c.Allowed, name) {
+			return "", nil, errtrace.Errorf("Username parameter %q is not allowed", name)
+		}
+		if value == "" {
+			return "", nil, errtrace.Errorf("Username parameter %q has no value", name)
+		}
+
+		switch name {
+		case PARAM_Country:
+			t.Tags = append(t.Tags, strings.ToUpper(value))
+		case PARAM_Protocol:
+			protocol := strings.ToLower(value)
+			if !slices.Contains(usernameProtocols, protocol) {
+				return "", nil, errtrace.Errorf("Unknown protocol %q, expected one of %s", value, strings.Join(usernameProtocols, ", "))
+			}
+			t.Tags = append(t.Tags, protocol)
+		case PARAM_Tag:
+			t.Tags = append(t.Tags, value)
+		case PARAM_Server:
+			t.ServerId = value
+		case PARAM_Session:
+			t.Session = value
+		case PARAM_Ttl:
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl <= 0 || ttl > maxSessionTtl {
+				return "", nil, errtrace.Errorf("Invalid session TTL %q, expected a duration up to %s", value, maxSessionTtl)
+			}
+			t.Ttl = ttl
+		}
+	}
+
+	if t.Ttl != 0 && t.Session == "" {
+		return "", nil, errtrace.Errorf("Session TTL requires a session")
+	}
+	if t.Session != "" && t.Ttl == 0 {
+		t.Ttl = defaultSessionTtl
+	}
+
+	return user, t, nil
+}
+
+// Narrow the filter to the requested servers
+func (t *usernameTargeting) apply(filter ServerFilter) (ServerFilter, error) {
+	if t == nil {
+		return filter, nil
+	}
+
+	filter.Tags = append(slices.Clone(filter.Tags), t.Tags...)
+
+	if t.ServerId != "" {
+		if len(filter.ServerIds) > 0 && !filter.ServerIds[t.ServerId] {
+			return filter, errtrace.Errorf("Server %q is not available to this user", t.ServerId)
+		}
+		filter.ServerIds = map[string]bool{t.ServerId: true}
+	}
+
+	return filter, nil
+}
+
+type stickySession struct {
+	serverId string
+	expires  time.Time
+}
+
+// Servers kept for username sessions until their TTL passes
+type sessionTable struct {
+	mu        sync.Mutex
+	sessions  map[string]stickySession
+	lastSweep time.Time
+}
+
+func newSessionTable() *sessionTable {
+	return &sessionTable{
+		sync.Mutex{},
+		map[string]stickySession{},
+		time.Time{},
+	}
+}
+
+func sessionKey(port int, user, session string) string {
+	return strconv.Itoa(port) + "/" + user + "/" + session
+}
+
+func (t *sessionTable) get(key string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.sessions[key]
+	if !ok || time.Now().After(s.expires) {
+		return "", false
+	}
+	return s.serverId, true
+}
+
+// The TTL counts from the session start, a later tunnel does not extend it
+func (t *sessionTable) set(key, serverId string, ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.sessions[key] = stickySession{serverId, now.Add(ttl)}
+
+	if now.Sub(t.lastSweep) < sessionSweepDelay {
+		return
+	}
+	t.lastSweep = now
+	for k, s := range t.sessions {
+		if now.After(s.expires) {
+			delete(t.sessions, k)
+		}
+	}
+}
+
+// Selectable server of the session if it still matches the filter
+func (m *listenerServerManager) sessionServer(key string, filter ServerFilter) (*ManagedProxyServer, bool) {
+	id, ok := m.sessions.get(key)
+	if !ok {
+		return nil, false
+	}
+
+	return m.selectableServer(id, filter)
+}
+
+// Server of the id if it is selectable and matches the filter
+func (m *listenerServerManager) selectableServer(id string, filter ServerFilter) (*ManagedProxyServer, bool) {
+	for _, e := range *m.selection.Load() {
+		if e.id == id && e.matches(filter) {
+			return e.server, true
+		}
+	}
+	return nil, false
+}
+
+func (l *LocalListener) SetUsernameParams(params UsernameParamsConfig) error {
+	err := params.Validate()
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	l.mu.Lock()
+	l.UsernameParams = params
+	l.mu.Unlock()
+
+	Events.Publish(EVENT_ListenerChanged, strconv.Itoa(l.Port), ListenerEvent{l.Port})
+	return nil
+}
+
+func (m *listenerServerManager) SetListenerUsernameParams(port int, params UsernameParamsConfig) error {
+	l, err := m.listener(port)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	err = l.SetUsernameParams(params)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	m.requestSave()
+	return nil
+}